@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/alerts"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+type AlertRuleRequest struct {
+	Name       string  `json:"name"`
+	Registry   string  `json:"registry"`
+	Package    string  `json:"package"`
+	Filter     string  `json:"filter"`
+	Metric     string  `json:"metric"`
+	Comparison string  `json:"comparison"`
+	Threshold  float64 `json:"threshold"`
+	// Window is a duration such as "168h", needed by the change_ comparisons.
+	Window     string `json:"window"`
+	WebhookURL string `json:"webhookUrl"`
+	Email      string `json:"email"`
+}
+
+type AlertFiringResult struct {
+	Package string    `json:"package"`
+	Since   time.Time `json:"since"`
+	Value   float64   `json:"value"`
+}
+
+type AlertRuleResult struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Registry   string    `json:"registry,omitempty"`
+	Package    string    `json:"package,omitempty"`
+	Filter     string    `json:"filter,omitempty"`
+	Metric     string    `json:"metric"`
+	Comparison string    `json:"comparison"`
+	Threshold  float64   `json:"threshold"`
+	Window     string    `json:"window,omitempty"`
+	WebhookURL string    `json:"webhookUrl,omitempty"`
+	Email      string    `json:"email,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// Firing lists the packages the rule is currently firing for.
+	Firing []AlertFiringResult `json:"firing"`
+}
+
+func newAlertRuleResult(r store.AlertRule) AlertRuleResult {
+	result := AlertRuleResult{
+		ID:         r.ID,
+		Name:       r.Name,
+		Registry:   r.Registry,
+		Package:    r.Package,
+		Filter:     r.Filter,
+		Metric:     r.Metric,
+		Comparison: r.Comparison,
+		Threshold:  r.Threshold,
+		WebhookURL: r.WebhookURL,
+		Email:      r.Email,
+		CreatedAt:  r.CreatedAt,
+		Firing:     []AlertFiringResult{},
+	}
+	if r.Window > 0 {
+		result.Window = r.Window.String()
+	}
+	return result
+}
+
+func doCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var req AlertRuleRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	var rule store.AlertRule
+	if err == nil {
+		rule, err = parseAlertRule(req)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, err.Error())
+		return
+	}
+
+	rule, err = repo.AddAlertRule(r.Context(), rule)
+	if err != nil {
+		writeInternalError(w, r, "Could not add alert rule", err, zap.String("name", req.Name))
+		return
+	}
+	logger.Info("Added alert rule", zap.Int("id", rule.ID), zap.String("name", rule.Name), zap.String("ip", r.RemoteAddr))
+
+	writeJSON(w, http.StatusCreated, newAlertRuleResult(rule))
+}
+
+func parseAlertRule(req AlertRuleRequest) (store.AlertRule, error) {
+	rule := store.AlertRule{
+		Name:       req.Name,
+		Registry:   req.Registry,
+		Package:    req.Package,
+		Filter:     req.Filter,
+		Metric:     req.Metric,
+		Comparison: req.Comparison,
+		Threshold:  req.Threshold,
+		WebhookURL: req.WebhookURL,
+		Email:      req.Email,
+	}
+	if req.Window != "" {
+		window, err := time.ParseDuration(req.Window)
+		if err != nil {
+			return rule, err
+		}
+		rule.Window = window
+	}
+	if req.WebhookURL != "" {
+		err := validateWebhook(WebhookRequest{URL: req.WebhookURL})
+		if err != nil {
+			return rule, err
+		}
+	}
+	return rule, alerts.Validate(rule, cfg.SMTP.Addr != "")
+}
+
+func doListAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := repo.AlertRules(r.Context())
+	if err != nil {
+		writeInternalError(w, r, "Could not list alert rules", err)
+		return
+	}
+	firing, err := repo.FiringAlerts(r.Context())
+	if err != nil {
+		writeInternalError(w, r, "Could not list firing alerts", err)
+		return
+	}
+
+	arr := make([]AlertRuleResult, 0, len(rules))
+	index := make(map[int]int, len(rules))
+	for i, rule := range rules {
+		index[rule.ID] = i
+		arr = append(arr, newAlertRuleResult(rule))
+	}
+	for _, state := range firing {
+		if i, ok := index[state.RuleID]; ok {
+			arr[i].Firing = append(arr[i].Firing, AlertFiringResult{Package: state.Package, Since: state.Since, Value: state.Value})
+		}
+	}
+	writeJSON(w, http.StatusOK, arr)
+}
+
+func doDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(w, r)
+	if !ok {
+		return
+	}
+
+	err := repo.DeleteAlertRule(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "There's no alert rule with ID "+strconv.Itoa(id)+".")
+		return
+	} else if err != nil {
+		writeInternalError(w, r, "Could not delete alert rule", err, zap.Int("id", id))
+		return
+	}
+	logger.Info("Deleted alert rule", zap.Int("id", id), zap.String("ip", r.RemoteAddr))
+	w.WriteHeader(http.StatusNoContent)
+}
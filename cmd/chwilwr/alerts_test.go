@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestAlertRules(t *testing.T) {
+	mem := setup(t)
+	cfg.HTTP.AdminToken = "token"
+	ctx := context.Background()
+
+	for _, bad := range []string{
+		`{"name": "no target", "metric": "stars", "comparison": "above"}`,
+		`{"name": "bad metric", "metric": "karma", "comparison": "above", "email": "me@example.com"}`,
+		`{"name": "no window", "metric": "stars", "comparison": "change_above", "email": "me@example.com"}`,
+		`{"name": "bad window", "metric": "stars", "comparison": "change_above", "window": "a week", "email": "me@example.com"}`,
+		`{"name": "bad url", "metric": "stars", "comparison": "above", "webhookUrl": "/relative"}`,
+	} {
+		if w := adminRequest(http.MethodPost, "/v1/alerts", bad, "token"); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", bad, w.Code)
+		}
+	}
+
+	// Email can't be sent until an SMTP relay is configured.
+	body := `{"name": "growing", "filter": "vibe*", "metric": "downloads_weekly", "comparison": "change_above", "threshold": 50, "window": "168h", "email": "me@example.com"}`
+	if w := adminRequest(http.MethodPost, "/v1/alerts", body, "token"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an email rule to be rejected without SMTP, got %d", w.Code)
+	}
+	cfg.SMTP.Addr = "localhost:25"
+	w := adminRequest(http.MethodPost, "/v1/alerts", body, "token")
+	var created AlertRuleResult
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if w.Code != http.StatusCreated || created.ID != 1 || created.Window != "168h0m0s" {
+		t.Fatalf("expected the rule to be created, got %d %s", w.Code, w.Body.String())
+	}
+
+	mem.AddPackage(ctx, 1, "vibe-d")
+	since := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	mem.SetAlertState(ctx, store.AlertState{RuleID: 1, PackageID: 1, Firing: true, Since: since, Value: 75})
+
+	w = adminRequest(http.MethodGet, "/v1/alerts", "", "token")
+	var listed []AlertRuleResult
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed) != 1 || len(listed[0].Firing) != 1 || listed[0].Firing[0].Package != "vibe-d" || !listed[0].Firing[0].Since.Equal(since) {
+		t.Errorf("expected the rule to be listed as firing for vibe-d, got %s", w.Body.String())
+	}
+
+	if w := adminRequest(http.MethodDelete, "/v1/alerts/1", "", "token"); w.Code != http.StatusNoContent {
+		t.Errorf("expected the rule to be deleted, got %d", w.Code)
+	}
+	if w := adminRequest(http.MethodDelete, "/v1/alerts/1", "", "token"); w.Code != http.StatusNotFound {
+		t.Errorf("expected deleting it again to 404, got %d", w.Code)
+	}
+	if firing, _ := mem.FiringAlerts(ctx); len(firing) != 0 {
+		t.Errorf("expected the rule's state to be deleted with it, got %+v", firing)
+	}
+}
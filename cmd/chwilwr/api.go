@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Error codes used in ErrorBody.Code. Clients should branch on these rather than on the message.
+const (
+	CodeInvalidParameters = "invalid_parameters"
+	CodeInvalidBody       = "invalid_body"
+	CodeNotFound          = "not_found"
+	CodeUnauthorized      = "unauthorized"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeInternal          = "internal"
+	CodeTimeout           = "timeout"
+)
+
+// FieldError describes what was wrong with one query parameter or body field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID matches the X-Request-Id response header, and is logged alongside the failure.
+	RequestID string       `json:"requestId"`
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// ErrorResult is the body of every response with a 4xx or 5xx status.
+type ErrorResult struct {
+	Error ErrorBody `json:"error"`
+}
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an ID, reusing the caller's X-Request-Id when it's reasonable, and echoes it in
+// the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// deprecated marks responses from the unversioned routes, pointing clients at the same route under /v1.
+func deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "</v1"+r.URL.Path+`>; rel="successor-version"`)
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	bytes, _ := json.Marshal(v)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string, details ...FieldError) {
+	logger.Info("Request failed",
+		zap.String("requestId", requestID(r)),
+		zap.String("path", r.URL.Path),
+		zap.Int("status", status),
+		zap.String("code", code),
+		zap.String("ip", r.RemoteAddr),
+	)
+	writeJSON(w, status, ErrorResult{Error: ErrorBody{Code: code, Message: message, RequestID: requestID(r), Details: details}})
+}
+
+// writeInternalError logs the cause, along with any extra fields, but keeps it from the client.
+func writeInternalError(w http.ResponseWriter, r *http.Request, message string, err error, fields ...zap.Field) {
+	fields = append(fields, zap.String("requestId", requestID(r)), zap.String("path", r.URL.Path), zap.String("ip", r.RemoteAddr), zap.Error(err))
+	logger.Error(message, fields...)
+	writeError(w, r, http.StatusInternalServerError, CodeInternal, message)
+}
+
+// writeInvalidParameters replies with a 400 listing every bad parameter.
+func writeInvalidParameters(w http.ResponseWriter, r *http.Request, details ...FieldError) {
+	writeError(w, r, http.StatusBadRequest, CodeInvalidParameters, "One or more parameters are invalid.", details...)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, CodeNotFound, "No such endpoint.")
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" isn't supported here.")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func errorResult(t *testing.T, w *httptest.ResponseRecorder) ErrorBody {
+	t.Helper()
+	var result ErrorResult
+	err := json.Unmarshal(w.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("expected an error envelope, got %q: %v", w.Body.String(), err)
+	}
+	if result.Error.RequestID == "" || result.Error.RequestID != w.Header().Get("X-Request-Id") {
+		t.Errorf("expected the request ID to match the header, got %+v", result.Error)
+	}
+	return result.Error
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	setup(t)
+
+	w := get(t, "/v1/stats?weeks=-1", nil)
+	body := errorResult(t, w)
+	if w.Code != http.StatusBadRequest || body.Code != CodeInvalidParameters || len(body.Details) != 2 ||
+		body.Details[0].Field != "package" || body.Details[1].Field != "weeks" {
+		t.Errorf("expected both bad parameters to be listed, got %d %+v", w.Code, body)
+	}
+
+	w = get(t, "/v1/stats?package=dub&weeks=1&registry=nope", nil)
+	if body := errorResult(t, w); w.Code != http.StatusBadRequest || body.Details[0].Field != "registry" {
+		t.Errorf("expected an unknown registry to be a bad parameter, got %d %+v", w.Code, body)
+	}
+
+	w = get(t, "/v1/stats?package=unknown&weeks=1", nil)
+	if body := errorResult(t, w); w.Code != http.StatusNotFound || body.Code != CodeNotFound {
+		t.Errorf("expected an unknown package to 404, got %d %+v", w.Code, body)
+	}
+
+	w = get(t, "/v1/nothing", nil)
+	if body := errorResult(t, w); w.Code != http.StatusNotFound || body.Code != CodeNotFound {
+		t.Errorf("expected an unknown endpoint to 404, got %d %+v", w.Code, body)
+	}
+
+	w = adminRequest(http.MethodPost, "/v1/search?query=dub", "", "")
+	if body := errorResult(t, w); w.Code != http.StatusMethodNotAllowed || body.Code != CodeMethodNotAllowed {
+		t.Errorf("expected a 405, got %d %+v", w.Code, body)
+	}
+
+	// Callers can pick their own request ID to correlate with.
+	req := httptest.NewRequest(http.MethodGet, "/v1/search", nil)
+	req.Header.Set("X-Request-Id", "abc-123")
+	w = httptest.NewRecorder()
+	newRouter().ServeHTTP(w, req)
+	if body := errorResult(t, w); body.RequestID != "abc-123" || body.Details[0].Field != "query" {
+		t.Errorf("expected the given request ID to be used, got %+v", body)
+	}
+}
+
+func TestDeprecatedAliases(t *testing.T) {
+	mem := setup(t)
+	mem.AddPackage(context.Background(), 1, "dub")
+
+	w := get(t, "/v1/search?query=dub", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "" {
+		t.Errorf("expected /v1 not to be deprecated, got %d %+v", w.Code, w.Header())
+	}
+
+	w = get(t, "/search?query=dub", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" || w.Header().Get("Link") != `</v1/search>; rel="successor-version"` {
+		t.Errorf("expected the old route to point at its successor, got %d %+v", w.Code, w.Header())
+	}
+
+	// Routes added since /v1 have no unversioned alias.
+	for _, url := range []string{"/suggest?prefix=dub", "/compare?packages=dub&weeks=1", "/top", "/readme?package=dub"} {
+		if w := get(t, url, nil); w.Code != http.StatusNotFound || w.Header().Get("Deprecation") != "" {
+			t.Errorf("%s: expected 404 without /v1, got %d %+v", url, w.Code, w.Header())
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAutocompleteLimit = 10
+	maxAutocompleteLimit     = 25
+	maxPrefixLength          = 100
+)
+
+// completions caches the results of recently requested prefixes. It's nil when caching is disabled.
+var completions *completionCache
+
+type CompletionResult struct {
+	Name      string `json:"name"`
+	Registry  string `json:"registry"`
+	Downloads int64  `json:"downloads"`
+	Stars     int    `json:"stars"`
+}
+
+func doAutocomplete(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	prefix := params.Get("prefix")
+	logger.Info("Autocomplete", zap.String("prefix", prefix), zap.String("ip", r.RemoteAddr))
+
+	var details []FieldError
+	if prefix == "" {
+		details = append(details, FieldError{Field: "prefix", Message: "is required"})
+	} else if utf8.RuneCountInString(prefix) > maxPrefixLength {
+		details = append(details, FieldError{Field: "prefix", Message: "can be at most " + strconv.Itoa(maxPrefixLength) + " characters long"})
+	}
+	limit := defaultAutocompleteLimit
+	if value := params.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAutocompleteLimit {
+			details = append(details, FieldError{Field: "limit", Message: "must be a whole number from 1 to " + strconv.Itoa(maxAutocompleteLimit)})
+		}
+	}
+	if len(details) > 0 {
+		writeInvalidParameters(w, r, details...)
+		return
+	}
+
+	// Every registry is searched unless one is asked for.
+	registryID := 0
+	if name := params.Get("registry"); name != "" {
+		reg, ok := lookupRegistry(w, r, name)
+		if !ok {
+			return
+		}
+		registryID = reg.ID
+	}
+
+	// Results are looked up and cached at the maximum limit, so every limit shares the same cache entry.
+	prefix = strings.ToLower(prefix)
+	results, ok := completions.get(registryID, prefix)
+	if !ok {
+		ctx := r.Context()
+		if timeout := cfg.Autocomplete.Timeout.Duration; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		var err error
+		results, err = repo.Autocomplete(ctx, registryID, prefix, maxAutocompleteLimit)
+		// The context is checked rather than err, as lib/pq reports a cancelled query as its own error.
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// An autocomplete that arrives late is no use to anyone, so the client is told to carry on without it.
+			logger.Warn("Autocomplete timed out", zap.String("prefix", prefix), zap.String("requestId", requestID(r)))
+			writeError(w, r, http.StatusServiceUnavailable, CodeTimeout, "Autocomplete took too long, try again shortly.")
+			return
+		} else if err != nil {
+			writeInternalError(w, r, "Autocomplete failed", err, zap.String("prefix", prefix))
+			return
+		}
+		completions.put(registryID, prefix, results)
+	}
+
+	if limit < len(results) {
+		results = results[:limit]
+	}
+	arr := make([]CompletionResult, 0, len(results))
+	for _, result := range results {
+		arr = append(arr, CompletionResult{
+			Name:      result.Name,
+			Registry:  result.Registry,
+			Downloads: result.Downloads,
+			Stars:     result.Stars,
+		})
+	}
+	writeJSON(w, http.StatusOK, arr)
+}
+
+// completionCache is a least recently used cache of autocomplete results, so popular prefixes stay in memory while
+// one-off ones are evicted. Entries also expire after a while, so new packages and downloads show up eventually.
+type completionCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[completionKey]*list.Element
+}
+
+type completionKey struct {
+	registryID int
+	prefix     string
+}
+
+type completionEntry struct {
+	key     completionKey
+	results []store.SearchResult
+	expires time.Time
+}
+
+// newCompletionCache returns nil if size isn't positive, which disables caching.
+func newCompletionCache(size int, ttl time.Duration) *completionCache {
+	if size <= 0 {
+		return nil
+	}
+	return &completionCache{size: size, ttl: ttl, order: list.New(), entries: map[completionKey]*list.Element{}}
+}
+
+func (c *completionCache) get(registryID int, prefix string) ([]store.SearchResult, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[completionKey{registryID, prefix}]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*completionEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, entry.key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.results, true
+}
+
+func (c *completionCache) put(registryID int, prefix string, results []store.SearchResult) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := completionKey{registryID, prefix}
+	entry := &completionEntry{key: key, results: results, expires: time.Now().Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*completionEntry).key)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/config"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+// slowStore's autocomplete waits until its context is done, then fails the way lib/pq does for a cancelled query.
+type slowStore struct {
+	store.Store
+}
+
+func (slowStore) Autocomplete(ctx context.Context, _ int, _ string, _ int) ([]store.SearchResult, error) {
+	<-ctx.Done()
+	return nil, errors.New("pq: canceling statement due to user request")
+}
+
+func TestAutocomplete(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	now := time.Now()
+	for i, name := range []string{"vibe-d", "vibe-core", "Vibrant", "dub"} {
+		mem.AddPackage(ctx, 1, name)
+		ver, _ := mem.EnsureVersion(ctx, i+1, "1.0.0")
+		mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now.Add(-time.Hour), DownloadsTotal: int64(i * 10)})
+	}
+
+	// Case is ignored, and the most downloaded come first.
+	var results []CompletionResult
+	w := get(t, "/v1/suggest?prefix=VIB", &results)
+	if w.Code != http.StatusOK || len(results) != 3 || results[0].Name != "Vibrant" || results[0].Downloads != 20 || results[2].Name != "vibe-d" {
+		t.Errorf("expected the vib packages by downloads, got %d %+v", w.Code, results)
+	}
+	get(t, "/v1/suggest?prefix=vib&limit=1", &results)
+	if len(results) != 1 || results[0].Name != "Vibrant" {
+		t.Errorf("expected only the most downloaded, got %+v", results)
+	}
+	get(t, "/v1/suggest?prefix=%25", &results)
+	if len(results) != 0 {
+		t.Errorf("expected %% to be matched literally, got %+v", results)
+	}
+
+	for _, url := range []string{
+		"/v1/suggest",
+		"/v1/suggest?prefix=vib&limit=0",
+		"/v1/suggest?prefix=vib&limit=26",
+		"/v1/suggest?prefix=vib&registry=nope",
+	} {
+		if w := get(t, url, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", url, w.Code)
+		}
+	}
+}
+
+func TestAutocompleteTimeout(t *testing.T) {
+	repo = slowStore{setup(t)}
+	cfg.Autocomplete.Timeout = config.Duration{Duration: time.Millisecond}
+
+	w := get(t, "/v1/suggest?prefix=vib", nil)
+	if body := errorResult(t, w); w.Code != http.StatusServiceUnavailable || body.Code != CodeTimeout {
+		t.Errorf("expected a timeout, got %d %+v", w.Code, body)
+	}
+}
+
+func TestAutocompleteCache(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	completions = newCompletionCache(10, time.Hour)
+	t.Cleanup(func() { completions = nil })
+	mem.AddPackage(ctx, 1, "vibe-d")
+
+	var results []CompletionResult
+	get(t, "/v1/suggest?prefix=vibe", &results)
+	mem.AddPackage(ctx, 1, "vibe-core")
+	get(t, "/v1/suggest?prefix=Vibe", &results)
+	if len(results) != 1 {
+		t.Errorf("expected the cached results, got %+v", results)
+	}
+
+	completions = newCompletionCache(10, 0)
+	get(t, "/v1/suggest?prefix=vibe", &results)
+	if len(results) != 2 {
+		t.Errorf("expected expired results to be looked up again, got %+v", results)
+	}
+}
+
+func TestCompletionCacheEviction(t *testing.T) {
+	c := newCompletionCache(2, time.Hour)
+	for i := 0; i < 3; i++ {
+		c.put(0, fmt.Sprint(i), nil)
+		if i == 1 {
+			// Using 0 again makes 1 the least recently used.
+			c.get(0, "0")
+		}
+	}
+	for prefix, want := range map[string]bool{"0": true, "1": false, "2": true} {
+		if _, ok := c.get(0, prefix); ok != want {
+			t.Errorf("%s: expected cached to be %v", prefix, want)
+		}
+	}
+
+	if newCompletionCache(0, time.Hour) != nil {
+		t.Error("expected a size of 0 to disable the cache")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+// maxComparePackages keeps /compare to a handful of series, which is all a chart can show anyway.
+const maxComparePackages = 10
+
+type CompareResult struct {
+	Resolution store.Resolution `json:"resolution"`
+	// Times is the shared time axis: the start of every bucket in the range, oldest first.
+	Times    []time.Time            `json:"times"`
+	Packages []ComparePackageResult `json:"packages"`
+}
+
+type ComparePackageResult struct {
+	Package string `json:"package"`
+	// Series has a point for each of Times, which is null for buckets without any stats.
+	Series []*StatsResult `json:"series"`
+	Growth CompareGrowth  `json:"growth"`
+}
+
+// CompareGrowth is how much each metric changed between the first and last points of the series, relative to the
+// first, so 0.5 means it grew by half. Metrics that started at 0, or series with fewer than two points, are null.
+type CompareGrowth struct {
+	DownloadsTotal   *float64 `json:"downloadsTotal"`
+	DownloadsMonthly *float64 `json:"downloadsMonthly"`
+	Stars            *float64 `json:"stars"`
+	Forks            *float64 `json:"forks"`
+}
+
+func doCompare(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	logger.Info("Compare", zap.String("packages", query.Get("packages")), zap.String("weeks", query.Get("weeks")), zap.String("from", query.Get("from")), zap.String("to", query.Get("to")), zap.String("ip", r.RemoteAddr))
+
+	var details []FieldError
+	names, err := parseComparePackages(query.Get("packages"))
+	if err != nil {
+		details = append(details, FieldError{Field: "packages", Message: err.Error()})
+	}
+	now := time.Now()
+	from, to, rangeDetails := parseStatsRange(query, now)
+	details = append(details, rangeDetails...)
+	resolution, by, seriesDetails := parseStatsSeries(query, from, to, now)
+	if len(rangeDetails) == 0 {
+		details = append(details, seriesDetails...)
+	}
+	// Raw snapshots are taken at different times for each package, so they can't share an axis.
+	if resolution == store.Raw {
+		if query.Get("resolution") != "" && len(seriesDetails) == 0 {
+			details = append(details, FieldError{Field: "resolution", Message: "must be day, week or month"})
+		}
+		resolution = store.Daily
+	}
+	if len(details) > 0 {
+		writeInvalidParameters(w, r, details...)
+		return
+	}
+
+	packages := make([]store.Package, 0, len(names))
+	for _, name := range names {
+		p, ok := lookupPackage(w, r, name)
+		if !ok {
+			return
+		}
+		packages = append(packages, p)
+	}
+
+	result := CompareResult{Resolution: resolution, Times: []time.Time{}, Packages: make([]ComparePackageResult, 0, len(packages))}
+	// The first bucket is the one containing from, and is included whole so that every point covers a full bucket.
+	start := resolution.Truncate(from)
+	axis := make(map[time.Time]int)
+	for bucket := start; bucket.Before(to); bucket = resolution.Next(bucket) {
+		axis[bucket] = len(result.Times)
+		result.Times = append(result.Times, bucket)
+	}
+
+	for _, p := range packages {
+		snapshots, err := statsSeries(r.Context(), store.SnapshotQuery{PackageID: p.ID, Since: start, Until: to, Resolution: resolution}, by)
+		var versions []store.Version
+		if err == nil {
+			versions, err = repo.Versions(r.Context(), p.ID)
+		}
+		if err != nil {
+			writeInternalError(w, r, "Query failed", err, zap.String("package", p.Name))
+			return
+		}
+		semvers := make(map[int]string, len(versions))
+		for _, ver := range versions {
+			semvers[ver.ID] = ver.Semver
+		}
+
+		series := make([]*StatsResult, len(result.Times))
+		var first, last *StatsResult
+		for _, s := range snapshots {
+			i, ok := axis[s.Time]
+			if !ok {
+				continue
+			}
+			series[i] = &StatsResult{
+				Time:             s.Time,
+				Version:          semvers[s.VersionID],
+				DownloadsWeekly:  s.DownloadsWeekly,
+				DownloadsMonthly: s.DownloadsMonthly,
+				DownloadsTotal:   s.DownloadsTotal,
+				Stars:            s.Stars,
+				Watchers:         s.Watchers,
+				Issues:           s.Issues,
+				Forks:            s.Forks,
+				Samples:          s.Samples,
+			}
+			if first == nil {
+				first = series[i]
+			}
+			last = series[i]
+		}
+		result.Packages = append(result.Packages, ComparePackageResult{Package: p.Name, Series: series, Growth: growth(first, last)})
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// parseComparePackages splits the comma separated package names, which must be distinct.
+func parseComparePackages(value string) ([]string, error) {
+	if value == "" {
+		return nil, errors.New("is required")
+	}
+	names := strings.Split(value, ",")
+	if len(names) > maxComparePackages {
+		return nil, fmt.Errorf("can name at most %d packages", maxComparePackages)
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" {
+			return nil, errors.New("can't contain an empty name")
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("names %q more than once", name)
+		}
+		seen[name] = true
+	}
+	return names, nil
+}
+
+func growth(first, last *StatsResult) CompareGrowth {
+	if first == nil || first == last {
+		return CompareGrowth{}
+	}
+	return CompareGrowth{
+		DownloadsTotal:   relativeChange(float64(first.DownloadsTotal), float64(last.DownloadsTotal)),
+		DownloadsMonthly: relativeChange(float64(first.DownloadsMonthly), float64(last.DownloadsMonthly)),
+		Stars:            relativeChange(float64(first.Stars), float64(last.Stars)),
+		Forks:            relativeChange(float64(first.Forks), float64(last.Forks)),
+	}
+}
+
+func relativeChange(from, to float64) *float64 {
+	if from == 0 {
+		return nil
+	}
+	change := (to - from) / from
+	return &change
+}
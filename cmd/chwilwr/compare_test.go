@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestCompare(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "vibe-d")
+	mem.AddPackage(ctx, 1, "hunt")
+	vibe, _ := mem.EnsureVersion(ctx, 1, "1.0.0")
+	hunt, _ := mem.EnsureVersion(ctx, 2, "1.0.0")
+
+	// vibe-d has stats for three days, at different times of day to hunt, which only has the last two. Nothing has been
+	// taken yet today.
+	day := time.Now().UTC().AddDate(0, 0, -3).Truncate(time.Hour * 24)
+	for i := 0; i < 3; i++ {
+		mem.AddSnapshot(ctx, store.Snapshot{VersionID: vibe.ID, Time: day.Add(time.Hour * time.Duration(24*i+1)), DownloadsTotal: int64(100 * (i + 1)), Stars: 10})
+		if i > 0 {
+			mem.AddSnapshot(ctx, store.Snapshot{VersionID: hunt.ID, Time: day.Add(time.Hour * time.Duration(24*i+13)), DownloadsTotal: int64(10 * i)})
+		}
+	}
+
+	var result CompareResult
+	w := get(t, "/v1/compare?packages=vibe-d,hunt&from=-3d&resolution=day", &result)
+	if w.Code != http.StatusOK || result.Resolution != store.Daily || len(result.Times) != 4 || len(result.Packages) != 2 {
+		t.Fatalf("expected two series over four days, got %d %+v", w.Code, result)
+	}
+	vibeSeries, huntSeries := result.Packages[0].Series, result.Packages[1].Series
+	if len(vibeSeries) != 4 || vibeSeries[0] == nil || !vibeSeries[0].Time.Equal(day) || vibeSeries[2].DownloadsTotal != 300 || vibeSeries[3] != nil {
+		t.Errorf("expected vibe-d to fill the first three buckets, got %+v", vibeSeries)
+	}
+	if len(huntSeries) != 4 || huntSeries[0] != nil || huntSeries[1] == nil || huntSeries[2].DownloadsTotal != 20 || huntSeries[3] != nil {
+		t.Errorf("expected hunt to be aligned with vibe-d, got %+v", huntSeries)
+	}
+
+	growth := result.Packages[0].Growth
+	if growth.DownloadsTotal == nil || *growth.DownloadsTotal != 2 || growth.Stars == nil || *growth.Stars != 0 || growth.Forks != nil {
+		t.Errorf("expected vibe-d's downloads to have tripled and its stars to be flat, got %+v", growth)
+	}
+	if growth := result.Packages[1].Growth; growth.DownloadsTotal == nil || *growth.DownloadsTotal != 1 {
+		t.Errorf("expected hunt's downloads to have doubled, got %+v", growth)
+	}
+
+	if w := get(t, "/v1/compare?packages=vibe-d,nope&weeks=1", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown package to 404, got %d", w.Code)
+	}
+	for url, field := range map[string]string{
+		"/v1/compare?weeks=1":                                "packages",
+		"/v1/compare?packages=hunt,hunt&weeks=1":             "packages",
+		"/v1/compare?packages=hunt,&weeks=1":                 "packages",
+		"/v1/compare?packages=a,b,c,d,e,f,g,h,i,j,k&weeks=1": "packages",
+		"/v1/compare?packages=hunt&weeks=1&resolution=raw":   "resolution",
+		"/v1/compare?packages=hunt&from=2022&to=2021":        "from",
+	} {
+		w := get(t, url, nil)
+		if body := errorResult(t, w); w.Code != http.StatusBadRequest || len(body.Details) != 1 || body.Details[0].Field != field {
+			t.Errorf("%s: expected a bad %s, got %d %+v", url, field, w.Code, body)
+		}
+	}
+}
@@ -2,13 +2,19 @@ module github.com/BradleyChatha/ystadegau/chwilwr
 
 go 1.17
 
+require (
+	github.com/BradleyChatha/ystadegau/pkg v0.0.0
+	github.com/gorilla/mux v1.8.0
+	github.com/lib/pq v1.10.3
+	github.com/rs/cors v1.8.0
+	go.uber.org/zap v1.19.1
+)
+
 require (
 	github.com/aws/aws-sdk-go v1.41.1 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	github.com/lib/pq v1.10.3 // indirect
-	github.com/rs/cors v1.8.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	go.uber.org/zap v1.19.1 // indirect
 )
+
+replace github.com/BradleyChatha/ystadegau/pkg => ../../pkg
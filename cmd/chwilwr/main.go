@@ -1,17 +1,16 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/BradleyChatha/ystadegau/pkg/config"
+	"github.com/BradleyChatha/ystadegau/pkg/db"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 	"github.com/rs/cors"
@@ -19,70 +18,24 @@ import (
 )
 
 var logger *zap.Logger
-var conn *sql.DB
-
-func init() {
-	ses, err := session.NewSession(&aws.Config{
-		Region: aws.String("eu-west-2"),
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	sesh := ssm.New(ses)
-	ssmhost, err := sesh.GetParameter(&ssm.GetParameterInput{
-		Name: aws.String("db_url"),
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Print("Got db_url")
-	ssmuser, err := sesh.GetParameter(&ssm.GetParameterInput{
-		Name: aws.String("db_lambda_user"),
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-	ssmpass, err := sesh.GetParameter(&ssm.GetParameterInput{
-		Name:           aws.String("db_lambda_pass"),
-		WithDecryption: aws.Bool(true),
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	host := *ssmhost.Parameter.Value
-	user := *ssmuser.Parameter.Value
-	pass := *ssmpass.Parameter.Value
-
-	connStr := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=%s", user, pass, host, "dubstats", "require")
-	conn, err = sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatal("Could not connect to database", zap.Error(err))
-	}
-	err = conn.Ping()
-	if err != nil {
-		log.Fatal("Could not connect to database", zap.Error(err))
-	}
-}
-
-//
-type QueryResult struct {
-	Id   int     `json:"id"`
-	Name string  `json:"name"`
-	Rank float64 `json:"rank"`
-}
+var repo store.Store
+var cfg *config.Config
 
 //
 type StatsResult struct {
 	Time             time.Time `json:"time"`
+	Version          string    `json:"version"`
 	DownloadsWeekly  int       `json:"downloadsWeekly"`
 	DownloadsMonthly int       `json:"downloadsMonthly"`
-	DownloadsTotal   int       `json:"downloadsTotal"`
+	DownloadsTotal   int64     `json:"downloadsTotal"`
 	Stars            int       `json:"stars"`
 	Watchers         int       `json:"watchers"`
 	Issues           int       `json:"issues"`
 	Forks            int       `json:"forks"`
+	Flagged          bool      `json:"flagged,omitempty"`
+	FlagReason       string    `json:"flagReason,omitempty"`
+	Samples          int       `json:"samples,omitempty"`
+	DownloadsPerDay  *float64  `json:"downloadsPerDay,omitempty"`
 }
 
 func main() {
@@ -92,96 +45,107 @@ func main() {
 		log.Fatal(err)
 	}
 
+	cfg, err = config.LoadDefault()
+	if err != nil {
+		logger.Fatal("Could not load config", zap.Error(err))
+	}
+
+	pool, err := db.Open(context.Background(), cfg, logger)
+	if err != nil {
+		logger.Fatal("Could not connect to database", zap.Error(err))
+	}
+	defer pool.Close()
+	go pool.Watch(context.Background(), cfg.Secrets.RefreshInterval.Duration)
+	repo = store.NewPostgres(pool)
+	completions = newCompletionCache(cfg.Autocomplete.CacheSize, cfg.Autocomplete.CacheTTL.Duration)
+
 	httpMain()
 }
 
 func httpMain() {
+	err := http.ListenAndServe(cfg.HTTP.Listen, newRouter())
+	if err != nil {
+		logger.Fatal("HTTP server stopped", zap.Error(err))
+	}
+}
+
+// legacyRoutes are the routes that predate /v1, which are kept as unversioned aliases for existing clients, along with
+// the handler that keeps each one's original response. Newer routes only exist under /v1.
+var legacyRoutes = map[string]http.HandlerFunc{"/search": doLegacySearch, "/stats": doStats}
+
+func newRouter() http.Handler {
 	r := mux.NewRouter()
-	r.Path("/search").Methods("GET").Queries("query", "{query}").HandlerFunc(doSearch)
-	r.Path("/stats").Methods("GET").Queries("package", "{package}", "weeks", "{weeks}").HandlerFunc(doStats)
+	v1 := r.PathPrefix("/v1").Subrouter()
+	for _, route := range []struct {
+		path    string
+		method  string
+		handler http.HandlerFunc
+	}{
+		{"/search", "GET", doSearch},
+		{"/suggest", "GET", doAutocomplete},
+		{"/stats", "GET", doStats},
+		{"/compare", "GET", doCompare},
+		{"/top", "GET", doTop},
+		{"/readme", "GET", doReadme},
+		{"/readme/diff", "GET", doReadmeDiff},
+		{"/webhooks", "GET", requireAdmin(doListWebhooks)},
+		{"/webhooks", "POST", requireAdmin(doCreateWebhook)},
+		{"/webhooks/{id:[0-9]+}", "DELETE", requireAdmin(doDeleteWebhook)},
+		{"/alerts", "GET", requireAdmin(doListAlertRules)},
+		{"/alerts", "POST", requireAdmin(doCreateAlertRule)},
+		{"/alerts/{id:[0-9]+}", "DELETE", requireAdmin(doDeleteAlertRule)},
+	} {
+		v1.Path(route.path).Methods(route.method).HandlerFunc(route.handler)
+		if legacy, ok := legacyRoutes[route.path]; ok {
+			r.Path(route.path).Methods(route.method).HandlerFunc(deprecated(legacy))
+		}
+	}
+	for _, router := range []*mux.Router{r, v1} {
+		router.NotFoundHandler = http.HandlerFunc(notFound)
+		router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	}
 
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
+		ExposedHeaders: []string{"X-Request-Id", "X-Stats-Resolution", "X-Total-Count", "X-Next-Cursor", "X-Search-Suggestion", "Deprecation", "Link"},
 	})
-	http.ListenAndServe(":5678", c.Handler(r))
+	return c.Handler(withRequestID(r))
 }
 
-func doSearch(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	query := vars["query"]
-	logger.Info("Query", zap.String("query", query), zap.String("ip", r.RemoteAddr))
-
-	rows, err := conn.Query("SELECT id, name, rank FROM search_packages($1);", query)
-	if err != nil {
-		logger.Error("Query failed", zap.String("query", query), zap.String("ip", r.RemoteAddr), zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// lookupRegistry finds the registry with the given name. If it doesn't exist, or the lookup fails, then the response
+// has already been written and false is returned.
+func lookupRegistry(w http.ResponseWriter, r *http.Request, name string) (store.Registry, bool) {
+	reg, err := repo.RegistryByName(r.Context(), name)
+	if errors.Is(err, store.ErrNotFound) {
+		writeInvalidParameters(w, r, FieldError{Field: "registry", Message: "there's no registry named " + strconv.Quote(name)})
+		return reg, false
+	} else if err != nil {
+		writeInternalError(w, r, "Registry lookup failed", err, zap.String("registry", name))
+		return reg, false
 	}
-
-	arr := make([]QueryResult, 0, 50)
-	for rows.Next() {
-		var value QueryResult
-		err := rows.Scan(&value.Id, &value.Name, &value.Rank)
-		if err != nil {
-			logger.Error("Failed to scan a row", zap.String("query", query), zap.String("ip", r.RemoteAddr), zap.Error(err))
-			continue
-		}
-
-		arr = append(arr, value)
-	}
-
-	bytes, _ := json.Marshal(arr)
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(bytes)
+	return reg, true
 }
 
-func doStats(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	pkg := vars["package"]
-	weeks := vars["weeks"]
-	logger.Info("Stats", zap.String("package", pkg), zap.String("weeks", weeks), zap.String("ip", r.RemoteAddr))
-
-	weeksAsNum, err := strconv.Atoi(weeks)
-	if err != nil {
-		logger.Error("User provided a bad week value", zap.String("package", pkg), zap.String("weeks", weeks), zap.String("ip", r.RemoteAddr), zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
-		return
+// lookupPackage finds the package in the registry named by the request, or the main registry. If it doesn't exist, or
+// the lookup fails, then the response has already been written and false is returned.
+func lookupPackage(w http.ResponseWriter, r *http.Request, name string) (store.Package, bool) {
+	// Package names are only unique within a registry, so the main registry is assumed unless another is given.
+	registryName := r.URL.Query().Get("registry")
+	if registryName == "" {
+		registryName = cfg.Registry.Name
 	}
-
-	rows, err := conn.Query(`
-		SELECT time, downloads_weekly, downloads_monthly, downloads_total, stars, watchers, issues, forks FROM package_snapshot 
-		WHERE package_version_id = 
-			(
-				SELECT id FROM package_version 
-				WHERE package_id = 
-				(
-					SELECT id FROM package
-					WHERE name = $1
-				)
-				ORDER BY id DESC
-				LIMIT 1
-			) 
-		AND time >= (now() - interval '7 days' * $2);`, pkg, weeksAsNum)
-	if err != nil {
-		logger.Error("Query failed", zap.String("package", pkg), zap.String("weeks", weeks), zap.String("ip", r.RemoteAddr), zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	reg, ok := lookupRegistry(w, r, registryName)
+	if !ok {
+		return store.Package{}, false
 	}
 
-	arr := make([]StatsResult, 0, weeksAsNum)
-	for rows.Next() {
-		var value StatsResult
-		err = rows.Scan(&value.Time, &value.DownloadsWeekly, &value.DownloadsMonthly, &value.DownloadsTotal, &value.Stars, &value.Watchers, &value.Issues, &value.Forks)
-		if err != nil {
-			logger.Error("Error scanning row", zap.String("package", pkg), zap.String("weeks", weeks), zap.String("ip", r.RemoteAddr), zap.Error(err))
-			continue
-		}
-		arr = append(arr, value)
+	p, err := repo.PackageByName(r.Context(), reg.ID, name)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "There's no package named "+strconv.Quote(name)+" in the "+reg.Name+" registry.")
+		return p, false
+	} else if err != nil {
+		writeInternalError(w, r, "Package lookup failed", err, zap.String("package", name))
+		return p, false
 	}
-
-	bytes, _ := json.Marshal(arr)
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(bytes)
+	return p, true
 }
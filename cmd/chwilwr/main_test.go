@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/config"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+// setup points the handlers at a fresh in-memory store, returning it so the test can fill it in.
+func setup(t *testing.T) *store.Memory {
+	t.Helper()
+	mem := store.NewMemory()
+	repo = mem
+	logger = zap.NewNop()
+	cfg = &config.Config{Registry: config.Registry{Name: "dub"}}
+	completions = nil
+	mem.EnsureRegistry(context.Background(), "dub", "https://code.dlang.org")
+	return mem
+}
+
+func get(t *testing.T, url string, result interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if result != nil && w.Code == http.StatusOK {
+		err := json.Unmarshal(w.Body.Bytes(), result)
+		if err != nil {
+			t.Fatalf("could not decode response %q: %v", w.Body.String(), err)
+		}
+	}
+	return w
+}
+
+func TestSearch(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "vibe-d")
+	mem.AddPackage(ctx, 1, "vibe-core")
+	mem.AddPackage(ctx, 1, "dub")
+	mem.UpdateSearchText(ctx, 3, "Package manager for D", "")
+
+	var results []QueryResult
+	w := get(t, "/search?query=vibe-d", &results)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	// vibe-core is close enough to match fuzzily, but far behind the exact match.
+	if len(results) != 2 || results[0].Name != "vibe-d" || results[0].Rank != 11 || results[1].Name != "vibe-core" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	get(t, "/search?query=manager", &results)
+	if len(results) != 1 || results[0].Name != "dub" {
+		t.Errorf("expected a full text match on dub, got %+v", results)
+	}
+
+	w = get(t, "/search?query=nothing", &results)
+	if w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Errorf("expected an empty array, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestStats(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "dub")
+	old, _ := mem.EnsureVersion(ctx, 1, "1.0.0")
+	latest, _ := mem.EnsureVersion(ctx, 1, "1.1.0")
+
+	now := time.Now()
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: old.ID, Time: now.Add(-time.Hour), DownloadsTotal: 1})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: latest.ID, Time: now.Add(-time.Hour * 24 * 10), DownloadsTotal: 2})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: latest.ID, Time: now.Add(-time.Hour), DownloadsTotal: 3, Stars: 4})
+
+	var results []StatsResult
+	w := get(t, "/stats?package=dub&weeks=1&version=latest", &results)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if len(results) != 1 || results[0].DownloadsTotal != 3 || results[0].Stars != 4 {
+		t.Errorf("expected only the recent snapshot of the latest version, got %+v", results)
+	}
+
+	get(t, "/stats?package=dub&weeks=2&version=latest", &results)
+	if len(results) != 2 || results[0].DownloadsTotal != 2 {
+		t.Errorf("expected both snapshots of the latest version, oldest first, got %+v", results)
+	}
+}
+
+func TestStatsLatestVersion(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "dub")
+
+	// Added out of order, as happens when a fix is backported to an older release.
+	now := time.Now()
+	for i, v := range []string{"1.1.0", "2.0.0-beta.1", "1.0.1", "~master"} {
+		ver, _ := mem.EnsureVersion(ctx, 1, v)
+		mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now.Add(-time.Hour), DownloadsTotal: int64(i)})
+	}
+
+	var results []StatsResult
+	get(t, "/stats?package=dub&weeks=1&version=latest", &results)
+	if len(results) != 1 || results[0].DownloadsTotal != 0 {
+		t.Errorf("expected the snapshots of 1.1.0, got %+v", results)
+	}
+
+	get(t, "/stats?package=dub&weeks=1&version=latest&prerelease=include", &results)
+	if len(results) != 1 || results[0].DownloadsTotal != 1 {
+		t.Errorf("expected the snapshots of 2.0.0-beta.1, got %+v", results)
+	}
+
+	// Once 2.0.0 is out, it's the latest even with prereleases included, but its beta can still be asked for.
+	ver, _ := mem.EnsureVersion(ctx, 1, "2.0.0")
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now.Add(-time.Hour), DownloadsTotal: 4})
+	get(t, "/stats?package=dub&weeks=1&version=latest&prerelease=include", &results)
+	if len(results) != 1 || results[0].DownloadsTotal != 4 {
+		t.Errorf("expected the snapshots of 2.0.0, got %+v", results)
+	}
+	get(t, "/stats?package=dub&weeks=1&version=latest-prerelease", &results)
+	if len(results) != 1 || results[0].DownloadsTotal != 1 {
+		t.Errorf("expected the snapshots of 2.0.0-beta.1, got %+v", results)
+	}
+}
+
+func TestStatsFlagged(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "dub")
+	ver, _ := mem.EnsureVersion(ctx, 1, "1.0.0")
+
+	now := time.Now()
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now.Add(-time.Hour * 2), DownloadsTotal: 5})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now.Add(-time.Hour), DownloadsTotal: 1, FlagReason: "went backwards"})
+
+	var results []StatsResult
+	get(t, "/stats?package=dub&weeks=1", &results)
+	if len(results) != 1 || results[0].Flagged {
+		t.Errorf("expected flagged snapshots to be left out by default, got %+v", results)
+	}
+
+	get(t, "/stats?package=dub&weeks=1&flagged=include", &results)
+	if len(results) != 2 || !results[1].Flagged || results[1].FlagReason != "went backwards" {
+		t.Errorf("expected the flagged snapshot to be included and marked, got %+v", results)
+	}
+}
+
+func TestRegistryParameter(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	private, _ := mem.EnsureRegistry(ctx, "private", "https://dub.example.com")
+	mem.AddPackage(ctx, 1, "dub")
+	mem.AddPackage(ctx, private.ID, "dub")
+	ver, _ := mem.EnsureVersion(ctx, 2, "1.0.0")
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: time.Now(), DownloadsTotal: 7})
+
+	var results []QueryResult
+	get(t, "/search?query=dub", &results)
+	if len(results) != 2 {
+		t.Errorf("expected a result from each registry, got %+v", results)
+	}
+	get(t, "/search?query=dub&registry=private", &results)
+	if len(results) != 1 || results[0].Registry != "private" || results[0].Id != 2 {
+		t.Errorf("expected only the private package, got %+v", results)
+	}
+
+	var stats []StatsResult
+	get(t, "/stats?package=dub&weeks=1", &stats)
+	if len(stats) != 0 {
+		t.Errorf("expected the main registry's package to have no stats, got %+v", stats)
+	}
+	get(t, "/stats?package=dub&weeks=1&registry=private", &stats)
+	if len(stats) != 1 || stats[0].DownloadsTotal != 7 {
+		t.Errorf("expected the private package's stats, got %+v", stats)
+	}
+
+	for _, url := range []string{"/search?query=dub&registry=nope", "/stats?package=dub&weeks=1&registry=nope"} {
+		if w := get(t, url, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400 for an unknown registry, got %d", url, w.Code)
+		}
+	}
+}
+
+func TestStatsChangeOnly(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "dub")
+	ver, _ := mem.EnsureVersion(ctx, 1, "1.0.0")
+
+	// One row that stayed the same for three weekly updates, as written by change-only storage.
+	now := time.Now()
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now.Add(-store.UpdateInterval * 3), ValidTo: now.Add(-store.UpdateInterval), DownloadsTotal: 5})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now, DownloadsTotal: 8})
+
+	var results []StatsResult
+	get(t, "/stats?package=dub&weeks=4", &results)
+	if len(results) != 4 || results[2].DownloadsTotal != 5 || results[3].DownloadsTotal != 8 {
+		t.Errorf("expected the unchanged weeks to be filled back in, got %+v", results)
+	}
+	get(t, "/stats?package=dub&weeks=2", &results)
+	if len(results) != 2 || results[0].DownloadsTotal != 5 {
+		t.Errorf("expected only the weeks within the range, got %+v", results)
+	}
+}
+
+func TestStatsDownloadsPerDay(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "dub")
+	ver, _ := mem.EnsureVersion(ctx, 1, "1.0.0")
+
+	today := store.Daily.Truncate(time.Now())
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: today.Add(-time.Hour * 24), DownloadsTotal: 10})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: today, DownloadsTotal: 40})
+	mem.AddDailyDownloads(ctx, 1, store.SpreadDownloads(today.Add(-time.Hour*24), today, 30))
+
+	var results []StatsResult
+	get(t, "/stats?package=dub&weeks=1", &results)
+	if len(results) != 2 || results[0].DownloadsPerDay == nil || *results[0].DownloadsPerDay != 30 || results[1].DownloadsPerDay != nil {
+		t.Errorf("expected 30 downloads per day for yesterday only, got %+v", results)
+	}
+}
+
+func TestStatsResolution(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "dub")
+	ver, _ := mem.EnsureVersion(ctx, 1, "1.0.0")
+
+	// A snapshot every three days for the past two years.
+	now := time.Now()
+	for i := 0; i < 240; i++ {
+		mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now.Add(-time.Hour * 24 * 3 * time.Duration(i)), DownloadsTotal: int64(240 - i)})
+	}
+
+	// Before the rollups exist, the raw snapshots are bucketed on the fly.
+	var results []StatsResult
+	w := get(t, "/stats?package=dub&weeks=104", &results)
+	if w.Header().Get("X-Stats-Resolution") != "weekly" || len(results) < 100 || len(results) > 106 {
+		t.Errorf("expected a point per week while there are no rollups, got %s and %d results", w.Header().Get("X-Stats-Resolution"), len(results))
+	}
+	for _, result := range results {
+		if !store.Weekly.Truncate(result.Time).Equal(result.Time) || result.Samples < 1 {
+			t.Errorf("expected weekly buckets made from raw snapshots, got %+v", result)
+			break
+		}
+	}
+
+	for _, resolution := range store.Rollups {
+		mem.Rollup(ctx, resolution)
+	}
+	for _, test := range []struct {
+		weeks      string
+		resolution string
+		max        int
+	}{
+		{"4", "raw", 10},
+		{"26", "daily", 62},
+		{"104", "weekly", 106},
+		{"520", "monthly", 26},
+	} {
+		w := get(t, "/stats?package=dub&weeks="+test.weeks, &results)
+		if got := w.Header().Get("X-Stats-Resolution"); got != test.resolution {
+			t.Errorf("%s weeks: expected %s resolution, got %s", test.weeks, test.resolution, got)
+		}
+		if len(results) == 0 || len(results) > test.max || results[len(results)-1].DownloadsTotal != 240 {
+			t.Errorf("%s weeks: expected at most %d results ending with the latest total, got %d", test.weeks, test.max, len(results))
+		}
+	}
+
+	// Raw snapshots that may have been pruned aren't relied on.
+	cfg.Retention.RawSnapshots = config.Duration{Duration: time.Hour * 24 * 14}
+	w = get(t, "/stats?package=dub&weeks=4", &results)
+	if got := w.Header().Get("X-Stats-Resolution"); got != "daily" {
+		t.Errorf("expected daily resolution beyond the retention period, got %s", got)
+	}
+}
+
+func TestStatsBadRequests(t *testing.T) {
+	setup(t)
+
+	for _, url := range []string{"/stats?package=dub&weeks=abc", "/stats?package=dub&weeks=-1"} {
+		w := get(t, url, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", url, w.Code)
+		}
+	}
+
+	if w := get(t, "/stats?package=unknown&weeks=1", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown package to 404, got %d", w.Code)
+	}
+}
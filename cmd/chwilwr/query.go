@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+// Limits on what a single search can ask for, so queries stay cheap to run.
+const (
+	maxQueryLength = 200
+	maxQueryParts  = 10
+	maxPhraseWords = 8
+)
+
+// queryFields lists the fields that can be filtered on with field:value.
+var queryFields = []string{"name", "author", "license"}
+
+// parseQuery turns the user's search text into terms and filters. It understands:
+//
+//	json parser            packages matching every word
+//	"web framework"        the words as a phrase
+//	vib*                   words starting with vib
+//	-deprecated            packages not matching the word, phrase or filter
+//	license:MIT            packages whose field is the value, where the field is name, author or license
+//	author:"Sönke Ludwig"  quoted values, and prefixes with name:vibe*
+//
+// The returned query's Query holds the positive words, for matching against package names.
+func parseQuery(text string) (store.SearchQuery, error) {
+	var q store.SearchQuery
+	if utf8.RuneCountInString(text) > maxQueryLength {
+		return q, fmt.Errorf("can be at most %d characters long", maxQueryLength)
+	}
+
+	var names []string
+	positive := 0
+	rest := strings.TrimLeftFunc(text, unicode.IsSpace)
+	for rest != "" {
+		negate := strings.HasPrefix(rest, "-")
+		if negate {
+			rest = rest[1:]
+		}
+
+		field := ""
+		if i := strings.IndexFunc(rest, func(r rune) bool { return r == ':' || r == '"' || unicode.IsSpace(r) }); i > 0 && rest[i] == ':' {
+			field = strings.ToLower(rest[:i])
+			rest = rest[i+1:]
+			if !contains(queryFields, field) {
+				return q, fmt.Errorf("can only filter on %s, not %q", strings.Join(queryFields, ", "), field)
+			}
+		}
+
+		value, quoted, next, err := nextQueryValue(rest)
+		if err != nil {
+			return q, err
+		}
+		rest = strings.TrimLeftFunc(next, unicode.IsSpace)
+
+		prefix := !quoted && strings.HasSuffix(value, "*")
+		if prefix {
+			value = strings.TrimSuffix(value, "*")
+		}
+		switch {
+		case value == "" && field != "":
+			return q, fmt.Errorf("%s: needs a value", field)
+		case value == "" && negate:
+			return q, errors.New("- needs a word, phrase or filter after it")
+		case value == "":
+			return q, errors.New("* needs a word before it")
+		case !quoted && strings.Contains(value, "*"):
+			return q, fmt.Errorf("%q: * only works at the end of a word", value)
+		}
+
+		if field != "" {
+			q.Filters = append(q.Filters, store.SearchFilter{Field: field, Value: value, Prefix: prefix, Negate: negate})
+		} else {
+			words := strings.Fields(value)
+			if len(words) > maxPhraseWords {
+				return q, fmt.Errorf("phrases can have at most %d words", maxPhraseWords)
+			}
+			for _, word := range words {
+				if strings.IndexFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+					return q, fmt.Errorf("%q doesn't have any letters or numbers to search for", word)
+				}
+			}
+			q.Terms = append(q.Terms, store.SearchTerm{Words: words, Prefix: prefix, Negate: negate})
+			if !negate {
+				names = append(names, words...)
+			}
+		}
+		if !negate {
+			positive++
+		}
+		if len(q.Terms)+len(q.Filters) > maxQueryParts {
+			return q, fmt.Errorf("can have at most %d words, phrases and filters", maxQueryParts)
+		}
+	}
+
+	if positive == 0 {
+		return q, errors.New("needs at least one word, phrase or filter that isn't negated")
+	}
+	q.Query = strings.Join(names, " ")
+	return q, nil
+}
+
+// nextQueryValue reads either a quoted phrase or a single word from the start of s, returning what's left after it.
+func nextQueryValue(s string) (value string, quoted bool, rest string, err error) {
+	if strings.HasPrefix(s, `"`) {
+		end := strings.IndexByte(s[1:], '"')
+		if end < 0 {
+			return "", true, "", errors.New(`has a " without a matching "`)
+		}
+		value = strings.TrimSpace(s[1 : end+1])
+		if value == "" {
+			return "", true, "", errors.New("has an empty phrase")
+		}
+		return value, true, s[end+2:], nil
+	}
+
+	end := strings.IndexFunc(s, unicode.IsSpace)
+	if end < 0 {
+		end = len(s)
+	}
+	return s[:end], false, s[end:], nil
+}
+
+func contains(arr []string, s string) bool {
+	for _, v := range arr {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want string
+	}{
+		{"json parser", `json parser | [{[json] false false} {[parser] false false}] []`},
+		{"c++", `c++ | [{[c++] false false}] []`},
+		{`"web  framework" vib*`, `web framework vib | [{[web framework] false false} {[vib] true false}] []`},
+		{"vibe -deprecated", `vibe | [{[vibe] false false} {[deprecated] false true}] []`},
+		{`License:MIT author:"Sönke Ludwig" -name:vibe*`, ` | [] [{license MIT false false} {author Sönke Ludwig false false} {name vibe true true}]`},
+		{"  it's  ", `it's | [{[it's] false false}] []`},
+	} {
+		q, err := parseQuery(test.text)
+		got := fmt.Sprintf("%s | %v %v", q.Query, q.Terms, q.Filters)
+		if err != nil || got != test.want {
+			t.Errorf("%q: expected %s, got %s (%v)", test.text, test.want, got, err)
+		}
+	}
+
+	for _, bad := range []string{
+		`"unterminated`,
+		`""`,
+		"-",
+		"-deprecated",
+		"*",
+		"v*be",
+		"stars:10",
+		"license:",
+		"++",
+		`"one two three four five six seven eight nine"`,
+		"a b c d e f g h i j k",
+		string(make([]byte, 201)),
+	} {
+		if _, err := parseQuery(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestSearchQueryLanguage(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "vibe-d")
+	mem.AddPackage(ctx, 1, "vibe-core")
+	mem.AddPackage(ctx, 1, "asdf")
+	mem.UpdateSearchText(ctx, 1, "Event driven web framework", "")
+	mem.UpdateSearchText(ctx, 2, "Core event loop of vibe.d", "")
+	mem.UpdateSearchText(ctx, 3, "Fast JSON parser", "")
+	mem.SetMetadata(ctx, 1, []string{"Sönke Ludwig"}, "MIT")
+	mem.SetMetadata(ctx, 2, []string{"Sönke Ludwig"}, "MIT")
+	mem.SetMetadata(ctx, 3, []string{"Ilya Yaroshenko"}, "BSL-1.0")
+
+	for query, want := range map[string]string{
+		"json parser":              "[asdf]",
+		`"web framework"`:          "[vibe-d]",
+		"event -framework":         "[vibe-core]",
+		`author:"sönke ludwig"`:    "[vibe-core vibe-d]",
+		"license:MIT -name:vibe-d": "[vibe-core]",
+		"event license:BSL*":       "[]",
+		"c++":                      "[]",
+	} {
+		var results []QueryResult
+		w := search(t, "/v1/search?sort=name&query="+url.QueryEscape(query), &results)
+		names := []string{}
+		for _, result := range results {
+			names = append(names, result.Name)
+		}
+		if w.Code != http.StatusOK || fmt.Sprint(names) != want {
+			t.Errorf("%s: expected %s, got %d %v", query, want, w.Code, names)
+		}
+	}
+
+	w := get(t, "/v1/search?query="+url.QueryEscape(`"oops`), nil)
+	if body := errorResult(t, w); w.Code != http.StatusBadRequest || body.Details[0].Field != "query" {
+		t.Errorf("expected a malformed query to be a 400, got %d %+v", w.Code, body)
+	}
+}
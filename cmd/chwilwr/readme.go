@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/diff"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+// diffContext is how many unchanged lines surround each change in a diff.
+const diffContext = 3
+
+type ReadmeResult struct {
+	Package     string    `json:"package"`
+	Version     string    `json:"version"`
+	Description string    `json:"description"`
+	Readme      string    `json:"readme"`
+	Hash        string    `json:"hash"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+type ReadmeDiffResult struct {
+	Package  string `json:"package"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	FromHash string `json:"fromHash"`
+	ToHash   string `json:"toHash"`
+	Changed  bool   `json:"changed"`
+	// Description and Readme are unified diffs, empty when that part didn't change.
+	Description string `json:"description"`
+	Readme      string `json:"readme"`
+}
+
+func doReadme(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("package")
+	version := query.Get("version")
+	logger.Info("Readme", zap.String("package", name), zap.String("version", version), zap.String("ip", r.RemoteAddr))
+	if name == "" {
+		writeInvalidParameters(w, r, FieldError{Field: "package", Message: "is required"})
+		return
+	}
+
+	p, ok := lookupPackage(w, r, name)
+	if !ok {
+		return
+	}
+	ver, readme, ok := lookupReadme(w, r, p, version)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ReadmeResult{
+		Package:     p.Name,
+		Version:     ver.Semver,
+		Description: readme.Description,
+		Readme:      readme.Readme,
+		Hash:        readme.Hash,
+		UpdatedAt:   readme.UpdatedAt,
+	})
+}
+
+func doReadmeDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("package")
+	logger.Info("Readme diff", zap.String("package", name), zap.String("from", query.Get("from")), zap.String("to", query.Get("to")), zap.String("ip", r.RemoteAddr))
+
+	var details []FieldError
+	for _, field := range []string{"package", "from", "to"} {
+		if query.Get(field) == "" {
+			details = append(details, FieldError{Field: field, Message: "is required"})
+		}
+	}
+	if len(details) > 0 {
+		writeInvalidParameters(w, r, details...)
+		return
+	}
+
+	p, ok := lookupPackage(w, r, name)
+	if !ok {
+		return
+	}
+	from, before, ok := lookupReadme(w, r, p, query.Get("from"))
+	if !ok {
+		return
+	}
+	to, after, ok := lookupReadme(w, r, p, query.Get("to"))
+	if !ok {
+		return
+	}
+
+	result := ReadmeDiffResult{
+		Package:  p.Name,
+		From:     from.Semver,
+		To:       to.Semver,
+		FromHash: before.Hash,
+		ToHash:   after.Hash,
+		Changed:  before.Hash != after.Hash,
+	}
+	if result.Changed {
+		result.Description = diff.Unified(diff.Lines(before.Description, after.Description), diffContext)
+		result.Readme = diff.Unified(diff.Lines(before.Readme, after.Readme), diffContext)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// lookupReadme finds the stored README of the given version, or of the latest version when it's empty. Unknown
+// versions, and versions whose README hasn't been stored yet, are a 404. If false is returned then the response has
+// already been written.
+func lookupReadme(w http.ResponseWriter, r *http.Request, p store.Package, version string) (store.Version, store.Readme, bool) {
+	var ver store.Version
+	var readme store.Readme
+	var err error
+	if version == "" {
+		ver, err = repo.LatestVersion(r.Context(), p.ID, r.URL.Query().Get("prerelease") == "include")
+	} else {
+		ver, err = repo.FindVersion(r.Context(), p.ID, version)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		message := "Package " + strconv.Quote(p.Name) + " has no version " + strconv.Quote(version) + "."
+		if version == "" {
+			message = "Package " + strconv.Quote(p.Name) + " has no versions yet."
+		}
+		writeError(w, r, http.StatusNotFound, CodeNotFound, message)
+		return ver, readme, false
+	}
+
+	if err == nil {
+		readme, err = repo.Readme(r.Context(), ver.ID)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "The README of "+p.Name+" "+ver.Semver+" hasn't been stored yet.")
+		return ver, readme, false
+	} else if err != nil {
+		writeInternalError(w, r, "Readme lookup failed", err, zap.String("package", p.Name), zap.String("version", version))
+		return ver, readme, false
+	}
+	return ver, readme, true
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestReadme(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "vibe-d")
+	v1, _ := mem.EnsureVersion(ctx, 1, "1.0.0")
+	v2, _ := mem.EnsureVersion(ctx, 1, "1.1.0")
+	v3, _ := mem.EnsureVersion(ctx, 1, "1.2.0")
+	mem.EnsureVersion(ctx, 1, "2.0.0")
+	mem.SetReadme(ctx, store.Readme{VersionID: v1.ID, Description: "Web framework", Readme: "# vibe.d\nInstall it\nUse it\n"})
+	mem.SetReadme(ctx, store.Readme{VersionID: v2.ID, Description: "Web framework", Readme: "# vibe.d\nInstall it with dub\nUse it\n"})
+	mem.SetReadme(ctx, store.Readme{VersionID: v3.ID, Description: "Web framework", Readme: "# vibe.d\nInstall it with dub\nUse it\n"})
+
+	var readme ReadmeResult
+	w := get(t, "/v1/readme?package=vibe-d&version=1.0.0", &readme)
+	if w.Code != http.StatusOK || readme.Version != "1.0.0" || readme.Readme != "# vibe.d\nInstall it\nUse it\n" || readme.Hash == "" {
+		t.Errorf("expected the 1.0.0 readme, got %d %s", w.Code, w.Body.String())
+	}
+
+	// The latest version's readme hasn't been stored yet.
+	for _, url := range []string{"/v1/readme?package=vibe-d", "/v1/readme?package=vibe-d&version=9.9.9", "/v1/readme?package=missing"} {
+		if w := get(t, url, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", url, w.Code)
+		}
+	}
+
+	var result ReadmeDiffResult
+	get(t, "/v1/readme/diff?package=vibe-d&from=1.0.0&to=1.1.0", &result)
+	want := "@@ -1,3 +1,3 @@\n # vibe.d\n-Install it\n+Install it with dub\n Use it\n"
+	if !result.Changed || result.Readme != want || result.Description != "" {
+		t.Errorf("expected only the readme to differ, got %+v", result)
+	}
+
+	result = ReadmeDiffResult{}
+	get(t, "/v1/readme/diff?package=vibe-d&from=1.1.0&to=1.2.0", &result)
+	if result.Changed || result.FromHash != result.ToHash || result.Readme != "" {
+		t.Errorf("expected no changes, got %+v", result)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type QueryResult struct {
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	Registry  string     `json:"registry"`
+	Rank      float64    `json:"rank"`
+	Downloads int64      `json:"downloads"`
+	Stars     int        `json:"stars"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+type SearchResult struct {
+	Results []QueryResult `json:"results"`
+	// Suggestion is a likely correction when the query looks like a misspelled package name.
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func doSearch(w http.ResponseWriter, r *http.Request) {
+	results, suggestion, ok := searchPage(w, r)
+	if ok {
+		writeJSON(w, http.StatusOK, SearchResult{Results: results, Suggestion: suggestion})
+	}
+}
+
+// doLegacySearch serves the unversioned /search, which has always returned a bare array. Its suggestion is only in
+// the X-Search-Suggestion header.
+func doLegacySearch(w http.ResponseWriter, r *http.Request) {
+	results, _, ok := searchPage(w, r)
+	if ok {
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// searchPage returns the page of results the request asks for, along with any suggested correction, having set the
+// paging and suggestion headers. If false is returned then the response has already been written.
+func searchPage(w http.ResponseWriter, r *http.Request) ([]QueryResult, string, bool) {
+	params := r.URL.Query()
+	query := params.Get("query")
+	logger.Info("Query", zap.String("query", query), zap.String("ip", r.RemoteAddr))
+
+	q, details := parseSearchQuery(params)
+	if len(details) > 0 {
+		writeInvalidParameters(w, r, details...)
+		return nil, "", false
+	}
+
+	// Every registry is searched unless one is asked for.
+	if name := params.Get("registry"); name != "" {
+		reg, ok := lookupRegistry(w, r, name)
+		if !ok {
+			return nil, "", false
+		}
+		q.RegistryID = reg.ID
+	}
+
+	results, total, err := repo.Search(r.Context(), q)
+	if err != nil {
+		writeInternalError(w, r, "Query failed", err, zap.String("query", query))
+		return nil, "", false
+	}
+
+	// Suggest a likely correction when the query looks like a misspelled package name.
+	var suggestion string
+	if q.Query != "" {
+		suggestion, err = repo.Suggest(r.Context(), q.RegistryID, q.Query)
+		if err != nil {
+			writeInternalError(w, r, "Suggestion failed", err, zap.String("query", query))
+			return nil, "", false
+		}
+		if suggestion != "" {
+			w.Header().Add("X-Search-Suggestion", suggestion)
+		}
+	}
+
+	arr := make([]QueryResult, 0, len(results))
+	for _, result := range results {
+		value := QueryResult{
+			Id:        result.ID,
+			Name:      result.Name,
+			Registry:  result.Registry,
+			Rank:      result.Rank,
+			Downloads: result.Downloads,
+			Stars:     result.Stars,
+		}
+		if !result.UpdatedAt.IsZero() {
+			value.UpdatedAt = &result.UpdatedAt
+		}
+		arr = append(arr, value)
+	}
+
+	w.Header().Add("X-Total-Count", strconv.Itoa(total))
+	if next := q.Offset + len(results); len(results) > 0 && next < total {
+		w.Header().Add("X-Next-Cursor", encodeCursor(next, params, searchCursorKeys))
+	}
+	return arr, suggestion, true
+}
+
+// parseSearchQuery checks every paging and sorting parameter, returning a detail for each bad one.
+func parseSearchQuery(params url.Values) (store.SearchQuery, []FieldError) {
+	var details []FieldError
+	q, err := parseQuery(params.Get("query"))
+	if params.Get("query") == "" {
+		details = append(details, FieldError{Field: "query", Message: "is required"})
+	} else if err != nil {
+		details = append(details, FieldError{Field: "query", Message: err.Error()})
+	}
+	q.Sort = store.SearchSort(params.Get("sort"))
+
+	if q.Sort == "" {
+		q.Sort = store.SortRelevance
+	} else if !validSort(q.Sort) {
+		sorts := make([]string, 0, len(store.SearchSorts))
+		for _, s := range store.SearchSorts {
+			sorts = append(sorts, string(s))
+		}
+		details = append(details, FieldError{Field: "sort", Message: "must be one of " + strings.Join(sorts, ", ")})
+	}
+
+	var pageDetails []FieldError
+	q.Limit, q.Offset, pageDetails = parsePage(params, defaultSearchLimit, maxSearchLimit, searchCursorKeys)
+	return q, append(details, pageDetails...)
+}
+
+// parsePage checks the limit, and the offset or cursor of the page to return. A cursor is only accepted with the same
+// values for keys as the request it came from.
+func parsePage(params url.Values, defaultLimit int, maxLimit int, keys []string) (int, int, []FieldError) {
+	var details []FieldError
+	limit := defaultLimit
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLimit {
+			details = append(details, FieldError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxLimit)})
+		}
+		limit = n
+	}
+
+	var offset int
+	offsetValue, cursor := params.Get("offset"), params.Get("cursor")
+	switch {
+	case offsetValue != "" && cursor != "":
+		details = append(details, FieldError{Field: "cursor", Message: "can't be combined with offset"})
+	case offsetValue != "":
+		n, err := strconv.Atoi(offsetValue)
+		if err != nil || n < 0 {
+			details = append(details, FieldError{Field: "offset", Message: "must be 0 or more"})
+		}
+		offset = n
+	case cursor != "":
+		n, ok := decodeCursor(cursor, params, keys)
+		if !ok {
+			details = append(details, FieldError{Field: "cursor", Message: "isn't a cursor from this search"})
+		}
+		offset = n
+	}
+	return limit, offset, details
+}
+
+func validSort(sort store.SearchSort) bool {
+	for _, s := range store.SearchSorts {
+		if s == sort {
+			return true
+		}
+	}
+	return false
+}
+
+// searchCursorKeys are the parameters a search's cursors are tied to.
+var searchCursorKeys = []string{"query", "registry", "sort"}
+
+// Cursors are the offset of the next page, tied to the request they came from so they can't be reused with different
+// values for keys, such as a different query, registry or sort.
+func encodeCursor(offset int, params url.Values, keys []string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset) + ":" + cursorFingerprint(params, keys)))
+}
+
+func decodeCursor(cursor string, params url.Values, keys []string) (int, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 || parts[1] != cursorFingerprint(params, keys) {
+		return 0, false
+	}
+	offset, err := strconv.Atoi(parts[0])
+	return offset, err == nil && offset >= 0
+}
+
+func cursorFingerprint(params url.Values, keys []string) string {
+	h := sha256.New()
+	for _, key := range keys {
+		h.Write([]byte(params.Get(key)))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestSearchPaging(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		mem.AddPackage(ctx, 1, fmt.Sprintf("vibe-%d", i))
+	}
+
+	var results []QueryResult
+	w := search(t, "/v1/search?query=vibe&sort=name&limit=2", &results)
+	if w.Header().Get("X-Total-Count") != "5" || len(results) != 2 || results[0].Name != "vibe-0" {
+		t.Fatalf("expected the first 2 of 5, got %s %+v", w.Header().Get("X-Total-Count"), results)
+	}
+
+	// Following the cursors visits every result once.
+	seen := []string{results[0].Name, results[1].Name}
+	for cursor := w.Header().Get("X-Next-Cursor"); cursor != ""; cursor = w.Header().Get("X-Next-Cursor") {
+		w = search(t, "/v1/search?query=vibe&sort=name&limit=2&cursor="+cursor, &results)
+		for _, result := range results {
+			seen = append(seen, result.Name)
+		}
+	}
+	if fmt.Sprint(seen) != "[vibe-0 vibe-1 vibe-2 vibe-3 vibe-4]" {
+		t.Errorf("expected to page through every result, got %v", seen)
+	}
+
+	w = search(t, "/v1/search?query=vibe&sort=name&offset=4", &results)
+	if len(results) != 1 || results[0].Name != "vibe-4" || w.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("expected the last result without a next cursor, got %+v", results)
+	}
+	w = search(t, "/v1/search?query=vibe&offset=10", &results)
+	if len(results) != 0 || w.Header().Get("X-Total-Count") != "5" {
+		t.Errorf("expected an empty page past the end that still has the total, got %+v", results)
+	}
+
+	cursor := encodeCursor(2, map[string][]string{"query": {"vibe"}, "sort": {"name"}}, searchCursorKeys)
+	for _, url := range []string{
+		"/v1/search?query=vibe&limit=101",
+		"/v1/search?query=vibe&limit=0",
+		"/v1/search?query=vibe&offset=-1",
+		"/v1/search?query=vibe&sort=popularity",
+		"/v1/search?query=vibe&sort=stars&cursor=" + cursor,
+		"/v1/search?query=vibe&sort=name&offset=2&cursor=" + cursor,
+		"/v1/search?query=vibe&cursor=nonsense",
+	} {
+		if w := get(t, url, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", url, w.Code)
+		}
+	}
+}
+
+func TestSearchSorting(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	now := time.Now()
+	for i, pkg := range []struct {
+		name      string
+		downloads int64
+		stars     int
+	}{
+		{"vibe-b", 10, 3},
+		{"vibe-a", 30, 1},
+		{"vibe-c", 20, 2},
+	} {
+		mem.AddPackage(ctx, 1, pkg.name)
+		mem.SetClock(func() time.Time { return now.Add(time.Duration(i) * time.Hour) })
+		ver, _ := mem.EnsureVersion(ctx, i+1, "1.0.0")
+		mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now, DownloadsTotal: pkg.downloads, Stars: pkg.stars})
+		// Flagged snapshots don't count.
+		mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: now.Add(time.Hour), DownloadsTotal: 1000, FlagReason: "jump"})
+	}
+	mem.AddPackage(ctx, 1, "vibe")
+
+	for sort, want := range map[string]string{
+		"relevance": "[vibe vibe-a vibe-b vibe-c]",
+		"name":      "[vibe vibe-a vibe-b vibe-c]",
+		"downloads": "[vibe-a vibe-c vibe-b vibe]",
+		"stars":     "[vibe-b vibe-c vibe-a vibe]",
+		"updated":   "[vibe-c vibe-a vibe-b vibe]",
+	} {
+		var results []QueryResult
+		search(t, "/v1/search?query=vibe&sort="+sort, &results)
+		var names []string
+		for _, result := range results {
+			names = append(names, result.Name)
+		}
+		if fmt.Sprint(names) != want {
+			t.Errorf("%s: expected %s, got %v", sort, want, names)
+		}
+	}
+}
+
+// search gets a page of search results, unwrapping them from the response.
+func search(t *testing.T, url string, results *[]QueryResult) *httptest.ResponseRecorder {
+	t.Helper()
+	var body SearchResult
+	w := get(t, url, &body)
+	*results = body.Results
+	return w
+}
+
+func TestSearchFuzzy(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "vibe-d")
+	mem.AddPackage(ctx, 1, "mir-algorithm")
+	mem.AddPackage(ctx, 1, "dub")
+
+	var results []QueryResult
+	w := search(t, "/v1/search?query=vibed", &results)
+	if len(results) != 1 || results[0].Name != "vibe-d" {
+		t.Errorf("expected the misspelling to still find vibe-d, got %+v", results)
+	}
+	if got := w.Header().Get("X-Search-Suggestion"); got != "vibe-d" {
+		t.Errorf("expected vibe-d to be suggested, got %q", got)
+	}
+	var body SearchResult
+	get(t, "/v1/search?query=vibed", &body)
+	if body.Suggestion != "vibe-d" {
+		t.Errorf("expected vibe-d to be suggested in the body, got %+v", body)
+	}
+
+	// The unversioned alias keeps its bare array, so the suggestion is only in the header.
+	w = get(t, "/search?query=vibed", &results)
+	if w.Header().Get("X-Search-Suggestion") != "vibe-d" || len(results) != 1 {
+		t.Errorf("expected the old route's array and header, got %q %+v", w.Header().Get("X-Search-Suggestion"), results)
+	}
+
+	w = search(t, "/v1/search?query=mir-algoritm", &results)
+	if got := w.Header().Get("X-Search-Suggestion"); got != "mir-algorithm" {
+		t.Errorf("expected mir-algorithm to be suggested, got %q", got)
+	}
+
+	for _, query := range []string{"dub", "DUB", "nothing"} {
+		w = search(t, "/v1/search?query="+query, &results)
+		if got := w.Header().Get("X-Search-Suggestion"); got != "" {
+			t.Errorf("%s: expected no suggestion, got %q", query, got)
+		}
+	}
+}
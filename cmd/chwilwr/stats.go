@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+const week = time.Hour * 24 * 7
+
+// maxRawSpan is the longest range that raw snapshots can be asked for, to keep responses to a sensible size.
+const maxRawSpan = week * 12
+
+// statsResolutions maps the resolution parameter onto the store's resolutions. The store's own names are accepted too,
+// since they're what X-Stats-Resolution reports.
+var statsResolutions = map[string]store.Resolution{
+	"raw":     store.Raw,
+	"day":     store.Daily,
+	"week":    store.Weekly,
+	"month":   store.Monthly,
+	"daily":   store.Daily,
+	"weekly":  store.Weekly,
+	"monthly": store.Monthly,
+}
+
+// maxRelativeYears is how far back a relative time or a number of weeks can reach, which is well beyond any stats but
+// keeps the arithmetic from overflowing.
+const maxRelativeYears = 100
+
+// relativeUnits are the units a relative time such as -30d can be given in, as the years, months and days to add.
+var relativeUnits = map[byte][3]int{
+	'd': {0, 0, 1},
+	'w': {0, 0, 7},
+	'm': {0, 1, 0},
+	'y': {1, 0, 0},
+}
+
+func doStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pkg := query.Get("package")
+	logger.Info("Stats", zap.String("package", pkg), zap.String("weeks", query.Get("weeks")), zap.String("from", query.Get("from")), zap.String("to", query.Get("to")), zap.String("ip", r.RemoteAddr))
+
+	var details []FieldError
+	if pkg == "" {
+		details = append(details, FieldError{Field: "package", Message: "is required"})
+	}
+	now := time.Now()
+	from, to, rangeDetails := parseStatsRange(query, now)
+	details = append(details, rangeDetails...)
+	resolution, by, seriesDetails := parseStatsSeries(query, from, to, now)
+	if len(rangeDetails) == 0 {
+		details = append(details, seriesDetails...)
+	}
+	if len(details) > 0 {
+		writeInvalidParameters(w, r, details...)
+		return
+	}
+
+	p, ok := lookupPackage(w, r, pkg)
+	if !ok {
+		return
+	}
+
+	// Snapshots that gwyliwr flagged as suspect are left out unless explicitly asked for.
+	q := store.SnapshotQuery{
+		Since:          from,
+		Until:          to,
+		IncludeFlagged: query.Get("flagged") == "include",
+		Resolution:     resolution,
+	}
+	if !statsVersion(w, r, p, &q) {
+		return
+	}
+	var snapshots []store.Snapshot
+	var versions []store.Version
+	var err error
+	if q.PackageID != 0 || q.VersionID != 0 {
+		snapshots, err = statsSeries(r.Context(), q, by)
+	}
+	if err == nil {
+		versions, err = repo.Versions(r.Context(), p.ID)
+	}
+	var days []store.DailyDownloads
+	if err == nil {
+		days, err = repo.DailyDownloads(r.Context(), p.ID, perDayResolution(resolution).Truncate(from))
+	}
+	if err != nil {
+		writeInternalError(w, r, "Query failed", err, zap.String("package", pkg), zap.Time("from", from), zap.Time("to", to))
+		return
+	}
+
+	semvers := make(map[int]string, len(versions))
+	for _, ver := range versions {
+		semvers[ver.ID] = ver.Semver
+	}
+	arr := make([]StatsResult, 0, len(snapshots))
+	for _, s := range snapshots {
+		// downloadsPerDay covers the day of a raw snapshot, or the whole bucket of a rollup.
+		var perDay *float64
+		bucket := perDayResolution(resolution).Truncate(s.Time)
+		if rate, ok := store.DownloadsPerDay(days, bucket, perDayResolution(resolution).Next(bucket)); ok {
+			perDay = &rate
+		}
+		arr = append(arr, StatsResult{
+			Time:             s.Time,
+			Version:          semvers[s.VersionID],
+			DownloadsWeekly:  s.DownloadsWeekly,
+			DownloadsMonthly: s.DownloadsMonthly,
+			DownloadsTotal:   s.DownloadsTotal,
+			Stars:            s.Stars,
+			Watchers:         s.Watchers,
+			Issues:           s.Issues,
+			Forks:            s.Forks,
+			Flagged:          s.Flagged(),
+			FlagReason:       s.FlagReason,
+			Samples:          s.Samples,
+			DownloadsPerDay:  perDay,
+		})
+	}
+
+	w.Header().Add("X-Stats-Resolution", string(resolution))
+	writeJSON(w, http.StatusOK, arr)
+}
+
+// parseStatsRange works out the [from, to) range that the request asks for, either as from and to, or as the number
+// of weeks before to. to defaults to now.
+func parseStatsRange(query url.Values, now time.Time) (time.Time, time.Time, []FieldError) {
+	var details []FieldError
+	to := now
+	if value := query.Get("to"); value != "" {
+		var err error
+		to, err = parseStatsTime(value, now)
+		if err != nil {
+			details = append(details, FieldError{Field: "to", Message: err.Error()})
+		}
+	}
+
+	var from time.Time
+	weeks := query.Get("weeks")
+	switch {
+	case weeks != "" && query.Get("from") != "":
+		details = append(details, FieldError{Field: "weeks", Message: "can't be combined with from"})
+	case weeks != "":
+		weeksAsNum, err := strconv.Atoi(weeks)
+		if err != nil || weeksAsNum < 0 {
+			details = append(details, FieldError{Field: "weeks", Message: "must be a whole number of weeks, 0 or more"})
+		} else if weeksAsNum > maxRelativeYears*52 {
+			details = append(details, FieldError{Field: "weeks", Message: "can be at most " + strconv.Itoa(maxRelativeYears*52)})
+			weeksAsNum = 0
+		}
+		from = to.AddDate(0, 0, -7*weeksAsNum)
+	case query.Get("from") != "":
+		var err error
+		from, err = parseStatsTime(query.Get("from"), now)
+		if err != nil {
+			details = append(details, FieldError{Field: "from", Message: err.Error()})
+		} else if !from.Before(to) && len(details) == 0 {
+			details = append(details, FieldError{Field: "from", Message: "must be before to"})
+		}
+	default:
+		details = append(details, FieldError{Field: "from", Message: "is required unless weeks is given"})
+	}
+	return from, to, details
+}
+
+// parseStatsTime understands "now", times relative to now such as -30d (in days, weeks, months or years), and
+// absolute times as RFC 3339, a date, a month such as 2022-06, or a year. Absolute times without a zone are UTC.
+func parseStatsTime(value string, now time.Time) (time.Time, error) {
+	if value == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(value, "-") && len(value) > 2 {
+		unit, ok := relativeUnits[value[len(value)-1]]
+		n, err := strconv.Atoi(value[1 : len(value)-1])
+		if ok && err == nil && n >= 0 {
+			// n is bounded first, as it can be large enough for AddDate to overflow.
+			if n > maxRelativeYears*366 || now.AddDate(-unit[0]*n, -unit[1]*n, -unit[2]*n).Before(now.AddDate(-maxRelativeYears, 0, 0)) {
+				return time.Time{}, errors.New("can be at most " + strconv.Itoa(maxRelativeYears) + " years ago")
+			}
+			return now.AddDate(-unit[0]*n, -unit[1]*n, -unit[2]*n), nil
+		}
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("must be now, a relative time such as -30d, or a time such as 2022-01-01 or 2022-01-01T12:00:00Z")
+}
+
+// parseStatsSeries picks the resolution and aggregation, choosing a resolution to suit the range if none was asked for.
+func parseStatsSeries(query url.Values, from time.Time, to time.Time, now time.Time) (store.Resolution, store.Aggregation, []FieldError) {
+	var details []FieldError
+	resolution := pickResolution(from, to, now)
+	if value := query.Get("resolution"); value != "" {
+		var ok bool
+		resolution, ok = statsResolutions[value]
+		if !ok {
+			details = append(details, FieldError{Field: "resolution", Message: "must be raw, day, week or month"})
+		} else if resolution == store.Raw && to.Sub(from) > maxRawSpan {
+			details = append(details, FieldError{Field: "resolution", Message: "raw can only cover up to 12 weeks"})
+		}
+	}
+
+	by := store.AggregateLast
+	if value := query.Get("aggregate"); value != "" {
+		by = store.Aggregation(value)
+		// Aggregating needs buckets, so short ranges that would otherwise be raw are daily instead.
+		if resolution == store.Raw && query.Get("resolution") == "" {
+			resolution = store.Daily
+		}
+		switch {
+		case !validAggregation(by):
+			details = append(details, FieldError{Field: "aggregate", Message: "must be last, max or avg"})
+		case resolution == store.Raw && by != store.AggregateLast:
+			details = append(details, FieldError{Field: "aggregate", Message: "needs a resolution other than raw"})
+		}
+	}
+	return resolution, by, details
+}
+
+func validAggregation(by store.Aggregation) bool {
+	for _, a := range store.Aggregations {
+		if a == by {
+			return true
+		}
+	}
+	return false
+}
+
+// statsVersion points q at the versions the request asks for: the whole package by default, its latest version for
+// "latest" (a stable one unless prerelease=include is passed), its latest prerelease for "latest-prerelease", or the
+// given version. q is left without either ID if the package has no such version yet. If false is returned then the
+// response has already been written.
+func statsVersion(w http.ResponseWriter, r *http.Request, p store.Package, q *store.SnapshotQuery) bool {
+	var ver store.Version
+	var err error
+	switch version := r.URL.Query().Get("version"); version {
+	case "":
+		q.PackageID = p.ID
+		return true
+	case "latest":
+		ver, err = repo.LatestVersion(r.Context(), p.ID, r.URL.Query().Get("prerelease") == "include")
+		if errors.Is(err, store.ErrNotFound) {
+			return true
+		}
+	case "latest-prerelease":
+		ver, err = repo.LatestPrerelease(r.Context(), p.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return true
+		}
+	default:
+		ver, err = repo.FindVersion(r.Context(), p.ID, version)
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "Package "+strconv.Quote(p.Name)+" has no version "+strconv.Quote(version)+".")
+			return false
+		}
+	}
+	if err != nil {
+		writeInternalError(w, r, "Version lookup failed", err, zap.String("package", p.Name))
+		return false
+	}
+	q.VersionID = ver.ID
+	return true
+}
+
+// statsSeries returns the snapshots matching q, combining each bucket's snapshots as asked. The rollups only keep the
+// last snapshot of each bucket, so the other aggregations are worked out from the next finer series.
+func statsSeries(ctx context.Context, q store.SnapshotQuery, by store.Aggregation) ([]store.Snapshot, error) {
+	if by == store.AggregateLast || q.Resolution == store.Raw {
+		return snapshotsOrRaw(ctx, q)
+	}
+
+	resolution := q.Resolution
+	q.Since = resolution.Truncate(q.Since)
+	q.IncludeFlagged = false
+	q.Resolution = store.Daily
+	if resolution == store.Daily {
+		q.Resolution = store.Raw
+	}
+	snapshots, err := snapshotsOrRaw(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return store.Aggregate(snapshots, resolution, by), nil
+}
+
+// snapshotsOrRaw returns the snapshots matching q. If the requested rollup is empty, most likely because gwyliwr's
+// maintain command hasn't run yet, then it's worked out from the raw snapshots instead, so the response still has the
+// resolution and size that was asked for.
+func snapshotsOrRaw(ctx context.Context, q store.SnapshotQuery) ([]store.Snapshot, error) {
+	snapshots, err := repo.Snapshots(ctx, q)
+	if err != nil || len(snapshots) > 0 || q.Resolution == store.Raw {
+		return snapshots, err
+	}
+	resolution := q.Resolution
+	q.Resolution = store.Raw
+	q.IncludeFlagged = false
+	snapshots, err = repo.Snapshots(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return store.Aggregate(snapshots, resolution, store.AggregateLast), nil
+}
+
+// perDayResolution is the bucket that downloadsPerDay is averaged over for each resolution.
+func perDayResolution(resolution store.Resolution) store.Resolution {
+	if resolution == store.Raw {
+		return store.Daily
+	}
+	return resolution
+}
+
+// pickResolution keeps responses to a sensible size by using coarser rollups for longer ranges. Raw snapshots are
+// only used while they're guaranteed not to have been pruned.
+func pickResolution(from time.Time, to time.Time, now time.Time) store.Resolution {
+	span := to.Sub(from)
+	retention := cfg.Retention.RawSnapshots.Duration
+	switch {
+	case span <= maxRawSpan && (retention == 0 || now.Sub(from) <= retention):
+		return store.Raw
+	case span <= week*52:
+		return store.Daily
+	case span <= week*260:
+		return store.Weekly
+	}
+	return store.Monthly
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestStatsRange(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "dub")
+	ver, _ := mem.EnsureVersion(ctx, 1, "1.0.0")
+
+	// A snapshot every ten days through 2021 to 2023, with monthly downloads rising by a hundred each time.
+	start := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 110; i++ {
+		mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: start.AddDate(0, 0, 10*i), DownloadsMonthly: 100 * i, DownloadsTotal: int64(1000 * i)})
+	}
+	for _, resolution := range store.Rollups {
+		mem.Rollup(ctx, resolution)
+	}
+
+	var results []StatsResult
+	w := get(t, "/v1/stats?package=dub&from=2022&to=2023&resolution=month", &results)
+	if w.Code != http.StatusOK || w.Header().Get("X-Stats-Resolution") != "monthly" || len(results) != 12 {
+		t.Fatalf("expected a point for each month of 2022, got %d %s %d", w.Code, w.Header().Get("X-Stats-Resolution"), len(results))
+	}
+	if !results[0].Time.Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)) || !results[11].Time.Equal(time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected January to December 2022, got %v to %v", results[0].Time, results[11].Time)
+	}
+
+	// January 2022 has snapshots on the 6th, 16th and 26th.
+	for aggregate, want := range map[string]int{"last": 3900, "max": 3900, "avg": 3800} {
+		get(t, "/v1/stats?package=dub&from=2022-01-01&to=2022-02-01&resolution=month&aggregate="+aggregate, &results)
+		if len(results) != 1 || results[0].DownloadsMonthly != want || results[0].Samples != 3 {
+			t.Errorf("%s: expected %d monthly downloads from 3 samples, got %+v", aggregate, want, results)
+		}
+	}
+
+	get(t, "/v1/stats?package=dub&from=2022-01-10T00:00:00Z&to=2022-01-20T00:00:00Z&resolution=raw", &results)
+	if len(results) != 1 || !results[0].Time.Equal(time.Date(2022, 1, 16, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected only the raw snapshot within the range, got %+v", results)
+	}
+
+	// Relative ranges count back from now, which is long after the last snapshot.
+	w = get(t, "/v1/stats?package=dub&from=-6m", &results)
+	if w.Code != http.StatusOK || w.Header().Get("X-Stats-Resolution") != "daily" || len(results) != 0 {
+		t.Errorf("expected no recent daily stats, got %d %s %+v", w.Code, w.Header().Get("X-Stats-Resolution"), results)
+	}
+	w = get(t, "/v1/stats?package=dub&from=-2w&aggregate=max", &results)
+	if w.Code != http.StatusOK || w.Header().Get("X-Stats-Resolution") != "daily" {
+		t.Errorf("expected aggregating to pick daily over raw, got %d %s", w.Code, w.Header().Get("X-Stats-Resolution"))
+	}
+}
+
+func TestStatsRangeBadRequests(t *testing.T) {
+	setup(t)
+
+	for url, field := range map[string]string{
+		"/v1/stats?package=dub":                                       "from",
+		"/v1/stats?package=dub&from=yesterday":                        "from",
+		"/v1/stats?package=dub&from=2022&to=2021":                     "from",
+		"/v1/stats?package=dub&from=2022&to=soon":                     "to",
+		"/v1/stats?package=dub&from=2022&weeks=4":                     "weeks",
+		"/v1/stats?package=dub&weeks=9999999999":                      "weeks",
+		"/v1/stats?package=dub&from=-9999999999w":                     "from",
+		"/v1/stats?package=dub&from=-4w&resolution=hourly":            "resolution",
+		"/v1/stats?package=dub&from=-1y&resolution=raw":               "resolution",
+		"/v1/stats?package=dub&from=-4w&aggregate=sum":                "aggregate",
+		"/v1/stats?package=dub&from=-4w&resolution=raw&aggregate=avg": "aggregate",
+	} {
+		w := get(t, url, nil)
+		if body := errorResult(t, w); w.Code != http.StatusBadRequest || len(body.Details) != 1 || body.Details[0].Field != field {
+			t.Errorf("%s: expected a bad %s, got %d %+v", url, field, w.Code, body)
+		}
+	}
+}
+
+func TestParseStatsTime(t *testing.T) {
+	now := time.Date(2022, 3, 31, 12, 0, 0, 0, time.UTC)
+	for value, want := range map[string]time.Time{
+		"now":                  now,
+		"-30d":                 now.AddDate(0, 0, -30),
+		"-2w":                  now.AddDate(0, 0, -14),
+		"-1m":                  now.AddDate(0, -1, 0),
+		"-1y":                  now.AddDate(-1, 0, 0),
+		"-100y":                now.AddDate(-100, 0, 0),
+		"-1200m":               now.AddDate(0, -1200, 0),
+		"2021":                 time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		"2021-06":              time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+		"2021-06-15":           time.Date(2021, 6, 15, 0, 0, 0, 0, time.UTC),
+		"2021-06-15T10:00:00Z": time.Date(2021, 6, 15, 10, 0, 0, 0, time.UTC),
+	} {
+		got, err := parseStatsTime(value, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("%s: expected %v, got %v %v", value, want, got, err)
+		}
+	}
+
+	for _, value := range []string{"", "-", "-d", "-1h", "--1d", "-xd", "tomorrow", "2021-13", "-101y", "-1300m", "-99999999999999999999d"} {
+		if _, err := parseStatsTime(value, now); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestStatsAcrossVersions(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "dub")
+	old, _ := mem.EnsureVersion(ctx, 1, "1.0.0")
+	latest, _ := mem.EnsureVersion(ctx, 1, "1.1.0")
+
+	// 1.1.0 is released partway through the second day.
+	day := time.Now().UTC().AddDate(0, 0, -3).Truncate(time.Hour * 24)
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: old.ID, Time: day.Add(time.Hour), DownloadsTotal: 10})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: old.ID, Time: day.Add(time.Hour * 25), DownloadsTotal: 20})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: latest.ID, Time: day.Add(time.Hour * 26), DownloadsTotal: 21})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: latest.ID, Time: day.Add(time.Hour * 49), DownloadsTotal: 30})
+
+	var results []StatsResult
+	get(t, "/v1/stats?package=dub&weeks=1", &results)
+	if len(results) != 4 || results[1].Version != "1.0.0" || results[2].Version != "1.1.0" || results[3].DownloadsTotal != 30 {
+		t.Errorf("expected the series to carry on across the release, got %+v", results)
+	}
+	get(t, "/v1/stats?package=dub&weeks=1&version=1.0.0", &results)
+	if len(results) != 2 || results[1].DownloadsTotal != 20 {
+		t.Errorf("expected only the snapshots of 1.0.0, got %+v", results)
+	}
+	if w := get(t, "/v1/stats?package=dub&weeks=1&version=2.0.0", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown version to 404, got %d", w.Code)
+	}
+
+	// The day of the release has a rollup for each version, which are merged into one.
+	mem.Rollup(ctx, store.Daily)
+	get(t, "/v1/stats?package=dub&weeks=1&resolution=day", &results)
+	if len(results) != 3 || results[1].Version != "1.1.0" || results[1].DownloadsTotal != 21 || results[1].Samples != 2 {
+		t.Errorf("expected one rollup per day, with the newest version's stats, got %+v", results)
+	}
+}
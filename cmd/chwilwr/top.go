@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTopLimit = 20
+	maxTopLimit     = 100
+	// defaultTopPrevious is what rank changes are measured against unless previous is given.
+	defaultTopPrevious = "-1m"
+)
+
+// topCursorKeys are the parameters a leaderboard's cursors are tied to.
+var topCursorKeys = []string{"metric", "registry", "category", "license", "previous"}
+
+type TopResult struct {
+	Rank     int    `json:"rank"`
+	Package  string `json:"package"`
+	Registry string `json:"registry"`
+	Value    int64  `json:"value"`
+	// PreviousRank, PreviousValue and RankChange are null if the package wasn't ranked at previous. A positive
+	// RankChange means the package has climbed.
+	PreviousRank  *int   `json:"previousRank"`
+	PreviousValue *int64 `json:"previousValue"`
+	RankChange    *int   `json:"rankChange"`
+}
+
+func doTop(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	logger.Info("Top", zap.String("metric", params.Get("metric")), zap.String("category", params.Get("category")), zap.String("ip", r.RemoteAddr))
+
+	var details []FieldError
+	q := store.TopQuery{
+		Metric:   store.TopMetric(params.Get("metric")),
+		Category: params.Get("category"),
+		License:  params.Get("license"),
+	}
+	if q.Metric == "" {
+		q.Metric = store.TopDownloadsTotal
+	} else if !validTopMetric(q.Metric) {
+		metrics := make([]string, 0, len(store.TopMetrics))
+		for _, m := range store.TopMetrics {
+			metrics = append(metrics, string(m))
+		}
+		details = append(details, FieldError{Field: "metric", Message: "must be one of " + strings.Join(metrics, ", ")})
+	}
+
+	now := time.Now()
+	previous := params.Get("previous")
+	if previous == "" {
+		previous = defaultTopPrevious
+	}
+	var err error
+	q.Previous, err = parseStatsTime(previous, now)
+	if err != nil {
+		details = append(details, FieldError{Field: "previous", Message: err.Error()})
+	} else if !q.Previous.Before(now) {
+		details = append(details, FieldError{Field: "previous", Message: "must be in the past"})
+	}
+
+	var pageDetails []FieldError
+	q.Limit, q.Offset, pageDetails = parsePage(params, defaultTopLimit, maxTopLimit, topCursorKeys)
+	details = append(details, pageDetails...)
+	if len(details) > 0 {
+		writeInvalidParameters(w, r, details...)
+		return
+	}
+
+	// Every registry is ranked together unless one is asked for.
+	if name := params.Get("registry"); name != "" {
+		reg, ok := lookupRegistry(w, r, name)
+		if !ok {
+			return
+		}
+		q.RegistryID = reg.ID
+	}
+
+	results, total, err := repo.Top(r.Context(), q)
+	if err != nil {
+		writeInternalError(w, r, "Query failed", err, zap.String("metric", string(q.Metric)))
+		return
+	}
+
+	arr := make([]TopResult, 0, len(results))
+	for _, result := range results {
+		value := TopResult{
+			Rank:     result.Rank,
+			Package:  result.Name,
+			Registry: result.Registry,
+			Value:    result.Value,
+		}
+		if result.PreviousRank != 0 {
+			previousRank, previousValue, change := result.PreviousRank, result.PreviousValue, result.PreviousRank-result.Rank
+			value.PreviousRank, value.PreviousValue, value.RankChange = &previousRank, &previousValue, &change
+		}
+		arr = append(arr, value)
+	}
+
+	w.Header().Add("X-Total-Count", strconv.Itoa(total))
+	if next := q.Offset + len(results); len(results) > 0 && next < total {
+		w.Header().Add("X-Next-Cursor", encodeCursor(next, params, topCursorKeys))
+	}
+	writeJSON(w, http.StatusOK, arr)
+}
+
+func validTopMetric(metric store.TopMetric) bool {
+	for _, m := range store.TopMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestTop(t *testing.T) {
+	mem := setup(t)
+	ctx := context.Background()
+	for i, name := range []string{"vibe-d", "hunt", "silly"} {
+		mem.AddPackage(ctx, 1, name)
+		mem.EnsureVersion(ctx, i+1, "1.0.0")
+	}
+	mem.SetCategory(ctx, 1, "vibe-d", "library/web")
+	mem.SetCategory(ctx, 1, "hunt", "library/web/framework")
+	mem.SetCategory(ctx, 1, "silly", "development/testing")
+	mem.SetMetadata(ctx, 3, nil, "ISC")
+	mem.SetDependencies(ctx, 2, []string{"vibe-d"})
+	mem.SetDependencies(ctx, 3, []string{"vibe-d", "hunt"})
+
+	// vibe-d has overtaken hunt since two months ago, and silly didn't have any stats back then.
+	old, now := time.Now().AddDate(0, -2, 0), time.Now().Add(-time.Hour)
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: 1, Time: old, DownloadsTotal: 100})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: 2, Time: old, DownloadsTotal: 200})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: 1, Time: now, DownloadsTotal: 300})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: 2, Time: now, DownloadsTotal: 250})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: 3, Time: now, DownloadsTotal: 50})
+
+	var results []TopResult
+	w := get(t, "/v1/top?limit=2", &results)
+	if w.Code != http.StatusOK || len(results) != 2 || w.Header().Get("X-Total-Count") != "3" {
+		t.Fatalf("expected the first two of three packages, got %d %+v", w.Code, results)
+	}
+	if r := results[0]; r.Package != "vibe-d" || r.Rank != 1 || r.Value != 300 || r.PreviousRank == nil || *r.PreviousRank != 2 || *r.PreviousValue != 100 || *r.RankChange != 1 {
+		t.Errorf("expected vibe-d to have climbed to first, got %+v", r)
+	}
+	if r := results[1]; r.Package != "hunt" || r.Rank != 2 || r.RankChange == nil || *r.RankChange != -1 {
+		t.Errorf("expected hunt to have dropped to second, got %+v", r)
+	}
+
+	w = get(t, "/v1/top?limit=2&cursor="+w.Header().Get("X-Next-Cursor"), &results)
+	if w.Code != http.StatusOK || len(results) != 1 || results[0].Package != "silly" || results[0].Rank != 3 || results[0].PreviousRank != nil || results[0].RankChange != nil {
+		t.Errorf("expected silly to be new in third, got %d %+v", w.Code, results)
+	}
+	if w.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("expected no cursor after the last page, got %q", w.Header().Get("X-Next-Cursor"))
+	}
+
+	// The previous ranking can be taken from before any stats, which leaves nothing to compare against.
+	get(t, "/v1/top?previous=-1y", &results)
+	if len(results) != 3 || results[0].PreviousRank != nil {
+		t.Errorf("expected no previous ranks a year ago, got %+v", results)
+	}
+
+	get(t, "/v1/top?metric=dependents", &results)
+	if len(results) != 3 || results[0].Package != "vibe-d" || results[0].Value != 2 || results[1].Value != 1 || results[2].Value != 0 {
+		t.Errorf("expected vibe-d to have the most dependents, got %+v", results)
+	}
+
+	get(t, "/v1/top?category=Library/Web", &results)
+	if len(results) != 2 || results[0].Package != "vibe-d" || results[1].Package != "hunt" {
+		t.Errorf("expected the category to include its subcategories, got %+v", results)
+	}
+	get(t, "/v1/top?license=isc", &results)
+	if len(results) != 1 || results[0].Package != "silly" || results[0].Rank != 1 {
+		t.Errorf("expected only silly to be ISC licensed, got %+v", results)
+	}
+
+	if w := get(t, "/v1/top?registry=nope", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown registry to be rejected, got %d", w.Code)
+	}
+	cursor := encodeCursor(2, map[string][]string{"metric": {"stars"}}, topCursorKeys)
+	for url, field := range map[string]string{
+		"/v1/top?metric=likes":                  "metric",
+		"/v1/top?previous=soon":                 "previous",
+		"/v1/top?previous=2999":                 "previous",
+		"/v1/top?limit=101":                     "limit",
+		"/v1/top?offset=1&cursor=" + cursor:     "cursor",
+		"/v1/top?metric=forks&cursor=" + cursor: "cursor",
+	} {
+		w := get(t, url, nil)
+		if body := errorResult(t, w); w.Code != http.StatusBadRequest || len(body.Details) != 1 || body.Details[0].Field != field {
+			t.Errorf("%s: expected a bad %s, got %d %+v", url, field, w.Code, body)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/events"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+type WebhookResult struct {
+	ID     int      `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is only returned when the webhook is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newWebhookResult(w store.Webhook) WebhookResult {
+	evs := w.Events
+	if evs == nil {
+		evs = []string{}
+	}
+	return WebhookResult{ID: w.ID, URL: w.URL, Events: evs, CreatedAt: w.CreatedAt}
+}
+
+// requireAdmin only lets requests through that have the admin token as a bearer token. Without a token configured,
+// the endpoints don't exist.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.HTTP.AdminToken == "" {
+			notFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.HTTP.AdminToken)) != 1 {
+			logger.Error("Admin request without a valid token", zap.String("path", r.URL.Path), zap.String("ip", r.RemoteAddr))
+			writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "A valid admin token is needed as a bearer token.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func doCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil {
+		err = validateWebhook(req)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, err.Error())
+		return
+	}
+
+	// A secret is generated unless the subscriber wants to pick their own.
+	if req.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		req.Secret = hex.EncodeToString(secret)
+	}
+
+	hook, err := repo.AddWebhook(r.Context(), store.Webhook{URL: req.URL, Secret: req.Secret, Events: req.Events})
+	if err != nil {
+		writeInternalError(w, r, "Could not add webhook", err, zap.String("url", req.URL))
+		return
+	}
+	logger.Info("Added webhook", zap.Int("id", hook.ID), zap.String("url", hook.URL), zap.String("ip", r.RemoteAddr))
+
+	result := newWebhookResult(hook)
+	result.Secret = hook.Secret
+	writeJSON(w, http.StatusCreated, result)
+}
+
+func validateWebhook(req WebhookRequest) error {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("the URL must be an absolute http or https URL")
+	}
+
+outer:
+	for _, e := range req.Events {
+		for _, t := range events.Types {
+			if e == string(t) {
+				continue outer
+			}
+		}
+		return errors.New("unknown event type " + strconv.Quote(e))
+	}
+	return nil
+}
+
+func doListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := repo.Webhooks(r.Context())
+	if err != nil {
+		writeInternalError(w, r, "Could not list webhooks", err)
+		return
+	}
+
+	arr := make([]WebhookResult, 0, len(hooks))
+	for _, hook := range hooks {
+		arr = append(arr, newWebhookResult(hook))
+	}
+	writeJSON(w, http.StatusOK, arr)
+}
+
+func doDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(w, r)
+	if !ok {
+		return
+	}
+
+	err := repo.DeleteWebhook(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "There's no webhook with ID "+strconv.Itoa(id)+".")
+		return
+	} else if err != nil {
+		writeInternalError(w, r, "Could not delete webhook", err, zap.Int("id", id))
+		return
+	}
+	logger.Info("Deleted webhook", zap.Int("id", id), zap.String("ip", r.RemoteAddr))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeID parses the {id} in the route. Routes only match digits, so this only fails on overflow, in which case the
+// response has already been written.
+func routeID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeInvalidParameters(w, r, FieldError{Field: "id", Message: "is too large"})
+		return 0, false
+	}
+	return id, true
+}
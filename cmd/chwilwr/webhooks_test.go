@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func adminRequest(method string, url string, body string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, req)
+	return w
+}
+
+func TestWebhooks(t *testing.T) {
+	setup(t)
+	body := `{"url": "https://example.com/hook", "events": ["version.released"]}`
+
+	if w := adminRequest(http.MethodPost, "/v1/webhooks", body, "token"); w.Code != http.StatusNotFound {
+		t.Errorf("expected webhooks to be disabled without an admin token, got %d", w.Code)
+	}
+	cfg.HTTP.AdminToken = "token"
+	if w := adminRequest(http.MethodPost, "/v1/webhooks", body, "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a bad token to be refused, got %d", w.Code)
+	}
+
+	for _, bad := range []string{
+		`{"url": "ftp://example.com"}`,
+		`{"url": "/relative"}`,
+		`{"url": "https://example.com", "events": ["package.exploded"]}`,
+		`not json`,
+	} {
+		if w := adminRequest(http.MethodPost, "/v1/webhooks", bad, "token"); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", bad, w.Code)
+		}
+	}
+
+	w := adminRequest(http.MethodPost, "/v1/webhooks", body, "token")
+	var created WebhookResult
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if w.Code != http.StatusCreated || created.ID != 1 || len(created.Secret) != 64 || created.Events[0] != "version.released" {
+		t.Fatalf("expected the webhook to be created with a generated secret, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = adminRequest(http.MethodGet, "/v1/webhooks", "", "token")
+	var listed []WebhookResult
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].URL != "https://example.com/hook" || listed[0].Secret != "" {
+		t.Errorf("expected the webhook to be listed without its secret, got %s", w.Body.String())
+	}
+
+	if w := adminRequest(http.MethodDelete, "/v1/webhooks/1", "", "token"); w.Code != http.StatusNoContent {
+		t.Errorf("expected the webhook to be deleted, got %d", w.Code)
+	}
+	if w := adminRequest(http.MethodDelete, "/v1/webhooks/1", "", "token"); w.Code != http.StatusNotFound {
+		t.Errorf("expected deleting it again to 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/smtp"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/alerts"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+type alertNotifier interface {
+	Notify(ctx context.Context, rule store.AlertRule, note alerts.Notification) error
+}
+
+// notifier sends alert notifications. Email needs setupAlerts to have been called first.
+var notifier alertNotifier = &alerts.Notifier{HTTP: &http.Client{Timeout: time.Second * 10}}
+
+func setupAlerts() {
+	if cfg.SMTP.Addr == "" {
+		return
+	}
+	mail := &alerts.SMTP{Addr: cfg.SMTP.Addr, From: cfg.SMTP.From}
+	if cfg.SMTP.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.SMTP.Addr)
+		mail.Auth = smtp.PlainAuth("", cfg.SMTP.Username, cfg.SMTP.Password, host)
+	}
+	notifier = &alerts.Notifier{HTTP: &http.Client{Timeout: time.Second * 10}, SMTP: mail}
+}
+
+// loadAlertRules fetches the rules to evaluate during an update. Alerts are secondary to collecting snapshots, so a
+// failure only means no rules are evaluated.
+func loadAlertRules(ctx context.Context) []store.AlertRule {
+	rules, err := repo.AlertRules(ctx)
+	if err != nil {
+		logger.Error("Could not load alert rules, so none will be evaluated", zap.Error(err))
+		return nil
+	}
+	return rules
+}
+
+// evaluateAlerts checks every matching rule against the package's new snapshot, notifying whenever a rule starts or
+// stops firing. Errors are logged rather than failing the update, since the snapshot has already been stored.
+func (c *crawler) evaluateAlerts(ctx context.Context, rules []store.AlertRule, pkg store.Package, snapshot store.Snapshot) {
+	for _, rule := range rules {
+		if !alerts.Matches(rule, c.Name, pkg.Name) {
+			continue
+		}
+		err := c.evaluateAlert(ctx, rule, pkg, snapshot)
+		if err != nil {
+			logger.Error("Could not evaluate alert rule", zap.Int("rule", rule.ID), zap.String("registry", c.Name), zap.String("package", pkg.Name), zap.Error(err))
+		}
+	}
+}
+
+func (c *crawler) evaluateAlert(ctx context.Context, rule store.AlertRule, pkg store.Package, snapshot store.Snapshot) error {
+	var baseline *store.Snapshot
+	if rule.IsChange() {
+		b, err := repo.SnapshotAt(ctx, pkg.ID, snapshot.Time.Add(-rule.Window))
+		if err == nil {
+			baseline = &b
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+
+	firing, value, ok := alerts.Evaluate(rule, snapshot, baseline)
+	if !ok {
+		return nil
+	}
+
+	prev, err := repo.AlertState(ctx, rule.ID, pkg.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	changed := firing != prev.Firing
+	state := store.AlertState{RuleID: rule.ID, PackageID: pkg.ID, Firing: firing, Since: prev.Since, Value: value}
+	if changed || err != nil {
+		state.Since = snapshot.Time
+	}
+	if !changed {
+		return repo.SetAlertState(ctx, state)
+	}
+
+	note := alerts.Notification{
+		RuleID:    rule.ID,
+		Rule:      rule.Name,
+		State:     "resolved",
+		Registry:  c.Name,
+		Package:   pkg.Name,
+		Metric:    rule.Metric,
+		Value:     value,
+		Threshold: rule.Threshold,
+		Time:      snapshot.Time,
+	}
+	if firing {
+		note.State = "firing"
+	}
+	logger.Info("Alert changed state", zap.Int("rule", rule.ID), zap.String("package", pkg.Name), zap.String("state", note.State), zap.Float64("value", value))
+
+	// The new state is only stored once it's been sent, so a failed notification is tried again on the next update
+	// rather than being lost.
+	err = notifier.Notify(ctx, rule, note)
+	if err != nil {
+		metricAlertNotificationsFailed.Inc()
+		return err
+	}
+	metricAlertNotifications.WithLabelValues(note.State).Inc()
+	return repo.SetAlertState(ctx, state)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/alerts"
+	"github.com/BradleyChatha/ystadegau/pkg/alerts/smtptest"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestUpdatePackagesAlerts(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	ctx := context.Background()
+
+	mail, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mail.Close()
+
+	var mu sync.Mutex
+	var posted []alerts.Notification
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var note alerts.Notification
+		json.NewDecoder(r.Body).Decode(&note)
+		posted = append(posted, note)
+	}))
+	defer hook.Close()
+
+	notifier = &alerts.Notifier{HTTP: hook.Client(), SMTP: &alerts.SMTP{Addr: mail.Addr, From: "gwyliwr@example.com"}}
+
+	// The fixture has 1 star, 4 issues and 3 downloads in total, up from 1 download two days ago.
+	mem.AddPackage(ctx, 1, "slack-d")
+	ver, _ := mem.EnsureVersion(ctx, 1, "0.0.1")
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: time.Now().Add(-time.Hour * 48), DownloadsTotal: 1})
+	stars, _ := mem.AddAlertRule(ctx, store.AlertRule{Name: "starred", Package: "slack-d", Metric: "stars", Comparison: "above", Threshold: 0, Email: "me@example.com"})
+	growth, _ := mem.AddAlertRule(ctx, store.AlertRule{Name: "growing", Filter: "slack-*", Metric: "downloads_total", Comparison: "change_above", Threshold: 50, Window: time.Hour * 24, WebhookURL: hook.URL})
+	issues, _ := mem.AddAlertRule(ctx, store.AlertRule{Name: "issues", Metric: "issues", Comparison: "above", Threshold: 10, WebhookURL: hook.URL})
+	mem.AddAlertRule(ctx, store.AlertRule{Name: "elsewhere", Registry: "other", Metric: "stars", Comparison: "above", Threshold: 0, WebhookURL: hook.URL})
+	mem.SetAlertState(ctx, store.AlertState{RuleID: issues.ID, PackageID: 1, Firing: true, Since: time.Now().Add(-time.Hour), Value: 20})
+	mem.SetClock(func() time.Time { return time.Now().Add(time.Minute) })
+
+	err = updatePackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := mail.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "me@example.com" || !strings.Contains(messages[0].Data, "Subject: [firing] starred: dub slack-d") {
+		t.Errorf("expected an email about the starred rule, got %+v", messages)
+	}
+	if len(posted) != 2 {
+		t.Fatalf("expected 2 webhook notifications, got %+v", posted)
+	}
+	if note := posted[0]; note.RuleID != growth.ID || note.State != "firing" || note.Value != 200 {
+		t.Errorf("expected the growing rule to fire with a 200%% change, got %+v", note)
+	}
+	if note := posted[1]; note.RuleID != issues.ID || note.State != "resolved" || note.Value != 4 {
+		t.Errorf("expected the issues rule to resolve, got %+v", note)
+	}
+	state, _ := mem.AlertState(ctx, stars.ID, 1)
+	if !state.Firing || state.Value != 1 {
+		t.Errorf("expected the starred rule to be recorded as firing, got %+v", state)
+	}
+
+	// Nothing changes on the next update, so nothing more is sent.
+	mem.SetClock(func() time.Time { return time.Now().Add(store.UpdateInterval * 2) })
+	err = updatePackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mail.Messages()) != 1 || len(posted) != 2 {
+		t.Errorf("expected no more notifications, got %d emails and %+v", len(mail.Messages()), posted)
+	}
+}
+
+func TestAlertNotificationRetried(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	ctx := context.Background()
+
+	// The webhook fails the first time it's called.
+	var calls int
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer hook.Close()
+	notifier = &alerts.Notifier{HTTP: hook.Client()}
+
+	mem.AddPackage(ctx, 1, "slack-d")
+	rule, _ := mem.AddAlertRule(ctx, store.AlertRule{Name: "starred", Metric: "stars", Comparison: "above", Threshold: 0, WebhookURL: hook.URL})
+	mem.SetClock(func() time.Time { return time.Now().Add(time.Minute) })
+
+	updatePackages()
+	if _, err := mem.AlertState(ctx, rule.ID, 1); err != store.ErrNotFound {
+		t.Errorf("expected the state not to be stored while the notification failed, got %v", err)
+	}
+
+	mem.SetClock(func() time.Time { return time.Now().Add(store.UpdateInterval * 2) })
+	updatePackages()
+	if state, _ := mem.AlertState(ctx, rule.ID, 1); calls != 2 || !state.Firing {
+		t.Errorf("expected the notification to be sent again and the state stored, got %d calls and %+v", calls, state)
+	}
+}
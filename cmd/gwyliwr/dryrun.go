@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/alerts"
+	"github.com/BradleyChatha/ystadegau/pkg/events"
+	"github.com/BradleyChatha/ystadegau/pkg/semver"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+// dryRunStore reads from the underlying store as normal, but describes each write to out instead of performing it.
+type dryRunStore struct {
+	store.Store
+	out io.Writer
+}
+
+func newDryRunStore(s store.Store, out io.Writer) *dryRunStore {
+	return &dryRunStore{Store: s, out: out}
+}
+
+// EnsureRegistry returns registries that would be added with an ID of 0.
+func (d *dryRunStore) EnsureRegistry(ctx context.Context, name string, url string) (store.Registry, error) {
+	reg, err := d.Store.RegistryByName(ctx, name)
+	if errors.Is(err, store.ErrNotFound) {
+		fmt.Fprintf(d.out, "would add registry %s at %s\n", name, url)
+		return store.Registry{Name: name, URL: url}, nil
+	} else if err == nil && reg.URL != url {
+		fmt.Fprintf(d.out, "would change the URL of registry %s from %s to %s\n", name, reg.URL, url)
+		reg.URL = url
+	}
+	return reg, err
+}
+
+func (d *dryRunStore) AddPackage(ctx context.Context, registryID int, name string) (bool, error) {
+	_, err := d.Store.PackageByName(ctx, registryID, name)
+	if errors.Is(err, store.ErrNotFound) {
+		fmt.Fprintf(d.out, "would add package %s to registry %d\n", name, registryID)
+		return true, nil
+	}
+	return false, err
+}
+
+func (d *dryRunStore) MarkRemoved(_ context.Context, packageID int) (bool, error) {
+	fmt.Fprintf(d.out, "would mark package %d as removed\n", packageID)
+	return true, nil
+}
+
+func (d *dryRunStore) SetMetadata(_ context.Context, packageID int, authors []string, license string) error {
+	fmt.Fprintf(d.out, "would set the authors of package %d to %q and its license to %q\n", packageID, authors, license)
+	return nil
+}
+
+func (d *dryRunStore) SetCategory(_ context.Context, registryID int, name string, category string) error {
+	fmt.Fprintf(d.out, "would set the category of package %s in registry %d to %q\n", name, registryID, category)
+	return nil
+}
+
+func (d *dryRunStore) SetDependencies(_ context.Context, packageID int, names []string) error {
+	fmt.Fprintf(d.out, "would set the dependencies of package %d to %q\n", packageID, names)
+	return nil
+}
+
+// RecordValidationFailure doesn't record anything, so every failure counts as the first.
+func (d *dryRunStore) RecordValidationFailure(_ context.Context, packageID int, rules string) (int, error) {
+	if rules == "" {
+		fmt.Fprintf(d.out, "would reset the validation failures of package %d\n", packageID)
+		return 0, nil
+	}
+	fmt.Fprintf(d.out, "would record that the snapshot of package %d failed %s\n", packageID, rules)
+	return 1, nil
+}
+
+// SetReadme still compares hashes against the real store, so the output shows whether the documentation changed.
+func (d *dryRunStore) SetReadme(ctx context.Context, r store.Readme) (bool, error) {
+	stored, err := d.Store.Readme(ctx, r.VersionID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return false, err
+	}
+	if stored.Hash == store.ReadmeHash(r.Description, r.Readme) {
+		return false, nil
+	}
+	fmt.Fprintf(d.out, "would store the readme of version %d (%d byte description, %d byte readme)\n", r.VersionID, len(r.Description), len(r.Readme))
+	return true, nil
+}
+
+func (d *dryRunStore) UpdateSearchText(_ context.Context, packageID int, description string, readme string) error {
+	fmt.Fprintf(d.out, "would update the search text of package %d (%d byte description, %d byte readme)\n", packageID, len(description), len(readme))
+	return nil
+}
+
+func (d *dryRunStore) BumpUpdateTime(_ context.Context, packageID int) error {
+	fmt.Fprintf(d.out, "would push back the next update of package %d by %s\n", packageID, store.UpdateInterval)
+	return nil
+}
+
+// EnsureVersion returns versions that would be added with an ID of 0.
+func (d *dryRunStore) EnsureVersion(ctx context.Context, packageID int, semverStr string) (store.Version, error) {
+	ver, err := d.Store.FindVersion(ctx, packageID, semverStr)
+	if !errors.Is(err, store.ErrNotFound) {
+		return ver, err
+	}
+
+	_, err = semver.Parse(semverStr)
+	if err != nil {
+		return ver, err
+	}
+	fmt.Fprintf(d.out, "would add version %s to package %d\n", semverStr, packageID)
+	return store.Version{PackageID: packageID, Semver: semverStr}, nil
+}
+
+func (d *dryRunStore) AddSnapshot(_ context.Context, s store.Snapshot) error {
+	fmt.Fprintf(d.out, "would add a snapshot to version %d: %s\n", s.VersionID, describeSnapshot(s))
+	return nil
+}
+
+func (d *dryRunStore) ExtendSnapshot(_ context.Context, snapshotID int, to time.Time) error {
+	fmt.Fprintf(d.out, "would mark snapshot %d as unchanged until %s\n", snapshotID, to.Format(time.RFC3339))
+	return nil
+}
+
+func (d *dryRunStore) AddDailyDownloads(_ context.Context, packageID int, days []store.DailyDownloads) error {
+	var downloads float64
+	for _, day := range days {
+		downloads += day.Downloads
+	}
+	fmt.Fprintf(d.out, "would add %.1f downloads across %d days to package %d\n", downloads, len(days), packageID)
+	return nil
+}
+
+func (d *dryRunStore) Rollup(_ context.Context, resolution store.Resolution) (int64, error) {
+	fmt.Fprintf(d.out, "would roll up snapshots into %s buckets\n", resolution)
+	return 0, nil
+}
+
+func (d *dryRunStore) PruneSnapshots(_ context.Context, before time.Time) (int64, error) {
+	fmt.Fprintf(d.out, "would delete raw snapshots taken before %s\n", before.Format(time.RFC3339))
+	return 0, nil
+}
+
+func (d *dryRunStore) SetAlertState(_ context.Context, state store.AlertState) error {
+	fmt.Fprintf(d.out, "would record alert rule %d as firing=%t for package %d (value %.2f)\n", state.RuleID, state.Firing, state.PackageID, state.Value)
+	return nil
+}
+
+// dryRunNotifier describes each alert notification instead of sending it.
+type dryRunNotifier struct {
+	out io.Writer
+}
+
+func (d dryRunNotifier) Notify(_ context.Context, rule store.AlertRule, note alerts.Notification) error {
+	fmt.Fprintf(d.out, "would notify %s for rule %d\n", note.Subject(), rule.ID)
+	return nil
+}
+
+// dryRunSink describes each event instead of sending it.
+type dryRunSink struct {
+	out io.Writer
+}
+
+func (d dryRunSink) Publish(_ context.Context, e events.Event) error {
+	fmt.Fprintf(d.out, "would publish %s for package %s of registry %s", e.Type, e.Package, e.Registry)
+	switch {
+	case e.Version != "":
+		fmt.Fprintf(d.out, " (version %s)", e.Version)
+	case e.Metric != "":
+		fmt.Fprintf(d.out, " (%s reached %d)", e.Metric, e.Milestone)
+	}
+	fmt.Fprintln(d.out)
+	return nil
+}
+
+func describeSnapshot(s store.Snapshot) string {
+	desc := fmt.Sprintf(
+		"downloads %d weekly, %d monthly, %d total; %d stars, %d watchers, %d issues, %d forks",
+		s.DownloadsWeekly, s.DownloadsMonthly, s.DownloadsTotal, s.Stars, s.Watchers, s.Issues, s.Forks,
+	)
+	if s.Mirror != "" {
+		desc += " via " + s.Mirror
+	}
+	if s.Flagged() {
+		desc += fmt.Sprintf(" (flagged: %s)", s.FlagReason)
+	}
+	return desc
+}
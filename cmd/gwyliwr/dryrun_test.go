@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestDryRun(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "slack-d")
+
+	var out bytes.Buffer
+	repo = newDryRunStore(mem, &out)
+
+	err := updateNamedPackages(crawlers[0], []string{"slack-d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"would add version 0.0.1 to package 1",
+		"would add a snapshot to version 0: downloads 1 weekly, 2 monthly, 3 total",
+		"would update the search text of package 1",
+		"would push back the next update of package 1",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected the output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	if _, err := mem.LatestVersion(ctx, 1, true); err != store.ErrNotFound {
+		t.Errorf("expected no version to have been stored, got %v", err)
+	}
+	if results, _, _ := mem.Search(ctx, store.SearchQuery{Terms: []store.SearchTerm{{Words: []string{"slack"}}, {Words: []string{"api"}}}}); len(results) != 0 {
+		t.Errorf("expected the search text to be left alone, got %+v", results)
+	}
+}
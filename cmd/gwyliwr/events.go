@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BradleyChatha/ystadegau/pkg/events"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+// sink receives every event gwyliwr emits. It's replaced by setupEvents, so nothing is sent until then.
+var sink events.Sink = events.Nop{}
+
+// setupEvents creates the configured sink, delivering to it in the background. The returned sink should be closed
+// before exiting so that waiting events aren't lost.
+func setupEvents() (*events.Async, error) {
+	s, err := newEventSink()
+	if err != nil {
+		return nil, err
+	}
+	async := events.NewAsync(s, 1000, func(e events.Event, err error) {
+		logger.Error("Could not deliver event", zap.String("type", string(e.Type)), zap.String("package", e.Package), zap.Error(err))
+		metricEventsFailed.WithLabelValues(string(e.Type)).Inc()
+	})
+	sink = async
+	return async, nil
+}
+
+func newEventSink() (events.Sink, error) {
+	switch cfg.Events.Sink {
+	case "webhook":
+		hooks := events.NewWebhooks(repo)
+		hooks.HTTP.Timeout = cfg.Events.Timeout.Duration
+		hooks.Retries = cfg.Events.Retries
+		hooks.RetryDelay = cfg.Events.RetryDelay.Duration
+		return hooks, nil
+	case "queue":
+		if cfg.Events.QueueURL == "" {
+			return nil, fmt.Errorf("the queue event sink needs a queue URL, see EVENTS_QUEUE_URL")
+		}
+		return events.NewQueue(cfg.Events.QueueURL, cfg.AWS.Region)
+	case "none", "":
+		return events.Nop{}, nil
+	default:
+		return nil, fmt.Errorf("unknown event sink %q", cfg.Events.Sink)
+	}
+}
+
+// publish sends an event about one of the crawler's packages. Events are best effort, so failures are only logged.
+func (c *crawler) publish(ctx context.Context, t events.Type, pkg string, fill func(e *events.Event)) {
+	e := events.New(t, c.Name, pkg)
+	if fill != nil {
+		fill(&e)
+	}
+
+	err := sink.Publish(ctx, e)
+	if err != nil {
+		logger.Error("Could not publish event", zap.String("type", string(t)), zap.String("package", pkg), zap.Error(err))
+		metricEventsFailed.WithLabelValues(string(t)).Inc()
+		return
+	}
+	metricEventsPublished.WithLabelValues(string(t)).Inc()
+}
+
+// publishMilestones sends an event for each metric that passed a milestone between the two snapshots.
+func (c *crawler) publishMilestones(ctx context.Context, pkg string, prev store.Snapshot, next store.Snapshot) {
+	for _, m := range []struct {
+		metric     string
+		prev, next int64
+		min        int64
+	}{
+		{"downloads_total", prev.DownloadsTotal, next.DownloadsTotal, 100},
+		{"stars", int64(prev.Stars), int64(next.Stars), 10},
+	} {
+		milestone, ok := events.Milestone(m.prev, m.next, m.min)
+		if !ok {
+			continue
+		}
+		metric := m.metric
+		c.publish(ctx, events.MilestoneCrossed, pkg, func(e *events.Event) {
+			e.Metric = metric
+			e.Milestone = milestone
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/events"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (r *recordingSink) Publish(_ context.Context, e events.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+// take returns the events published since it was last called.
+func (r *recordingSink) take() []events.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	taken := r.events
+	r.events = nil
+	return taken
+}
+
+func TestEventsPackageRegistered(t *testing.T) {
+	setup(t, nil)
+	rec := &recordingSink{}
+	sink = rec
+
+	crawlers[0].updatePackageList(0, 20)
+	published := rec.take()
+	if len(published) != 20 || published[0].Type != events.PackageRegistered || published[0].Registry != "dub" {
+		t.Errorf("expected an event for each new package, got %+v", published)
+	}
+
+	crawlers[0].updatePackageList(0, 20)
+	if published := rec.take(); len(published) != 0 {
+		t.Errorf("expected no events for packages that already exist, got %+v", published)
+	}
+}
+
+func TestEventsVersionReleasedAndRemoved(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.2", "new": "1.0.0"})
+	rec := &recordingSink{}
+	sink = rec
+	ctx := context.Background()
+
+	mem.AddPackage(ctx, 1, "slack-d")
+	mem.EnsureVersion(ctx, 1, "0.0.1")
+	mem.AddPackage(ctx, 1, "new")
+	mem.AddPackage(ctx, 1, "gone")
+	mem.SetClock(func() time.Time { return time.Now().Add(time.Minute) })
+
+	updatePackages()
+	published := rec.take()
+	if len(published) != 2 {
+		t.Fatalf("expected 2 events, got %+v", published)
+	}
+	if e := published[0]; e.Type != events.VersionReleased || e.Package != "slack-d" || e.Version != "0.0.2" {
+		t.Errorf("expected slack-d 0.0.2 to have been released, got %+v", e)
+	}
+	if e := published[1]; e.Type != events.PackageRemoved || e.Package != "gone" {
+		t.Errorf("expected gone to have been removed, got %+v", e)
+	}
+
+	// Removed packages aren't updated again until they're listed again.
+	mem.SetClock(func() time.Time { return time.Now().Add(store.UpdateInterval * 2) })
+	updatePackages()
+	if published := rec.take(); len(published) != 0 {
+		t.Errorf("expected no more events, got %+v", published)
+	}
+	due, _ := mem.PackagesDue(ctx)
+	if len(due) != 0 {
+		t.Errorf("expected the removed package not to be due, got %+v", due)
+	}
+	if added, _ := mem.AddPackage(ctx, 1, "gone"); !added {
+		t.Error("expected the removed package to be restored")
+	}
+}
+
+func TestEventsMilestones(t *testing.T) {
+	setup(t, nil)
+	rec := &recordingSink{}
+	sink = rec
+
+	crawlers[0].publishMilestones(context.Background(), "vibe-d", store.Snapshot{DownloadsTotal: 950, Stars: 9}, store.Snapshot{DownloadsTotal: 1020, Stars: 9})
+	published := rec.take()
+	if len(published) != 1 || published[0].Metric != "downloads_total" || published[0].Milestone != 1000 {
+		t.Errorf("expected the downloads to have passed 1000, got %+v", published)
+	}
+}
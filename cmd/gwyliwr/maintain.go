@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+// maintain brings every rollup up to date, and then deletes any raw snapshots older than the configured retention.
+func maintain(ctx context.Context) error {
+	for _, resolution := range store.Rollups {
+		written, err := repo.Rollup(ctx, resolution)
+		if err != nil {
+			return err
+		}
+		logger.Info("Rolled up snapshots", zap.String("resolution", string(resolution)), zap.Int64("buckets", written))
+	}
+
+	retention := cfg.Retention.RawSnapshots.Duration
+	if retention <= 0 {
+		return nil
+	}
+	pruned, err := repo.PruneSnapshots(ctx, pruneBefore(time.Now(), retention))
+	if err != nil {
+		return err
+	}
+	logger.Info("Pruned raw snapshots", zap.Int64("snapshots", pruned))
+	return nil
+}
+
+// pruneBefore only ever prunes whole months, so the rollups above never have to be recalculated from a bucket that's
+// only partly there.
+func pruneBefore(now time.Time, retention time.Duration) time.Time {
+	return store.Monthly.Truncate(now.Add(-retention))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/config"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestMaintain(t *testing.T) {
+	mem := setup(t, nil)
+	ctx := context.Background()
+
+	now := time.Now()
+	old := now.AddDate(0, -3, 0)
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: 1, Time: old, DownloadsTotal: 1})
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: 1, Time: now, DownloadsTotal: 2})
+
+	cfg.Retention.RawSnapshots = config.Duration{Duration: time.Hour * 24 * 31}
+	err := maintain(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := mem.Snapshots(ctx, store.SnapshotQuery{VersionID: 1})
+	if len(raw) != 1 || raw[0].DownloadsTotal != 2 {
+		t.Errorf("expected only the recent raw snapshot to be kept, got %+v", raw)
+	}
+	for _, resolution := range store.Rollups {
+		rollups, _ := mem.Snapshots(ctx, store.SnapshotQuery{VersionID: 1, Resolution: resolution})
+		if len(rollups) != 2 {
+			t.Errorf("expected both snapshots to have been rolled up into %s buckets first, got %+v", resolution, rollups)
+		}
+	}
+}
+
+func TestPruneBefore(t *testing.T) {
+	now := time.Date(2021, 10, 18, 12, 0, 0, 0, time.UTC)
+	got := pruneBefore(now, time.Hour*24*60)
+	if want := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+var (
+	metricPackagesUpdated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "packages_updated_total",
+		Help:      "Number of packages that have had a new snapshot stored.",
+	})
+	metricPackagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "packages_failed_total",
+		Help:      "Number of package updates that failed, by the stage they failed in.",
+	}, []string{"stage"})
+	metricQueueReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "queue_messages_received_total",
+		Help:      "Number of messages received from the command queue.",
+	})
+	metricQueueDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "queue_messages_deleted_total",
+		Help:      "Number of messages deleted from the command queue after being handled.",
+	})
+	metricRegistryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gwyliwr",
+		Name:      "registry_request_duration_seconds",
+		Help:      "Latency of requests made to the dub registry.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code", "method"})
+	metricRegistryRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "registry_requests_total",
+		Help:      "Number of requests made to the dub registry, by status code.",
+	}, []string{"code", "method"})
+	metricRateLimitWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gwyliwr",
+		Name:      "rate_limiter_wait_seconds",
+		Help:      "Time spent waiting on the rate limiter before contacting the registry.",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30},
+	})
+	metricRegistryFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "registry_failovers_total",
+		Help:      "Number of times a registry mirror failed and the next one was tried, by the mirror that failed.",
+	}, []string{"mirror"})
+	metricSnapshotsInvalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "snapshots_invalid_total",
+		Help:      "Number of snapshots that failed validation, by the rule they broke.",
+	}, []string{"rule"})
+	metricSnapshotsAcceptedAfterFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "snapshots_accepted_after_failures_total",
+		Help:      "Number of snapshots stored unflagged despite failing validation, after breaking the same rules too many times in a row.",
+	})
+	metricEventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "events_published_total",
+		Help:      "Number of events handed to the event sink, by type.",
+	}, []string{"type"})
+	metricEventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "events_failed_total",
+		Help:      "Number of events that couldn't be delivered, by type.",
+	}, []string{"type"})
+	metricAlertNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "alert_notifications_total",
+		Help:      "Number of alert notifications sent, by whether the alert started firing or resolved.",
+	}, []string{"state"})
+	metricAlertNotificationsFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gwyliwr",
+		Name:      "alert_notifications_failed_total",
+		Help:      "Number of alert notifications that couldn't be sent.",
+	})
+)
+
+// registryClient is used for every request to code.dlang.org so that latency and status codes are recorded.
+var registryClient = &http.Client{
+	Timeout: time.Second * 30,
+	Transport: promhttp.InstrumentRoundTripperCounter(
+		metricRegistryRequests,
+		promhttp.InstrumentRoundTripperDuration(metricRegistryDuration, http.DefaultTransport),
+	),
+}
+
+func registerOverdueGauge() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "gwyliwr",
+		Name:      "packages_overdue",
+		Help:      "Number of packages whose next_update is in the past.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		count, err := repo.CountOverdue(ctx)
+		if err != nil {
+			logger.Error("Could not count overdue packages", zap.Error(err))
+			return math.NaN()
+		}
+		return float64(count)
+	})
+}
+
+// registerMirrorGauges reports whether each registry mirror is currently in use, i.e. it hasn't recently failed.
+func registerMirrorGauges() {
+	for _, c := range crawlers {
+		for _, baseURL := range c.client.Mirrors() {
+			client, baseURL := c.client, baseURL
+			promauto.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace:   "gwyliwr",
+				Name:        "registry_mirror_up",
+				Help:        "Whether the registry mirror is healthy (1) or being skipped (0).",
+				ConstLabels: prometheus.Labels{"registry": c.Name, "mirror": baseURL},
+			}, func() float64 {
+				if client.Healthy(baseURL) {
+					return 1
+				}
+				return 0
+			})
+		}
+	}
+}
+
+func metricsMain(addr string) {
+	registerOverdueGauge()
+	registerMirrorGauges()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			logger.Error("Metrics server stopped", zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/BradleyChatha/ystadegau/pkg/config"
+	"github.com/BradleyChatha/ystadegau/pkg/dub"
+	"github.com/BradleyChatha/ystadegau/pkg/secrets"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// crawler is everything needed to fetch packages from a single registry, which each have their own client and
+// rate limit.
+type crawler struct {
+	store.Registry
+	client  *dub.Client
+	limiter *rate.Limiter
+}
+
+// crawlers holds every configured registry, with the main one (cfg.Registry) first.
+var crawlers []*crawler
+
+func crawlerByID(id int) *crawler {
+	for _, c := range crawlers {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func crawlerByName(name string) (*crawler, error) {
+	for _, c := range crawlers {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no registry called %q has been configured", name)
+}
+
+// setupCrawlers makes sure every configured registry exists in the database, and creates a client for each.
+func setupCrawlers(ctx context.Context) error {
+	crawlers = nil
+	for _, reg := range cfg.AllRegistries() {
+		if reg.Name == "" {
+			return fmt.Errorf("the registry at %s needs a name", reg.URL)
+		}
+
+		client, err := newRegistryClient(ctx, reg)
+		if err != nil {
+			return fmt.Errorf("registry %s: %w", reg.Name, err)
+		}
+		stored, err := repo.EnsureRegistry(ctx, reg.Name, reg.URL)
+		if err != nil {
+			return fmt.Errorf("registry %s: %w", reg.Name, err)
+		}
+
+		crawlers = append(crawlers, &crawler{
+			Registry: stored,
+			client:   client,
+			limiter:  rate.NewLimiter(rate.Every(reg.RateLimit.Interval.Duration), reg.RateLimit.Burst),
+		})
+		if len(reg.Mirrors) > 0 {
+			go client.Watch(ctx, reg.HealthCheckInterval.Duration)
+		}
+	}
+	return nil
+}
+
+func newRegistryClient(ctx context.Context, reg config.Registry) (*dub.Client, error) {
+	httpClient := *registryClient
+	httpClient.Timeout = reg.Timeout.Duration
+
+	client := dub.NewClient(reg.URL, &httpClient, reg.Mirrors...)
+	client.RetryAfter = reg.HealthCheckInterval.Duration
+	client.OnFailover = func(baseURL string, err error) {
+		logger.Warn("Registry mirror failed, trying the next one", zap.String("registry", reg.Name), zap.String("mirror", baseURL), zap.Error(err))
+		metricRegistryFailovers.WithLabelValues(baseURL).Inc()
+	}
+
+	if reg.UserKey == "" {
+		return client, nil
+	}
+	if cfg.Secrets.Provider == "" {
+		return nil, errors.New("credentials were given but no secrets provider has been set, see SECRETS_PROVIDER")
+	}
+	provider, err := secrets.New(cfg.Secrets.Provider, cfg.Secrets.Dir, cfg.AWS.Region)
+	if err != nil {
+		return nil, err
+	}
+	client.Username, err = provider.Get(ctx, reg.UserKey)
+	if err != nil {
+		return nil, err
+	}
+	client.Password, err = provider.Get(ctx, reg.PassKey)
+	return client, err
+}
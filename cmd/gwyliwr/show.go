@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+// showPackage prints what's stored about a package next to what the registry currently reports, so it's easy to see
+// whether an update would change anything.
+func (c *crawler) showPackage(ctx context.Context, out io.Writer, name string) error {
+	fmt.Fprintf(out, "Package:          %s\n", name)
+	fmt.Fprintf(out, "Registry:         %s (%s)\n", c.Name, c.URL)
+
+	pkg, err := repo.PackageByName(ctx, c.ID, name)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		fmt.Fprintln(out, "Stored:           no")
+	case err != nil:
+		return err
+	default:
+		err = showStored(ctx, out, pkg)
+		if err != nil {
+			return err
+		}
+	}
+
+	ver, err := c.client.LatestVersion(ctx, name)
+	if err != nil {
+		return err
+	}
+	stats, info, err := c.getStatsAndInfo(ctx, name, ver)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Registry version: %s (%s)\n", ver, info.Date.Format(time.RFC3339))
+	fmt.Fprintf(out, "Registry stats:   %s\n", describeSnapshot(store.Snapshot{
+		DownloadsWeekly:  stats.Downloads.Weekly,
+		DownloadsMonthly: stats.Downloads.Monthly,
+		DownloadsTotal:   int64(stats.Downloads.Total),
+		Stars:            stats.Repo.Stars,
+		Watchers:         stats.Repo.Watchers,
+		Issues:           stats.Repo.Issues,
+		Forks:            stats.Repo.Forks,
+		Mirror:           stats.Mirror,
+	}))
+	fmt.Fprintf(out, "Description:      %s\n", info.Info.Description)
+	return nil
+}
+
+func showStored(ctx context.Context, out io.Writer, pkg store.Package) error {
+	fmt.Fprintf(out, "Stored:           yes (id %d)\n", pkg.ID)
+	fmt.Fprintf(out, "Next update:      %s\n", pkg.NextUpdate.Format(time.RFC3339))
+
+	for _, v := range []struct {
+		label             string
+		includePrerelease bool
+	}{
+		{"Latest stable:    ", false},
+		{"Latest overall:   ", true},
+	} {
+		ver, err := repo.LatestVersion(ctx, pkg.ID, v.includePrerelease)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			fmt.Fprintln(out, v.label+"none")
+		case err != nil:
+			return err
+		default:
+			fmt.Fprintln(out, v.label+ver.Semver)
+		}
+	}
+
+	last, err := repo.LastSnapshot(ctx, pkg.ID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		fmt.Fprintln(out, "Last snapshot:    none")
+	case err != nil:
+		return err
+	default:
+		fmt.Fprintf(out, "Last snapshot:    %s at %s\n", describeSnapshot(last), last.Time.Format(time.RFC3339))
+		if last.ValidTo.After(last.Time) {
+			fmt.Fprintf(out, "Unchanged until:  %s\n", last.ValidTo.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
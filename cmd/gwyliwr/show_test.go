@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestShowPackage(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	err := crawlers[0].showPackage(ctx, &out, "slack-d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Stored:           no", "Registry version: 0.0.1", "Description:      Slack API for D"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected the output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	mem.AddPackage(ctx, 1, "slack-d")
+	ver, _ := mem.EnsureVersion(ctx, 1, "0.0.1")
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: time.Now(), DownloadsTotal: 2})
+
+	out.Reset()
+	err = crawlers[0].showPackage(ctx, &out, "slack-d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Stored:           yes (id 1)", "Latest stable:    0.0.1", "Last snapshot:    downloads 0 weekly, 0 monthly, 2 total"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected the output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	if err := crawlers[0].showPackage(ctx, &out, "missing"); err == nil {
+		t.Error("expected an error for a package the registry doesn't know")
+	}
+}
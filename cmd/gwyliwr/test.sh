@@ -7,5 +7,4 @@ export DB_USER=postgres
 export DB_PASS=test
 export DB_DB=test
 export DB_SSL=disable
-export MODE=test
-go run .
\ No newline at end of file
+go test ./...
@@ -7,5 +7,6 @@ export DB_USER=postgres
 export DB_PASS=test
 export DB_DB=test
 export DB_SSL=disable
-export MODE=test-live
-go run .
\ No newline at end of file
+go run . update-list
+go run . update jioc
+go run . show jioc
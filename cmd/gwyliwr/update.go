@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/dub"
+	"github.com/BradleyChatha/ystadegau/pkg/events"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+)
+
+func updatePackages() error {
+	ctx := context.Background()
+	pkgs, err := repo.PackagesDue(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = updateEach(ctx, pkgs)
+	return err
+}
+
+// updateNamedPackages updates the given packages of a registry whether they're due or not. Unlike updatePackages, it
+// fails if any of them couldn't be updated, since someone's presumably waiting on the result.
+func updateNamedPackages(c *crawler, names []string) error {
+	ctx := context.Background()
+	pkgs := make([]store.Package, 0, len(names))
+	for _, name := range names {
+		pkg, err := repo.PackageByName(ctx, c.ID, name)
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("package %s isn't known yet, run update-list first", name)
+		} else if err != nil {
+			return err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	failed, err := updateEach(ctx, pkgs)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d packages failed to update", failed, len(pkgs))
+	}
+	return nil
+}
+
+// updateEach updates every package, with the packages of each registry updated in turn and sticking to that registry's
+// rate limit. Registries are updated concurrently, so a slow public registry doesn't hold up a private one. Failures
+// are logged and counted rather than stopping the rest of the packages from updating.
+func updateEach(ctx context.Context, pkgs []store.Package) (failed int, err error) {
+	byRegistry := make(map[int][]store.Package)
+	for _, pkg := range pkgs {
+		byRegistry[pkg.RegistryID] = append(byRegistry[pkg.RegistryID], pkg)
+	}
+
+	rules := loadAlertRules(ctx)
+
+	type result struct {
+		failed int
+		err    error
+	}
+	results := make(chan result, len(byRegistry))
+	for registryID, pkgs := range byRegistry {
+		c := crawlerByID(registryID)
+		if c == nil {
+			logger.Error("Packages belong to a registry that isn't configured", zap.Int("registry_id", registryID), zap.Int("packages", len(pkgs)))
+			metricPackagesFailed.WithLabelValues("registry").Add(float64(len(pkgs)))
+			results <- result{failed: len(pkgs)}
+			continue
+		}
+
+		go func(c *crawler, pkgs []store.Package) {
+			failed, err := c.updateEach(ctx, pkgs, rules)
+			results <- result{failed, err}
+		}(c, pkgs)
+	}
+
+	for range byRegistry {
+		r := <-results
+		failed += r.failed
+		if r.err != nil {
+			err = r.err
+		}
+	}
+	return failed, err
+}
+
+func (c *crawler) updateEach(ctx context.Context, pkgs []store.Package, rules []store.AlertRule) (failed int, err error) {
+	for _, pkg := range pkgs {
+		waitStart := time.Now()
+		err = c.limiter.Wait(ctx)
+		if err != nil {
+			return failed, err
+		}
+		metricRateLimitWait.Observe(time.Since(waitStart).Seconds())
+
+		logger.Info("Updating package", zap.String("registry", c.Name), zap.String("package", pkg.Name))
+		stage, err := c.updatePackage(ctx, pkg, rules)
+		if err != nil {
+			logger.Error("Error updating package", zap.String("registry", c.Name), zap.String("package", pkg.Name), zap.String("stage", stage), zap.Error(err))
+			metricPackagesFailed.WithLabelValues(stage).Inc()
+			failed++
+			continue
+		}
+
+		metricPackagesUpdated.Inc()
+	}
+	return failed, nil
+}
+
+// updatePackage takes a new snapshot of the package's latest version, then evaluates the alert rules against it. On
+// failure it also returns the name of the stage that failed, for logging and metrics.
+func (c *crawler) updatePackage(ctx context.Context, pkg store.Package, rules []store.AlertRule) (string, error) {
+	ver, err := c.client.LatestVersion(ctx, pkg.Name)
+	var statusErr *dub.StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		return "removed", c.markRemoved(ctx, pkg, err)
+	} else if err != nil {
+		return "latest_version", err
+	}
+
+	// A version is only announced as released if the package already had an older one.
+	released := false
+	if _, err = repo.FindVersion(ctx, pkg.ID, ver); errors.Is(err, store.ErrNotFound) {
+		_, err = repo.LatestVersion(ctx, pkg.ID, true)
+		released = err == nil
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return "version", err
+	}
+
+	version, err := repo.EnsureVersion(ctx, pkg.ID, ver)
+	if err != nil {
+		return "version", err
+	}
+
+	stats, info, err := c.getStatsAndInfo(ctx, pkg.Name, ver)
+	if err != nil {
+		return "stats", err
+	}
+
+	snapshot := store.Snapshot{
+		VersionID:        version.ID,
+		Time:             time.Now(),
+		DownloadsWeekly:  stats.Downloads.Weekly,
+		DownloadsMonthly: stats.Downloads.Monthly,
+		DownloadsTotal:   int64(stats.Downloads.Total),
+		Stars:            stats.Repo.Stars,
+		Watchers:         stats.Repo.Watchers,
+		Issues:           stats.Repo.Issues,
+		Forks:            stats.Repo.Forks,
+		Mirror:           stats.Mirror,
+	}
+	prev, err := validate(ctx, pkg, &snapshot)
+	if err != nil {
+		return "validation", err
+	}
+
+	err = storeSnapshot(ctx, prev, snapshot)
+	if err != nil {
+		return "snapshot", err
+	}
+
+	// The downloads made since the previous snapshot are spread over the days in between, from when the previous
+	// total was last seen.
+	if prev != nil && !snapshot.Flagged() {
+		days := store.SpreadDownloads(prev.ValidTo, snapshot.Time, snapshot.DownloadsTotal-prev.DownloadsTotal)
+		err = repo.AddDailyDownloads(ctx, pkg.ID, days)
+		if err != nil {
+			return "downloads", err
+		}
+		c.publishMilestones(ctx, pkg.Name, *prev, snapshot)
+	}
+	if !snapshot.Flagged() {
+		c.evaluateAlerts(ctx, rules, pkg, snapshot)
+	}
+	if released {
+		c.publish(ctx, events.VersionReleased, pkg.Name, func(e *events.Event) { e.Version = ver })
+	}
+
+	err = repo.SetMetadata(ctx, pkg.ID, info.Info.Authors, info.Info.License)
+	if err != nil {
+		return "metadata", err
+	}
+
+	err = repo.SetDependencies(ctx, pkg.ID, info.Info.DependencyNames())
+	if err != nil {
+		return "dependencies", err
+	}
+
+	// The search text only needs rebuilding when the documentation changed.
+	changed, err := repo.SetReadme(ctx, store.Readme{VersionID: version.ID, Description: info.Info.Description, Readme: info.Readme})
+	if err != nil {
+		return "readme", err
+	}
+	if changed {
+		err = repo.UpdateSearchText(ctx, pkg.ID, info.Info.Description, info.Readme)
+		if err != nil {
+			return "query_vector", err
+		}
+	}
+
+	err = repo.BumpUpdateTime(ctx, pkg.ID)
+	if err != nil {
+		return "bump", err
+	}
+	return "", nil
+}
+
+// markRemoved stops updating a package the registry no longer knows about, announcing it the first time. The original
+// error is returned so the update still counts as failed.
+func (c *crawler) markRemoved(ctx context.Context, pkg store.Package, notFound error) error {
+	removed, err := repo.MarkRemoved(ctx, pkg.ID)
+	if err != nil {
+		return err
+	}
+	if removed {
+		logger.Warn("Package has been removed from the registry", zap.String("registry", c.Name), zap.String("package", pkg.Name))
+		c.publish(ctx, events.PackageRemoved, pkg.Name, nil)
+	}
+	return notFound
+}
+
+// validate compares the snapshot against the package's last good one, which is returned if there is one. Depending on
+// the configured mode, a snapshot that breaks any rule is either flagged so it's kept out of the stats by default, or
+// rejected outright so the package stays due and gets retried on the next run. Once the package's snapshots have broken
+// the same rules more than Validation.MaxFailures times in a row, the snapshot is accepted unflagged.
+func validate(ctx context.Context, pkg store.Package, snapshot *store.Snapshot) (*store.Snapshot, error) {
+	var prev *store.Snapshot
+	last, err := repo.LastSnapshot(ctx, pkg.ID)
+	if err == nil {
+		prev = &last
+	} else if err != store.ErrNotFound {
+		return nil, err
+	}
+
+	failed, reason := validateSnapshot(prev, *snapshot)
+	if len(failed) == 0 {
+		if pkg.ValidationFailures > 0 {
+			_, err := repo.RecordValidationFailure(ctx, pkg.ID, "")
+			return prev, err
+		}
+		return prev, nil
+	}
+	for _, rule := range failed {
+		metricSnapshotsInvalid.WithLabelValues(rule).Inc()
+	}
+
+	// Breaking the same rules over and over means the registry is sticking to its figures, such as after correcting its
+	// download counts. The last good snapshot never moves on while they're rejected or flagged, so without a limit the
+	// package would be stuck failing forever.
+	failures, err := repo.RecordValidationFailure(ctx, pkg.ID, strings.Join(failed, ","))
+	if err != nil {
+		return nil, err
+	}
+	if limit := cfg.Validation.MaxFailures; limit > 0 && failures > limit {
+		logger.Warn("Accepting snapshot after repeated validation failures", zap.String("package", pkg.Name), zap.Int("failures", failures), zap.String("reason", reason))
+		metricSnapshotsAcceptedAfterFailures.Inc()
+		_, err = repo.RecordValidationFailure(ctx, pkg.ID, "")
+		return prev, err
+	}
+
+	if cfg.Validation.Mode == "reject" {
+		return nil, fmt.Errorf("snapshot rejected (%d in a row): %s", failures, reason)
+	}
+	logger.Warn("Flagging snapshot", zap.String("package", pkg.Name), zap.String("reason", reason))
+	snapshot.FlagReason = reason
+	return prev, nil
+}
+
+// storeSnapshot adds the snapshot, unless change-only storage is enabled and nothing has changed since prev, in which
+// case prev is extended to cover it instead. Flagged snapshots always get their own row.
+func storeSnapshot(ctx context.Context, prev *store.Snapshot, snapshot store.Snapshot) error {
+	if cfg.Storage.Snapshots == "changes" && prev != nil && !snapshot.Flagged() &&
+		prev.VersionID == snapshot.VersionID && prev.SameMetrics(snapshot) {
+		return repo.ExtendSnapshot(ctx, prev.ID, snapshot.Time)
+	}
+	return repo.AddSnapshot(ctx, snapshot)
+}
+
+// updatePackageLists refreshes the package list of every registry, carrying on with the rest if one fails.
+func updatePackageLists(skip int, limit int) error {
+	var err error
+	for _, c := range crawlers {
+		listErr := c.updatePackageList(skip, limit)
+		if listErr != nil {
+			logger.Error("Error refreshing package list", zap.String("registry", c.Name), zap.Error(listErr))
+			err = listErr
+		}
+	}
+	return err
+}
+
+func (c *crawler) updatePackageList(skip int, limit int) error {
+	ctx := context.Background()
+	listings, err := c.client.Listing(ctx, skip, limit)
+	if err != nil {
+		return err
+	}
+
+	for _, listing := range listings {
+		added, err := repo.AddPackage(ctx, c.ID, listing.Name)
+		if err != nil {
+			logger.Error("Failed to add package into database", zap.String("registry", c.Name), zap.String("package", listing.Name), zap.Error(err))
+			continue
+		}
+		if added {
+			logger.Info("Added package", zap.String("registry", c.Name), zap.String("package", listing.Name))
+			c.publish(ctx, events.PackageRegistered, listing.Name, nil)
+		}
+
+		// Categories can be changed by the package's owner, so they're refreshed along with the list.
+		err = repo.SetCategory(ctx, c.ID, listing.Name, listing.Category)
+		if err != nil {
+			logger.Error("Failed to set package category", zap.String("registry", c.Name), zap.String("package", listing.Name), zap.Error(err))
+		}
+	}
+
+	logger.Info("Packages list has been refreshed.", zap.String("registry", c.Name))
+	return nil
+}
+
+func (c *crawler) getStatsAndInfo(ctx context.Context, pkg string, ver string) (stats dub.PackageStats, info dub.PackageInfo, err error) {
+	info, err = c.client.Info(ctx, pkg, ver)
+	if err != nil {
+		return
+	}
+	stats, err = c.client.Stats(ctx, pkg)
+	return
+}
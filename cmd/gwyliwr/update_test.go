@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/alerts"
+	"github.com/BradleyChatha/ystadegau/pkg/config"
+	"github.com/BradleyChatha/ystadegau/pkg/dub"
+	"github.com/BradleyChatha/ystadegau/pkg/dub/dubtest"
+	"github.com/BradleyChatha/ystadegau/pkg/events"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// setup points the updater's globals at an in-memory store and a fake registry that knows about the given packages.
+func setup(t *testing.T, versions map[string]string) *store.Memory {
+	t.Helper()
+
+	mem := store.NewMemory()
+	repo = mem
+	crawlers = nil
+	logger = zap.NewNop()
+	sink = events.Nop{}
+	notifier = &alerts.Notifier{HTTP: http.DefaultClient}
+	cfg = &config.Config{Validation: config.Validation{Mode: "flag"}}
+	addCrawler(t, "dub", versions)
+	return mem
+}
+
+// addCrawler adds another fake registry, which knows about the given packages.
+func addCrawler(t *testing.T, name string, versions map[string]string) *crawler {
+	t.Helper()
+
+	server := httptest.NewServer(&dubtest.Registry{Versions: versions})
+	t.Cleanup(server.Close)
+
+	reg, err := repo.EnsureRegistry(context.Background(), name, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &crawler{
+		Registry: reg,
+		client:   dub.NewClient(server.URL, server.Client()),
+		limiter:  rate.NewLimiter(rate.Inf, 1),
+	}
+	crawlers = append(crawlers, c)
+	return c
+}
+
+func TestUpdatePackageList(t *testing.T) {
+	mem := setup(t, nil)
+	ctx := context.Background()
+
+	err := crawlers[0].updatePackageList(0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"slack-d", "eventy", "symmetry_libssh-d"} {
+		_, err := mem.PackageByName(ctx, 1, name)
+		if err != nil {
+			t.Errorf("expected %s to have been added: %v", name, err)
+		}
+	}
+
+	top, _, _ := mem.Top(ctx, store.TopQuery{Metric: store.TopDependents, Category: "library/gamedev"})
+	if len(top) != 1 || top[0].Name != "vsignal" {
+		t.Errorf("expected the listing's categories to be stored, got %+v", top)
+	}
+
+	// Running it again shouldn't duplicate anything.
+	err = crawlers[0].updatePackageList(0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.SetClock(func() time.Time { return time.Now().Add(time.Minute) })
+	due, _ := mem.PackagesDue(ctx)
+	if len(due) != 20 {
+		t.Errorf("expected 20 packages, got %d", len(due))
+	}
+}
+
+func TestUpdatePackages(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	ctx := context.Background()
+
+	mem.AddPackage(ctx, 1, "slack-d")
+	mem.AddPackage(ctx, 1, "missing")
+	mem.SetClock(func() time.Time { return time.Now().Add(time.Minute) })
+
+	err := updatePackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, _ := mem.PackageByName(ctx, 1, "slack-d")
+	ver, err := mem.LatestVersion(ctx, pkg.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver.Semver != "0.0.1" {
+		t.Errorf("expected version 0.0.1, got %s", ver.Semver)
+	}
+
+	snapshots, _ := mem.Snapshots(ctx, store.SnapshotQuery{VersionID: ver.ID})
+	if len(snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(snapshots))
+	}
+	s := snapshots[0]
+	if s.DownloadsTotal != 3 || s.DownloadsMonthly != 2 || s.DownloadsWeekly != 1 || s.Stars != 1 || s.Watchers != 2 || s.Forks != 3 || s.Issues != 4 {
+		t.Errorf("snapshot does not match the registry's stats: %+v", s)
+	}
+	if s.Mirror != crawlers[0].client.Mirrors()[0] {
+		t.Errorf("expected the snapshot to record the registry it came from, got %q", s.Mirror)
+	}
+
+	results, _, _ := mem.Search(ctx, store.SearchQuery{Terms: []store.SearchTerm{{Words: []string{"slack"}}, {Words: []string{"api"}}}})
+	if len(results) != 1 {
+		t.Errorf("expected the package's description to be searchable, got %+v", results)
+	}
+	results, _, _ = mem.Search(ctx, store.SearchQuery{Filters: []store.SearchFilter{{Field: "author", Value: "sinisa susnjar"}, {Field: "license", Value: "MIT"}}})
+	if len(results) != 1 {
+		t.Errorf("expected the package's authors and license to be stored, got %+v", results)
+	}
+
+	// The registry doesn't know about 'missing', so rather than staying due it's marked as removed.
+	due, _ := mem.PackagesDue(ctx)
+	if len(due) != 0 {
+		t.Errorf("expected nothing to still be due, got %+v", due)
+	}
+}
+
+func TestUpdatePackagesReusesVersions(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	ctx := context.Background()
+
+	mem.AddPackage(ctx, 1, "slack-d")
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		now = now.Add(store.UpdateInterval + time.Minute)
+		current := now
+		mem.SetClock(func() time.Time { return current })
+		err := updatePackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pkg, _ := mem.PackageByName(ctx, 1, "slack-d")
+	ver, _ := mem.LatestVersion(ctx, pkg.ID, false)
+	if ver.ID != 1 {
+		t.Errorf("expected the existing version to be reused, got %+v", ver)
+	}
+	snapshots, _ := mem.Snapshots(ctx, store.SnapshotQuery{VersionID: ver.ID})
+	if len(snapshots) != 2 {
+		t.Errorf("expected 2 snapshots, got %d", len(snapshots))
+	}
+}
+
+func TestUpdatePackagesChangeOnly(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	cfg.Storage.Snapshots = "changes"
+	ctx := context.Background()
+
+	mem.AddPackage(ctx, 1, "slack-d")
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		now = now.Add(store.UpdateInterval + time.Minute)
+		current := now
+		mem.SetClock(func() time.Time { return current })
+		err := updatePackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The fixture never changes, so the first snapshot is extended rather than a second one being added.
+	last, _ := mem.LastSnapshot(ctx, 1)
+	if last.ID != 1 || !last.ValidTo.After(last.Time) {
+		t.Errorf("expected the first snapshot to have been extended, got %+v", last)
+	}
+	snapshots, _ := mem.Snapshots(ctx, store.SnapshotQuery{VersionID: last.VersionID})
+	if len(snapshots) != 2 || !snapshots[1].Time.Equal(last.ValidTo) {
+		t.Errorf("expected the series to still have 2 snapshots, got %+v", snapshots)
+	}
+}
+
+func TestUpdatePackagesDailyDownloads(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	ctx := context.Background()
+
+	// The fixture's total is 3, so 2 downloads were made over the two days since this snapshot.
+	mem.AddPackage(ctx, 1, "slack-d")
+	ver, _ := mem.EnsureVersion(ctx, 1, "0.0.1")
+	mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: time.Now().Add(-time.Hour * 48), DownloadsTotal: 1})
+	mem.SetClock(func() time.Time { return time.Now().Add(time.Minute) })
+
+	err := updatePackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	days, _ := mem.DailyDownloads(ctx, 1, time.Now().Add(-time.Hour*72))
+	perDay, ok := store.DownloadsPerDay(days, time.Time{}, time.Now().Add(time.Hour*24))
+	if len(days) < 2 || !ok || perDay < 0.99 || perDay > 1.01 {
+		t.Errorf("expected roughly 1 download per day, got %f from %+v", perDay, days)
+	}
+}
+
+func TestUpdatePackagesValidation(t *testing.T) {
+	for _, mode := range []string{"flag", "reject"} {
+		t.Run(mode, func(t *testing.T) {
+			mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+			cfg.Validation.Mode = mode
+			ctx := context.Background()
+
+			// The fixture's total of 3 is lower than this earlier sample, so the new snapshot looks like it went backwards.
+			mem.AddPackage(ctx, 1, "slack-d")
+			ver, _ := mem.EnsureVersion(ctx, 1, "0.0.1")
+			mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: time.Now().Add(-time.Hour), DownloadsTotal: 100})
+			mem.SetClock(func() time.Time { return time.Now().Add(time.Minute) })
+
+			err := updatePackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			all, _ := mem.Snapshots(ctx, store.SnapshotQuery{VersionID: ver.ID, IncludeFlagged: true})
+			due, _ := mem.PackagesDue(ctx)
+			switch mode {
+			case "flag":
+				if len(all) != 2 || !all[1].Flagged() {
+					t.Errorf("expected the new snapshot to be stored flagged, got %+v", all)
+				}
+				if len(due) != 0 {
+					t.Errorf("expected the package to have been bumped, got %+v", due)
+				}
+			case "reject":
+				if len(all) != 1 {
+					t.Errorf("expected the new snapshot to be rejected, got %+v", all)
+				}
+				if len(due) != 1 {
+					t.Errorf("expected the package to still be due, got %+v", due)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdatePackagesRepeatedFailures(t *testing.T) {
+	for _, test := range []struct {
+		mode  string
+		limit int
+	}{{"reject", 2}, {"flag", 1}} {
+		t.Run(test.mode, func(t *testing.T) {
+			mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+			cfg.Validation = config.Validation{Mode: test.mode, MaxFailures: test.limit}
+			ctx := context.Background()
+
+			// The registry has permanently corrected slack-d's total down from 100 to the fixture's 3.
+			mem.AddPackage(ctx, 1, "slack-d")
+			ver, _ := mem.EnsureVersion(ctx, 1, "0.0.1")
+			mem.AddSnapshot(ctx, store.Snapshot{VersionID: ver.ID, Time: time.Now().Add(-time.Hour), DownloadsTotal: 100})
+
+			for i := 1; i <= test.limit+1; i++ {
+				mem.SetClock(func() time.Time { return time.Now().Add(store.UpdateInterval * time.Duration(2*i)) })
+				err := updatePackages()
+				if err != nil {
+					t.Fatal(err)
+				}
+				all, _ := mem.Snapshots(ctx, store.SnapshotQuery{VersionID: ver.ID, IncludeFlagged: true})
+				latest := all[len(all)-1]
+				switch {
+				case i > test.limit:
+					if latest.Flagged() || latest.DownloadsTotal != 3 {
+						t.Errorf("expected the snapshot to be accepted after %d failures, got %+v", test.limit, all)
+					}
+				case test.mode == "reject":
+					if len(all) != 1 {
+						t.Errorf("update %d: expected the snapshot to be rejected, got %+v", i, all)
+					}
+				default:
+					if len(all) != i+1 || !latest.Flagged() {
+						t.Errorf("update %d: expected the snapshot to be flagged, got %+v", i, all)
+					}
+				}
+			}
+
+			// The count starts again once the package passes, so a later failure isn't accepted straight away.
+			pkg, _ := mem.PackageByName(ctx, 1, "slack-d")
+			if pkg.ValidationFailures != 0 {
+				t.Errorf("expected the failures to have been reset, got %d", pkg.ValidationFailures)
+			}
+		})
+	}
+}
+
+func TestUpdateNamedPackages(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "slack-d")
+	mem.AddPackage(ctx, 1, "missing")
+
+	// Packages are updated even when they aren't due yet.
+	err := updateNamedPackages(crawlers[0], []string{"slack-d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, _ := mem.PackageByName(ctx, 1, "slack-d")
+	if _, err := mem.LastSnapshot(ctx, pkg.ID); err != nil {
+		t.Errorf("expected a snapshot to have been taken: %v", err)
+	}
+
+	if err := updateNamedPackages(crawlers[0], []string{"missing"}); err == nil {
+		t.Error("expected an error when the registry doesn't know the package")
+	}
+	if err := updateNamedPackages(crawlers[0], []string{"unknown"}); err == nil {
+		t.Error("expected an error for a package that isn't stored")
+	}
+}
+
+func TestUpdatePackagesMultipleRegistries(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	private := addCrawler(t, "private", map[string]string{"slack-d": "0.0.2"})
+	ctx := context.Background()
+
+	// The same name in two registries is two different packages.
+	mem.AddPackage(ctx, 1, "slack-d")
+	mem.AddPackage(ctx, private.ID, "slack-d")
+	mem.SetClock(func() time.Time { return time.Now().Add(time.Minute) })
+
+	err := updatePackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range crawlers {
+		pkg, _ := mem.PackageByName(ctx, c.ID, "slack-d")
+		last, err := mem.LastSnapshot(ctx, pkg.ID)
+		if err != nil || last.Mirror != c.URL {
+			t.Errorf("%s: expected a snapshot from %s, got %+v (%v)", c.Name, c.URL, last, err)
+		}
+	}
+	pkg, _ := mem.PackageByName(ctx, private.ID, "slack-d")
+	ver, _ := mem.LatestVersion(ctx, pkg.ID, false)
+	if ver.Semver != "0.0.2" {
+		t.Errorf("expected the private registry's version, got %s", ver.Semver)
+	}
+
+	if results, _, _ := mem.Search(ctx, store.SearchQuery{Query: "slack-d", RegistryID: private.ID}); len(results) != 1 || results[0].Registry != "private" {
+		t.Errorf("expected search to be limited to the private registry, got %+v", results)
+	}
+}
+
+func TestUpdatePackagesReadme(t *testing.T) {
+	mem := setup(t, map[string]string{"slack-d": "0.0.1"})
+	ctx := context.Background()
+	mem.AddPackage(ctx, 1, "slack-d")
+
+	err := updatePackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ver, _ := mem.FindVersion(ctx, 1, "0.0.1")
+	first, err := mem.Readme(ctx, ver.ID)
+	if err != nil || !strings.HasPrefix(first.Description, "Slack API for D") || !strings.Contains(first.Readme, "# Slack Web API for D") {
+		t.Fatalf("expected the readme to be stored, got %+v: %v", first, err)
+	}
+
+	// The fixture's documentation never changes, so the stored copy is left alone.
+	mem.SetClock(func() time.Time { return time.Now().Add(store.UpdateInterval * 2) })
+	err = updatePackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := mem.Readme(ctx, ver.ID)
+	if second.Hash != first.Hash || !second.UpdatedAt.Equal(first.UpdatedAt) {
+		t.Errorf("expected the readme to be unchanged, got %+v", second)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+// A validationRule returns a description of what's wrong with next, or an empty string if it looks fine.
+// prev is the package's last unflagged snapshot, and is nil for a package's first snapshot.
+type validationRule struct {
+	name  string
+	check func(prev *store.Snapshot, next store.Snapshot) string
+}
+
+var validationRules = []validationRule{
+	{"monotonic_total", func(prev *store.Snapshot, next store.Snapshot) string {
+		if prev != nil && next.DownloadsTotal < prev.DownloadsTotal {
+			return fmt.Sprintf("downloads_total went backwards from %d to %d", prev.DownloadsTotal, next.DownloadsTotal)
+		}
+		return ""
+	}},
+	{"window_order", func(_ *store.Snapshot, next store.Snapshot) string {
+		if next.DownloadsWeekly > next.DownloadsMonthly || int64(next.DownloadsMonthly) > next.DownloadsTotal {
+			return fmt.Sprintf(
+				"expected weekly <= monthly <= total, got %d, %d, %d",
+				next.DownloadsWeekly, next.DownloadsMonthly, next.DownloadsTotal,
+			)
+		}
+		return ""
+	}},
+	// A registry hiccup tends to zero out a whole block of figures at once, rather than a single one.
+	{"sudden_zero", func(prev *store.Snapshot, next store.Snapshot) string {
+		if prev == nil {
+			return ""
+		}
+		if next.DownloadsTotal == 0 && next.DownloadsMonthly == 0 && next.DownloadsWeekly == 0 && prev.DownloadsTotal > 0 {
+			return "download figures were all zero"
+		}
+		if next.Stars == 0 && next.Watchers == 0 && next.Forks == 0 && next.Issues == 0 && (prev.Stars > 0 || prev.Watchers > 0 || prev.Forks > 0) {
+			return "repo figures were all zero"
+		}
+		return ""
+	}},
+}
+
+// validateSnapshot runs every rule against next, returning the names of the rules that failed alongside the reasons why.
+func validateSnapshot(prev *store.Snapshot, next store.Snapshot) (failed []string, reason string) {
+	var reasons []string
+	for _, rule := range validationRules {
+		msg := rule.check(prev, next)
+		if msg == "" {
+			continue
+		}
+		failed = append(failed, rule.name)
+		reasons = append(reasons, msg)
+	}
+	return failed, strings.Join(reasons, "; ")
+}
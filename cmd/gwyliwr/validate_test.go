@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestValidateSnapshot(t *testing.T) {
+	prev := &store.Snapshot{DownloadsWeekly: 1, DownloadsMonthly: 5, DownloadsTotal: 50, Stars: 3, Watchers: 1}
+
+	tests := []struct {
+		name string
+		prev *store.Snapshot
+		next store.Snapshot
+		want []string
+	}{
+		{"first snapshot", nil, store.Snapshot{DownloadsTotal: 0}, nil},
+		{"healthy", prev, store.Snapshot{DownloadsWeekly: 2, DownloadsMonthly: 6, DownloadsTotal: 52, Stars: 3}, nil},
+		{"total decreased", prev, store.Snapshot{DownloadsWeekly: 1, DownloadsMonthly: 5, DownloadsTotal: 40, Stars: 3}, []string{"monotonic_total"}},
+		{"weekly above monthly", prev, store.Snapshot{DownloadsWeekly: 9, DownloadsMonthly: 5, DownloadsTotal: 60, Stars: 3}, []string{"window_order"}},
+		{"monthly above total", nil, store.Snapshot{DownloadsMonthly: 5, DownloadsTotal: 2}, []string{"window_order"}},
+		{"downloads zeroed", prev, store.Snapshot{Stars: 3}, []string{"monotonic_total", "sudden_zero"}},
+		{"repo zeroed", prev, store.Snapshot{DownloadsWeekly: 1, DownloadsMonthly: 5, DownloadsTotal: 50}, []string{"sudden_zero"}},
+	}
+
+	for _, test := range tests {
+		failed, reason := validateSnapshot(test.prev, test.next)
+		if !reflect.DeepEqual(failed, test.want) {
+			t.Errorf("%s: expected %v to fail, got %v (%s)", test.name, test.want, failed, reason)
+		}
+		if (reason == "") != (len(test.want) == 0) {
+			t.Errorf("%s: unexpected reason %q", test.name, reason)
+		}
+	}
+}
@@ -3,14 +3,19 @@ module github.com/BradleyChatha/ystadegau/ymfudwr
 go 1.17
 
 require (
-	github.com/aws/aws-lambda-go v1.27.0 // indirect
-	github.com/aws/aws-sdk-go v1.41.0 // indirect
-	github.com/golang-migrate/migrate/v4 v4.15.0 // indirect
+	github.com/BradleyChatha/ystadegau/pkg v0.0.0
+	github.com/golang-migrate/migrate/v4 v4.15.0
+	github.com/lib/pq v1.10.3
+)
+
+require (
+	github.com/aws/aws-sdk-go v1.41.1 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	github.com/lib/pq v1.10.3 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
 	go.uber.org/zap v1.19.1 // indirect
 )
+
+replace github.com/BradleyChatha/ystadegau/pkg => ../../pkg
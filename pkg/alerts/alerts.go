@@ -0,0 +1,124 @@
+// Package alerts evaluates the alert rules stored by chwilwr against the snapshots gwyliwr takes, and sends
+// notifications when a rule starts or stops firing.
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+// Metrics lists the snapshot fields that rules can watch.
+var Metrics = []string{"downloads_weekly", "downloads_monthly", "downloads_total", "stars", "watchers", "issues", "forks"}
+
+// Comparisons lists how rules can compare a metric, see store.AlertRule.
+var Comparisons = []string{"above", "below", "change_above", "change_below"}
+
+// Metric returns the named field of the snapshot.
+func Metric(s store.Snapshot, name string) (float64, bool) {
+	switch name {
+	case "downloads_weekly":
+		return float64(s.DownloadsWeekly), true
+	case "downloads_monthly":
+		return float64(s.DownloadsMonthly), true
+	case "downloads_total":
+		return float64(s.DownloadsTotal), true
+	case "stars":
+		return float64(s.Stars), true
+	case "watchers":
+		return float64(s.Watchers), true
+	case "issues":
+		return float64(s.Issues), true
+	case "forks":
+		return float64(s.Forks), true
+	}
+	return 0, false
+}
+
+// ErrNoSMTP is returned for rules with an email address when no SMTP relay has been configured to send it.
+var ErrNoSMTP = errors.New("no SMTP server has been configured to send email notifications")
+
+// Validate checks that the rule can be evaluated and has somewhere to send notifications. email reports whether an
+// SMTP relay has been configured, without which rules can't have an email address.
+func Validate(r store.AlertRule, email bool) error {
+	if r.Name == "" {
+		return errors.New("rules need a name")
+	}
+	if _, ok := Metric(store.Snapshot{}, r.Metric); !ok {
+		return fmt.Errorf("unknown metric %q", r.Metric)
+	}
+	if !contains(Comparisons, r.Comparison) {
+		return fmt.Errorf("unknown comparison %q", r.Comparison)
+	}
+	if r.IsChange() && r.Window <= 0 {
+		return fmt.Errorf("%s needs a window to compare against", r.Comparison)
+	}
+	if r.Package != "" && r.Filter != "" {
+		return errors.New("rules can have a package or a filter, but not both")
+	}
+	if _, err := path.Match(r.Filter, ""); err != nil {
+		return fmt.Errorf("bad filter: %w", err)
+	}
+	if r.WebhookURL == "" && r.Email == "" {
+		return errors.New("rules need a webhook URL or an email address to notify")
+	}
+	if r.Email != "" && !email {
+		return ErrNoSMTP
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to the given package.
+func Matches(r store.AlertRule, registry string, pkg string) bool {
+	if r.Registry != "" && r.Registry != registry {
+		return false
+	}
+	switch {
+	case r.Package != "":
+		return r.Package == pkg
+	case r.Filter != "":
+		ok, _ := path.Match(r.Filter, pkg)
+		return ok
+	}
+	return true
+}
+
+// Evaluate decides whether the rule fires for the current snapshot, returning the value that was compared: either the
+// metric itself, or the percentage it changed by since baseline. Change rules can't be evaluated without a baseline,
+// or when the baseline is zero, in which case ok is false.
+func Evaluate(r store.AlertRule, current store.Snapshot, baseline *store.Snapshot) (firing bool, value float64, ok bool) {
+	value, ok = Metric(current, r.Metric)
+	if !ok {
+		return false, 0, false
+	}
+
+	if r.IsChange() {
+		if baseline == nil {
+			return false, 0, false
+		}
+		before, _ := Metric(*baseline, r.Metric)
+		if before == 0 {
+			return false, 0, false
+		}
+		value = (value - before) / before * 100
+	}
+
+	switch r.Comparison {
+	case "above", "change_above":
+		return value > r.Threshold, value, true
+	case "below", "change_below":
+		return value < r.Threshold, value, true
+	}
+	return false, 0, false
+}
+
+func contains(arr []string, s string) bool {
+	for _, v := range arr {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/alerts/smtptest"
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestEvaluate(t *testing.T) {
+	baseline := &store.Snapshot{DownloadsWeekly: 100, Issues: 4}
+	for _, test := range []struct {
+		rule    store.AlertRule
+		current store.Snapshot
+		base    *store.Snapshot
+		firing  bool
+		value   float64
+		ok      bool
+	}{
+		{store.AlertRule{Metric: "stars", Comparison: "above", Threshold: 10}, store.Snapshot{Stars: 11}, nil, true, 11, true},
+		{store.AlertRule{Metric: "stars", Comparison: "below", Threshold: 10}, store.Snapshot{Stars: 11}, nil, false, 11, true},
+		{store.AlertRule{Metric: "downloads_weekly", Comparison: "change_below", Threshold: -50}, store.Snapshot{DownloadsWeekly: 40}, baseline, true, -60, true},
+		{store.AlertRule{Metric: "downloads_weekly", Comparison: "change_below", Threshold: -50}, store.Snapshot{DownloadsWeekly: 60}, baseline, false, -40, true},
+		{store.AlertRule{Metric: "issues", Comparison: "change_above", Threshold: 99}, store.Snapshot{Issues: 8}, baseline, true, 100, true},
+		{store.AlertRule{Metric: "issues", Comparison: "change_above", Threshold: 99}, store.Snapshot{Issues: 8}, nil, false, 0, false},
+		{store.AlertRule{Metric: "forks", Comparison: "change_above", Threshold: 99}, store.Snapshot{Forks: 8}, baseline, false, 0, false},
+	} {
+		firing, value, ok := Evaluate(test.rule, test.current, test.base)
+		if firing != test.firing || value != test.value || ok != test.ok {
+			t.Errorf("%s %s %f: expected %v %f %v, got %v %f %v", test.rule.Metric, test.rule.Comparison, test.rule.Threshold, test.firing, test.value, test.ok, firing, value, ok)
+		}
+	}
+}
+
+func TestMatchesAndValidate(t *testing.T) {
+	rule := store.AlertRule{Name: "vibe", Registry: "dub", Filter: "vibe-*", Metric: "stars", Comparison: "above", Email: "a@b.c"}
+	if err := Validate(rule, true); err != nil {
+		t.Fatal(err)
+	}
+	if !Matches(rule, "dub", "vibe-core") || Matches(rule, "dub", "dub") || Matches(rule, "private", "vibe-core") {
+		t.Error("expected the rule to only match vibe-* packages in the dub registry")
+	}
+
+	for _, bad := range []store.AlertRule{
+		{Name: "x", Metric: "score", Comparison: "above", Email: "a@b.c"},
+		{Name: "x", Metric: "stars", Comparison: "equals", Email: "a@b.c"},
+		{Name: "x", Metric: "stars", Comparison: "change_above", Email: "a@b.c"},
+		{Name: "x", Metric: "stars", Comparison: "above", Package: "a", Filter: "b*", Email: "a@b.c"},
+		{Name: "x", Metric: "stars", Comparison: "above"},
+	} {
+		if Validate(bad, true) == nil {
+			t.Errorf("expected %+v to be invalid", bad)
+		}
+	}
+	if err := Validate(rule, false); err != ErrNoSMTP {
+		t.Errorf("expected an email address to be invalid without an SMTP server, got %v", err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var received []Notification
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		json.NewDecoder(r.Body).Decode(&n)
+		received = append(received, n)
+	}))
+	defer hook.Close()
+
+	mail, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mail.Close()
+
+	notifier := &Notifier{HTTP: hook.Client(), SMTP: &SMTP{Addr: mail.Addr, From: "alerts@example.com"}}
+	rule := store.AlertRule{ID: 1, Name: "Downloads halved", Metric: "downloads_weekly", Comparison: "change_below", Threshold: -50, Window: time.Hour, WebhookURL: hook.URL, Email: "team@example.com"}
+	note := Notification{RuleID: 1, Rule: rule.Name, State: "firing", Registry: "dub", Package: "vibe-d", Metric: rule.Metric, Value: -60, Threshold: -50}
+
+	err = notifier.Notify(context.Background(), rule, note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || received[0].Package != "vibe-d" || received[0].State != "firing" {
+		t.Errorf("expected the webhook to get the notification, got %+v", received)
+	}
+	messages := mail.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "team@example.com" || !strings.Contains(messages[0].Data, "Subject: [firing] Downloads halved: dub vibe-d") {
+		t.Errorf("expected an email to have been sent, got %+v", messages)
+	}
+
+	// Without an SMTP server the webhook isn't sent either, since the notification will be retried.
+	if err := (&Notifier{HTTP: hook.Client()}).Notify(context.Background(), rule, note); err != ErrNoSMTP {
+		t.Errorf("expected email notifications to fail without an SMTP server, got %v", err)
+	}
+	if len(received) != 1 {
+		t.Errorf("expected the webhook not to be sent again, got %+v", received)
+	}
+}
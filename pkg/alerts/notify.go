@@ -0,0 +1,110 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+// Notification is sent whenever a rule starts ("firing") or stops ("resolved") firing for a package.
+type Notification struct {
+	RuleID    int       `json:"ruleId"`
+	Rule      string    `json:"rule"`
+	State     string    `json:"state"`
+	Registry  string    `json:"registry"`
+	Package   string    `json:"package"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// Subject is a one line summary of the notification.
+func (n Notification) Subject() string {
+	return fmt.Sprintf("[%s] %s: %s %s", n.State, n.Rule, n.Registry, n.Package)
+}
+
+func (n Notification) describe(comparison string) string {
+	what := n.Metric
+	if strings.HasPrefix(comparison, "change_") {
+		what += " change (%)"
+	}
+	return fmt.Sprintf("%s\n\n%s is %.2f, with a threshold of %s %.2f.\n", n.Subject(), what, n.Value, comparison, n.Threshold)
+}
+
+// Notifier sends notifications to wherever each rule asks for them.
+type Notifier struct {
+	HTTP *http.Client
+	// SMTP is needed for rules with an email address, which fail to notify without it.
+	SMTP *SMTP
+}
+
+// Notify sends to both the rule's webhook and email address when both are set, returning the last error. Nothing is
+// sent if the rule has an email address but there's no SMTP relay, since a failed notification is retried and the
+// webhook would be sent again every time.
+func (n *Notifier) Notify(ctx context.Context, r store.AlertRule, note Notification) error {
+	if r.Email != "" && n.SMTP == nil {
+		return ErrNoSMTP
+	}
+
+	var err error
+	if r.WebhookURL != "" {
+		err = n.post(ctx, r.WebhookURL, note)
+	}
+	if r.Email != "" {
+		if mailErr := n.SMTP.Send(r.Email, note.Subject(), note.describe(r.Comparison)); mailErr != nil {
+			err = mailErr
+		}
+	}
+	return err
+}
+
+func (n *Notifier) post(ctx context.Context, url string, note Notification) error {
+	body, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	return nil
+}
+
+// SMTP sends plain text email through a relay.
+type SMTP struct {
+	Addr string
+	From string
+	// Auth is optional, for relays that need a login.
+	Auth smtp.Auth
+}
+
+// headerValue stops values from adding headers of their own.
+var headerValue = strings.NewReplacer("\r", " ", "\n", " ")
+
+func (s *SMTP) Send(to string, subject string, body string) error {
+	msg := "From: " + headerValue.Replace(s.From) + "\r\n" +
+		"To: " + headerValue.Replace(to) + "\r\n" +
+		"Subject: " + headerValue.Replace(subject) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{to}, []byte(msg))
+}
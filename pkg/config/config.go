@@ -0,0 +1,251 @@
+// Package config loads the settings shared by every ystadegau service.
+//
+// Settings are built up from layered sources, each one overriding whatever the previous
+// layers set: built-in defaults, an optional JSON file, environment variables, and finally
+// AWS SSM when it has been enabled by one of the earlier layers. Nothing touches AWS unless
+// SSM is enabled, so every service can run locally against a plain Postgres.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	DB         DB         `json:"db"`
+	Queue      Queue      `json:"queue"`
+	HTTP       HTTP       `json:"http"`
+	Registry   Registry   `json:"registry"`
+	RateLimit  RateLimit  `json:"rateLimit"`
+	AWS        AWS        `json:"aws"`
+	SSM        SSM        `json:"ssm"`
+	Secrets    Secrets    `json:"secrets"`
+	Validation Validation `json:"validation"`
+	Retention  Retention  `json:"retention"`
+	Storage    Storage    `json:"storage"`
+	Events     Events     `json:"events"`
+	SMTP       SMTP       `json:"smtp"`
+	// Autocomplete tunes chwilwr's /suggest endpoint.
+	Autocomplete Autocomplete `json:"autocomplete"`
+
+	// Registries are crawled alongside Registry, e.g. private registries. Only the JSON config file can set them.
+	Registries []Registry `json:"registries"`
+}
+
+type DB struct {
+	Host    string `json:"host"`
+	Port    int    `json:"port"`
+	User    string `json:"user"`
+	Pass    string `json:"pass"`
+	Name    string `json:"name"`
+	SSLMode string `json:"sslMode"`
+}
+
+type Queue struct {
+	URL      string   `json:"url"`
+	WaitTime Duration `json:"waitTime"`
+}
+
+type HTTP struct {
+	Listen        string `json:"listen"`
+	MetricsListen string `json:"metricsListen"`
+	// AdminToken must be given as a bearer token to manage webhooks through chwilwr. Leaving it empty disables them.
+	AdminToken string `json:"adminToken"`
+}
+
+type Registry struct {
+	// Name identifies the registry in the database and in chwilwr's registry parameter.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Mirrors are tried in order whenever URL, or the mirror before, is down.
+	Mirrors             []string `json:"mirrors"`
+	Timeout             Duration `json:"timeout"`
+	HealthCheckInterval Duration `json:"healthCheckInterval"`
+	// RateLimit overrides the top level RateLimit for this registry when set.
+	RateLimit RateLimit `json:"rateLimit"`
+	// UserKey and PassKey name the secrets holding basic auth credentials for private registries.
+	UserKey string `json:"userKey"`
+	PassKey string `json:"passKey"`
+}
+
+// AllRegistries returns Registry followed by Registries, with any unset timeouts, health check intervals and
+// rate limits taken from Registry and RateLimit.
+func (c *Config) AllRegistries() []Registry {
+	regs := make([]Registry, 0, len(c.Registries)+1)
+	for _, reg := range append([]Registry{c.Registry}, c.Registries...) {
+		if reg.Timeout.Duration == 0 {
+			reg.Timeout = c.Registry.Timeout
+		}
+		if reg.HealthCheckInterval.Duration == 0 {
+			reg.HealthCheckInterval = c.Registry.HealthCheckInterval
+		}
+		if reg.RateLimit.Interval.Duration == 0 {
+			reg.RateLimit = c.RateLimit
+		}
+		regs = append(regs, reg)
+	}
+	return regs
+}
+
+// RateLimit controls how often gwyliwr is allowed to contact the registry.
+type RateLimit struct {
+	Interval Duration `json:"interval"`
+	Burst    int      `json:"burst"`
+}
+
+type AWS struct {
+	Region string `json:"region"`
+}
+
+// SSM names the parameters that hold the database connection details.
+type SSM struct {
+	Enabled   bool   `json:"enabled"`
+	HostParam string `json:"hostParam"`
+	UserParam string `json:"userParam"`
+	PassParam string `json:"passParam"`
+}
+
+// Secrets controls where the database credentials are read from at runtime.
+// When Provider is empty the static DB.User and DB.Pass are used and never refreshed.
+type Secrets struct {
+	Provider        string   `json:"provider"`
+	Dir             string   `json:"dir"`
+	UserKey         string   `json:"userKey"`
+	PassKey         string   `json:"passKey"`
+	RefreshInterval Duration `json:"refreshInterval"`
+}
+
+// Validation decides what gwyliwr does with snapshots that look wrong: "flag" stores them marked as suspect, "reject" drops them.
+type Validation struct {
+	Mode string `json:"mode"`
+	// MaxFailures is how many of a package's snapshots in a row can fail validation by breaking the same rules before
+	// they're accepted as a genuine change, such as the registry correcting its figures. 0 never accepts them.
+	MaxFailures int `json:"maxFailures"`
+}
+
+// Retention controls how long raw snapshots are kept once they've been rolled up. Zero keeps them forever.
+type Retention struct {
+	RawSnapshots Duration `json:"rawSnapshots"`
+}
+
+// Storage decides how snapshots are written: "full" adds a row for every snapshot, while "changes" only adds a row when
+// a metric differs from the version's previous snapshot, and otherwise extends how long the previous row is valid for.
+type Storage struct {
+	Snapshots string `json:"snapshots"`
+}
+
+// Events controls where gwyliwr sends its events: "webhook" delivers them to the subscriptions managed through
+// chwilwr, "queue" sends them to the SQS queue at QueueURL, and "none" drops them.
+type Events struct {
+	Sink     string `json:"sink"`
+	QueueURL string `json:"queueUrl"`
+	// Retries and RetryDelay control how failed webhook deliveries are retried, with the delay doubling each time.
+	Retries    int      `json:"retries"`
+	RetryDelay Duration `json:"retryDelay"`
+	Timeout    Duration `json:"timeout"`
+}
+
+// SMTP is the relay used for email notifications from alert rules. Addr is a host:port, and leaving it empty
+// disables email. Username and Password are only sent when Username is set.
+type SMTP struct {
+	Addr     string `json:"addr"`
+	From     string `json:"from"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Autocomplete controls chwilwr's cache of recently requested prefixes, and how long a lookup may take before it's
+// given up on. A CacheSize of 0 disables the cache.
+type Autocomplete struct {
+	CacheSize int      `json:"cacheSize"`
+	CacheTTL  Duration `json:"cacheTtl"`
+	Timeout   Duration `json:"timeout"`
+}
+
+// Duration is a time.Duration that is written as a string such as "5s" in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var str string
+	err := json.Unmarshal(b, &str)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(str)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// Source is a single layer of configuration.
+type Source interface {
+	Apply(cfg *Config) error
+}
+
+// SourceFunc allows a plain function to be used as a Source.
+type SourceFunc func(cfg *Config) error
+
+func (f SourceFunc) Apply(cfg *Config) error {
+	return f(cfg)
+}
+
+// Load applies each source in order, so later sources override earlier ones.
+func Load(sources ...Source) (*Config, error) {
+	var cfg Config
+	for _, source := range sources {
+		err := source.Apply(&cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+// LoadDefault loads the standard layers: defaults, the file named by CONFIG_FILE (if any), the environment, then SSM.
+func LoadDefault() (*Config, error) {
+	return Load(Defaults(), File(os.Getenv("CONFIG_FILE")), Env(), SSMSource())
+}
+
+// ConnString returns a lib/pq key/value connection string.
+func (db DB) ConnString() string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quoteConnValue(db.Host),
+		db.Port,
+		quoteConnValue(db.User),
+		quoteConnValue(db.Pass),
+		quoteConnValue(db.Name),
+		quoteConnValue(db.SSLMode),
+	)
+}
+
+// URL returns the connection details as a postgres:// URL, as expected by golang-migrate.
+func (db DB) URL() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(db.User, db.Pass),
+		Host:     net.JoinHostPort(db.Host, strconv.Itoa(db.Port)),
+		Path:     "/" + db.Name,
+		RawQuery: url.Values{"sslmode": []string{db.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+func quoteConnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
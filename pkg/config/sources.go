@@ -0,0 +1,238 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/secrets"
+)
+
+// Defaults provides settings that work against a local Postgres and the public dub registry.
+func Defaults() Source {
+	return SourceFunc(func(cfg *Config) error {
+		*cfg = Config{
+			DB: DB{
+				Host:    "localhost",
+				Port:    5432,
+				User:    "postgres",
+				Name:    "dubstats",
+				SSLMode: "disable", // A stock local Postgres has no TLS, so deployments set DB_SSL=require.
+			},
+			Queue: Queue{
+				WaitTime: Duration{time.Second * 20},
+			},
+			HTTP: HTTP{
+				Listen:        ":5678",
+				MetricsListen: ":5679",
+			},
+			Registry: Registry{
+				Name:                "dub",
+				URL:                 "https://code.dlang.org",
+				Timeout:             Duration{time.Second * 30},
+				HealthCheckInterval: Duration{time.Minute},
+			},
+			RateLimit: RateLimit{
+				Interval: Duration{time.Second * 5},
+				Burst:    1,
+			},
+			AWS: AWS{
+				Region: "eu-west-2",
+			},
+			SSM: SSM{
+				HostParam: "db_url",
+				UserParam: "db_lambda_user",
+				PassParam: "db_lambda_pass",
+			},
+			Secrets: Secrets{
+				UserKey:         "db_lambda_user",
+				PassKey:         "db_lambda_pass",
+				RefreshInterval: Duration{time.Minute},
+			},
+			Validation: Validation{
+				Mode:        "flag",
+				MaxFailures: 5,
+			},
+			Storage: Storage{
+				Snapshots: "full",
+			},
+			Events: Events{
+				Sink:       "webhook",
+				Retries:    5,
+				RetryDelay: Duration{time.Second},
+				Timeout:    Duration{time.Second * 10},
+			},
+			Autocomplete: Autocomplete{
+				CacheSize: 1000,
+				CacheTTL:  Duration{time.Minute},
+				Timeout:   Duration{time.Millisecond * 200},
+			},
+		}
+		return nil
+	})
+}
+
+// File overlays the JSON file at path. Fields missing from the file are left untouched, and an empty path is ignored.
+func File(path string) Source {
+	return SourceFunc(func(cfg *Config) error {
+		if path == "" {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("could not open config file: %w", err)
+		}
+		defer f.Close()
+
+		err = json.NewDecoder(f).Decode(cfg)
+		if err != nil {
+			return fmt.Errorf("could not parse config file %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// Env overlays any of the recognised environment variables that are set.
+func Env() Source {
+	return SourceFunc(func(cfg *Config) error {
+		vars := []struct {
+			name  string
+			apply func(string) error
+		}{
+			{"DB_HOST", setString(&cfg.DB.Host)},
+			{"DB_PORT", setInt(&cfg.DB.Port)},
+			{"DB_USER", setString(&cfg.DB.User)},
+			{"DB_PASS", setString(&cfg.DB.Pass)},
+			{"DB_DB", setString(&cfg.DB.Name)},
+			{"DB_SSL", setString(&cfg.DB.SSLMode)},
+			{"QUEUE_URL", setString(&cfg.Queue.URL)},
+			{"QUEUE_WAIT_TIME", setDuration(&cfg.Queue.WaitTime)},
+			{"HTTP_LISTEN", setString(&cfg.HTTP.Listen)},
+			{"METRICS_ADDR", setString(&cfg.HTTP.MetricsListen)},
+			{"ADMIN_TOKEN", setString(&cfg.HTTP.AdminToken)},
+			{"REGISTRY_URL", setString(&cfg.Registry.URL)},
+			{"REGISTRY_MIRRORS", setStringList(&cfg.Registry.Mirrors)},
+			{"REGISTRY_TIMEOUT", setDuration(&cfg.Registry.Timeout)},
+			{"REGISTRY_HEALTH_CHECK_INTERVAL", setDuration(&cfg.Registry.HealthCheckInterval)},
+			{"RATE_LIMIT_INTERVAL", setDuration(&cfg.RateLimit.Interval)},
+			{"RATE_LIMIT_BURST", setInt(&cfg.RateLimit.Burst)},
+			{"AWS_REGION", setString(&cfg.AWS.Region)},
+			{"SSM_ENABLED", setBool(&cfg.SSM.Enabled)},
+			{"SECRETS_PROVIDER", setString(&cfg.Secrets.Provider)},
+			{"SECRETS_DIR", setString(&cfg.Secrets.Dir)},
+			{"SECRETS_REFRESH_INTERVAL", setDuration(&cfg.Secrets.RefreshInterval)},
+			{"VALIDATION_MODE", setString(&cfg.Validation.Mode)},
+			{"VALIDATION_MAX_FAILURES", setInt(&cfg.Validation.MaxFailures)},
+			{"RETENTION_RAW_SNAPSHOTS", setDuration(&cfg.Retention.RawSnapshots)},
+			{"SNAPSHOT_STORAGE", setString(&cfg.Storage.Snapshots)},
+			{"EVENTS_SINK", setString(&cfg.Events.Sink)},
+			{"EVENTS_QUEUE_URL", setString(&cfg.Events.QueueURL)},
+			{"EVENTS_RETRIES", setInt(&cfg.Events.Retries)},
+			{"EVENTS_RETRY_DELAY", setDuration(&cfg.Events.RetryDelay)},
+			{"EVENTS_TIMEOUT", setDuration(&cfg.Events.Timeout)},
+			{"SMTP_ADDR", setString(&cfg.SMTP.Addr)},
+			{"SMTP_FROM", setString(&cfg.SMTP.From)},
+			{"SMTP_USERNAME", setString(&cfg.SMTP.Username)},
+			{"SMTP_PASSWORD", setString(&cfg.SMTP.Password)},
+			{"AUTOCOMPLETE_CACHE_SIZE", setInt(&cfg.Autocomplete.CacheSize)},
+			{"AUTOCOMPLETE_CACHE_TTL", setDuration(&cfg.Autocomplete.CacheTTL)},
+			{"AUTOCOMPLETE_TIMEOUT", setDuration(&cfg.Autocomplete.Timeout)},
+		}
+
+		for _, v := range vars {
+			value, ok := os.LookupEnv(v.name)
+			if !ok || value == "" {
+				continue
+			}
+			err := v.apply(value)
+			if err != nil {
+				return fmt.Errorf("bad value for %s: %w", v.name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// SSMSource fetches the database connection details from AWS SSM, but only if cfg.SSM.Enabled has been set by an earlier layer.
+func SSMSource() Source {
+	return SourceFunc(func(cfg *Config) error {
+		if !cfg.SSM.Enabled {
+			return nil
+		}
+
+		provider, err := secrets.NewSSM(cfg.AWS.Region)
+		if err != nil {
+			return err
+		}
+		ctx := context.Background()
+
+		hostAndPort, err := provider.Get(ctx, cfg.SSM.HostParam)
+		if err != nil {
+			return err
+		}
+		host, port, err := net.SplitHostPort(hostAndPort)
+		if err != nil {
+			return fmt.Errorf("bad value for SSM parameter %s: %w", cfg.SSM.HostParam, err)
+		}
+		cfg.DB.Host = host
+		cfg.DB.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("bad port in SSM parameter %s: %w", cfg.SSM.HostParam, err)
+		}
+
+		cfg.DB.User, err = provider.Get(ctx, cfg.SSM.UserParam)
+		if err != nil {
+			return err
+		}
+		cfg.DB.Pass, err = provider.Get(ctx, cfg.SSM.PassParam)
+		return err
+	})
+}
+
+func setString(dst *string) func(string) error {
+	return func(value string) error {
+		*dst = value
+		return nil
+	}
+}
+
+// setStringList splits a comma separated list, ignoring any blank entries.
+func setStringList(dst *[]string) func(string) error {
+	return func(value string) error {
+		*dst = nil
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				*dst = append(*dst, item)
+			}
+		}
+		return nil
+	}
+}
+
+func setInt(dst *int) func(string) error {
+	return func(value string) (err error) {
+		*dst, err = strconv.Atoi(value)
+		return
+	}
+}
+
+func setBool(dst *bool) func(string) error {
+	return func(value string) (err error) {
+		*dst, err = strconv.ParseBool(value)
+		return
+	}
+}
+
+func setDuration(dst *Duration) func(string) error {
+	return func(value string) (err error) {
+		dst.Duration, err = time.ParseDuration(value)
+		return
+	}
+}
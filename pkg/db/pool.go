@@ -0,0 +1,224 @@
+// Package db opens the Postgres connection used by the services, and keeps it working when the credentials are rotated.
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/config"
+	"github.com/BradleyChatha/ystadegau/pkg/secrets"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// How long a replaced connection pool is kept open, so that anything which grabbed it just before the swap can finish.
+var retireDelay = time.Second * 30
+
+// openDB opens a connection pool, and is replaced by tests that don't have a database to connect to.
+var openDB = func(connString string) (*sql.DB, error) {
+	return sql.Open("postgres", connString)
+}
+
+// Pool wraps a *sql.DB which is replaced whenever the credentials held by the secrets provider change.
+//
+// Callers should fetch DB() for each unit of work rather than holding onto it, so that they pick up replacements.
+type Pool struct {
+	cfg      config.DB
+	provider secrets.Provider
+	userKey  string
+	passKey  string
+	logger   *zap.Logger
+
+	mu     sync.RWMutex
+	db     *sql.DB
+	dbUser string
+	dbPass string
+
+	reloadMu sync.Mutex
+}
+
+// Open connects using the static credentials in cfg.DB, or the ones in the configured secrets provider if there is one.
+func Open(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*Pool, error) {
+	p := &Pool{
+		cfg:     cfg.DB,
+		userKey: cfg.Secrets.UserKey,
+		passKey: cfg.Secrets.PassKey,
+		logger:  logger,
+	}
+
+	var err error
+	p.provider, err = newProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	user, pass, err := p.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.db, err = p.connect(ctx, user, pass)
+	if err != nil {
+		return nil, err
+	}
+	p.dbUser = user
+	p.dbPass = pass
+
+	return p, nil
+}
+
+// Resolve returns cfg.DB with the credentials filled in from the secrets provider, if there is one.
+// This is for tools such as ymfudwr that only need a one-off connection.
+func Resolve(ctx context.Context, cfg *config.Config) (config.DB, error) {
+	provider, err := newProvider(cfg)
+	if err != nil {
+		return config.DB{}, err
+	}
+
+	p := &Pool{cfg: cfg.DB, provider: provider, userKey: cfg.Secrets.UserKey, passKey: cfg.Secrets.PassKey}
+	resolved := cfg.DB
+	resolved.User, resolved.Pass, err = p.credentials(ctx)
+	return resolved, err
+}
+
+// DB returns the current connection pool.
+func (p *Pool) DB() *sql.DB {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.db
+}
+
+// Reload fetches the credentials again and, if they have changed, swaps in a new connection pool.
+// The old pool is closed after a delay so in-flight queries are not interrupted.
+func (p *Pool) Reload(ctx context.Context) error {
+	if p.provider == nil {
+		return nil
+	}
+
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	user, pass, err := p.credentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.mu.RLock()
+	unchanged := user == p.dbUser && pass == p.dbPass
+	p.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	p.logger.Info("Database credentials have changed, reconnecting", zap.String("user", user))
+	newDB, err := p.connect(ctx, user, pass)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	old := p.db
+	p.db = newDB
+	p.dbUser = user
+	p.dbPass = pass
+	p.mu.Unlock()
+
+	time.AfterFunc(retireDelay, func() {
+		err := old.Close()
+		if err != nil {
+			p.logger.Warn("Error closing old database pool", zap.Error(err))
+		}
+	})
+	return nil
+}
+
+// Watch calls Reload every interval until ctx is cancelled.
+func (p *Pool) Watch(ctx context.Context, interval time.Duration) {
+	if p.provider == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := p.Reload(ctx)
+			if err != nil {
+				p.logger.Error("Could not reload database credentials", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Check looks for authentication failures in err, and if it finds one it reloads the credentials straight away
+// instead of waiting for Watch to notice.
+func (p *Pool) Check(err error) {
+	if !IsAuthError(err) {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+		reloadErr := p.Reload(ctx)
+		if reloadErr != nil {
+			p.logger.Error("Could not reload database credentials", zap.Error(reloadErr))
+		}
+	}()
+}
+
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.db.Close()
+}
+
+// IsAuthError reports whether err is Postgres rejecting our credentials.
+func IsAuthError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "28P01" || pqErr.Code == "28000"
+}
+
+func newProvider(cfg *config.Config) (secrets.Provider, error) {
+	if cfg.Secrets.Provider == "" {
+		return nil, nil
+	}
+	return secrets.New(cfg.Secrets.Provider, cfg.Secrets.Dir, cfg.AWS.Region)
+}
+
+func (p *Pool) credentials(ctx context.Context) (user string, pass string, err error) {
+	if p.provider == nil {
+		return p.cfg.User, p.cfg.Pass, nil
+	}
+
+	user, err = p.provider.Get(ctx, p.userKey)
+	if err != nil {
+		return
+	}
+	pass, err = p.provider.Get(ctx, p.passKey)
+	return
+}
+
+func (p *Pool) connect(ctx context.Context, user string, pass string) (*sql.DB, error) {
+	cfg := p.cfg
+	cfg.User = user
+	cfg.Pass = pass
+
+	conn, err := openDB(cfg.ConnString())
+	if err != nil {
+		return nil, err
+	}
+	err = conn.PingContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
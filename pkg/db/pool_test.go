@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/config"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// fakeDriver accepts any connection, so pools can be swapped without a database.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+// fakeProvider hands out whatever credentials it currently holds.
+type fakeProvider struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (f *fakeProvider) Get(_ context.Context, name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.values[name]
+	if !ok {
+		return "", fmt.Errorf("no secret %s", name)
+	}
+	return value, nil
+}
+
+func (f *fakeProvider) set(name string, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[name] = value
+}
+
+// setup returns a pool connected with the provider's credentials, recording the connection string of each connection.
+func setup(t *testing.T) (*Pool, *fakeProvider, func() []string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var opened []string
+	realOpenDB, realRetireDelay := openDB, retireDelay
+	t.Cleanup(func() { openDB, retireDelay = realOpenDB, realRetireDelay })
+	openDB = func(connString string) (*sql.DB, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		opened = append(opened, connString)
+		return sql.Open("fake", connString)
+	}
+	retireDelay = time.Millisecond * 50
+
+	provider := &fakeProvider{values: map[string]string{"user": "ystadegau", "pass": "one"}}
+	p := &Pool{cfg: config.DB{Host: "localhost", Name: "ystadegau"}, provider: provider, userKey: "user", passKey: "pass", logger: zap.NewNop()}
+	user, pass, err := p.credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.db, err = p.connect(context.Background(), user, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.dbUser, p.dbPass = user, pass
+	t.Cleanup(func() { p.Close() })
+
+	return p, provider, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), opened...)
+	}
+}
+
+// waitClosed waits for db to be closed, failing the test if it isn't closed in time.
+func waitClosed(t *testing.T, db *sql.DB) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if err := db.Ping(); err != nil && strings.Contains(err.Error(), "closed") {
+			return
+		}
+	}
+	t.Error("expected the old pool to be closed")
+}
+
+func TestReload(t *testing.T) {
+	p, provider, opened := setup(t)
+	ctx := context.Background()
+	old := p.DB()
+
+	// Nothing changed, so the pool is kept.
+	err := p.Reload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DB() != old || len(opened()) != 1 {
+		t.Errorf("expected the pool to be kept when the credentials are unchanged, opened %q", opened())
+	}
+
+	provider.set("pass", "two")
+	err = p.Reload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DB() == old || len(opened()) != 2 || !strings.Contains(opened()[1], "two") {
+		t.Fatalf("expected a new pool with the new password, opened %q", opened())
+	}
+	if err := old.Ping(); err != nil {
+		t.Errorf("expected the old pool to stay open for a while, got %v", err)
+	}
+	waitClosed(t, old)
+	if err := p.DB().Ping(); err != nil {
+		t.Errorf("expected the new pool to be open, got %v", err)
+	}
+}
+
+func TestReloadFailure(t *testing.T) {
+	p, provider, _ := setup(t)
+	old := p.DB()
+
+	// A missing secret leaves the current pool in place.
+	provider.mu.Lock()
+	delete(provider.values, "pass")
+	provider.mu.Unlock()
+	if err := p.Reload(context.Background()); err == nil || p.DB() != old {
+		t.Errorf("expected the reload to fail and keep the pool, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, provider, opened := setup(t)
+	old := p.DB()
+	provider.set("pass", "two")
+
+	// Other errors don't trigger a reload.
+	p.Check(errors.New("connection refused"))
+	p.Check(&pq.Error{Code: "42P01"})
+	time.Sleep(time.Millisecond * 20)
+	if len(opened()) != 1 {
+		t.Errorf("expected no reload, opened %q", opened())
+	}
+
+	p.Check(fmt.Errorf("query failed: %w", &pq.Error{Code: "28P01"}))
+	for deadline := time.Now().Add(time.Second); p.DB() == old && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if p.DB() == old {
+		t.Fatal("expected an authentication error to reload the credentials")
+	}
+	waitClosed(t, old)
+}
+
+func TestIsAuthError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "28P01"}, true},
+		{&pq.Error{Code: "28000"}, true},
+		{fmt.Errorf("wrapped: %w", &pq.Error{Code: "28P01"}), true},
+		{&pq.Error{Code: "57014"}, false},
+		{errors.New("password authentication failed"), false},
+		{nil, false},
+	} {
+		if got := IsAuthError(test.err); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.err, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package diff compares text line by line, for showing how a package's README changed between versions.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Op byte
+
+const (
+	Equal  Op = ' '
+	Delete Op = '-'
+	Insert Op = '+'
+)
+
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// maxCells bounds the memory used to find the longest common subsequence. Texts that differ by more than this are
+// treated as entirely rewritten.
+const maxCells = 4 << 20
+
+// Lines returns the edits that turn a into b, keeping the longest common subsequence of their lines.
+func Lines(a string, b string) []Line {
+	as, bs := split(a), split(b)
+
+	// Documentation tends to change in a few places, so the common prefix and suffix are cheap to skip.
+	prefix := 0
+	for prefix < len(as) && prefix < len(bs) && as[prefix] == bs[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(as)-prefix && suffix < len(bs)-prefix && as[len(as)-1-suffix] == bs[len(bs)-1-suffix] {
+		suffix++
+	}
+
+	var lines []Line
+	for _, s := range as[:prefix] {
+		lines = append(lines, Line{Equal, s})
+	}
+	lines = append(lines, middle(as[prefix:len(as)-suffix], bs[prefix:len(bs)-suffix])...)
+	for _, s := range as[len(as)-suffix:] {
+		lines = append(lines, Line{Equal, s})
+	}
+	return lines
+}
+
+func middle(as []string, bs []string) []Line {
+	var lines []Line
+	if len(as)*len(bs) > maxCells {
+		for _, s := range as {
+			lines = append(lines, Line{Delete, s})
+		}
+		for _, s := range bs {
+			lines = append(lines, Line{Insert, s})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of as[i:] and bs[j:].
+	lcs := make([][]int, len(as)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bs)+1)
+	}
+	for i := len(as) - 1; i >= 0; i-- {
+		for j := len(bs) - 1; j >= 0; j-- {
+			if as[i] == bs[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(as) && j < len(bs) {
+		switch {
+		case as[i] == bs[j]:
+			lines = append(lines, Line{Equal, as[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Delete, as[i]})
+			i++
+		default:
+			lines = append(lines, Line{Insert, bs[j]})
+			j++
+		}
+	}
+	for ; i < len(as); i++ {
+		lines = append(lines, Line{Delete, as[i]})
+	}
+	for ; j < len(bs); j++ {
+		lines = append(lines, Line{Insert, bs[j]})
+	}
+	return lines
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Unified formats the edits as unified diff hunks, with the given number of unchanged lines around each change. File
+// headers are left out. It returns an empty string when nothing changed.
+func Unified(lines []Line, context int) string {
+	// aBefore[i] and bBefore[i] count the lines of each side before lines[i].
+	aBefore := make([]int, len(lines)+1)
+	bBefore := make([]int, len(lines)+1)
+	var changes []int
+	for i, l := range lines {
+		aBefore[i+1], bBefore[i+1] = aBefore[i], bBefore[i]
+		if l.Op != Insert {
+			aBefore[i+1]++
+		}
+		if l.Op != Delete {
+			bBefore[i+1]++
+		}
+		if l.Op != Equal {
+			changes = append(changes, i)
+		}
+	}
+
+	var sb strings.Builder
+	for n := 0; n < len(changes); {
+		// Changes close enough for their context to overlap share a hunk.
+		last := n
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= context*2+1 {
+			last++
+		}
+		start := changes[n] - context
+		if start < 0 {
+			start = 0
+		}
+		end := changes[last] + context + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aBefore[start], aBefore[end]-aBefore[start]), hunkRange(bBefore[start], bBefore[end]-bBefore[start]))
+		for _, l := range lines[start:end] {
+			sb.WriteByte(byte(l.Op))
+			sb.WriteString(l.Text)
+			sb.WriteByte('\n')
+		}
+		n = last + 1
+	}
+	return sb.String()
+}
+
+// hunkRange follows diff's convention of pointing empty ranges at the line before them.
+func hunkRange(before int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
@@ -0,0 +1,28 @@
+package diff
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want string
+	}{
+		{"same\n", "same\n", ""},
+		{"", "new\n", "@@ -0,0 +1,1 @@\n+new\n"},
+		{
+			"# Title\none\ntwo\nthree\nfour\nfive\nsix\n",
+			"# Title\none\n2\nthree\nfour\nfive\nsix\nseven\n",
+			"@@ -2,3 +2,3 @@\n one\n-two\n+2\n three\n@@ -7,1 +7,2 @@\n six\n+seven\n",
+		},
+		{
+			"a\nb\nc\n",
+			"a\nc\n",
+			"@@ -1,3 +1,2 @@\n a\n-b\n c\n",
+		},
+	} {
+		got := Unified(Lines(test.a, test.b), 1)
+		if got != test.want {
+			t.Errorf("%q to %q: expected\n%s\ngot\n%s", test.a, test.b, test.want, got)
+		}
+	}
+}
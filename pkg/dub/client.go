@@ -0,0 +1,251 @@
+package dub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// StatusError is returned when the registry responds with anything other than a 2xx status.
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("registry returned status %d for %s", e.Code, e.URL)
+}
+
+// Client talks to a dub registry, failing over to its mirrors when the registry is down.
+//
+// Mirrors are tried in the order given, skipping any that recently failed. A mirror counts as failed when it can't be
+// reached or responds with a 5xx or 429, and is skipped for RetryAfter or until a health check finds it working again.
+// Other errors, like a 404 for an unknown package, are returned as-is without trying the next mirror.
+type Client struct {
+	HTTP       *http.Client
+	RetryAfter time.Duration
+	// Username and Password are sent as basic auth when Username is set, for private registries.
+	Username string
+	Password string
+	// OnFailover, if set, is called whenever a mirror fails and the next one is about to be tried.
+	OnFailover func(baseURL string, err error)
+
+	mu      sync.Mutex
+	mirrors []*mirror
+}
+
+type mirror struct {
+	baseURL   string
+	downUntil time.Time
+}
+
+// HealthCheckPath is requested from each mirror by Check. Any response below 500 counts as healthy.
+const HealthCheckPath = "/api/packages/dub/latest"
+
+// NewClient creates a client for the registry at baseURL, e.g. "https://code.dlang.org", followed by any mirrors to
+// fail over to. If httpClient is nil then http.DefaultClient is used.
+func NewClient(baseURL string, httpClient *http.Client, mirrors ...string) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	c := &Client{HTTP: httpClient, RetryAfter: time.Minute}
+	for _, u := range append([]string{baseURL}, mirrors...) {
+		c.mirrors = append(c.mirrors, &mirror{baseURL: u})
+	}
+	return c
+}
+
+// Mirrors returns the base URL of the registry and each of its mirrors, in order of preference.
+func (c *Client) Mirrors() []string {
+	urls := make([]string, 0, len(c.mirrors))
+	for _, m := range c.mirrors {
+		urls = append(urls, m.baseURL)
+	}
+	return urls
+}
+
+// Healthy reports whether the mirror with the given base URL is currently being used.
+func (c *Client) Healthy(baseURL string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.mirrors {
+		if m.baseURL == baseURL {
+			return !time.Now().Before(m.downUntil)
+		}
+	}
+	return false
+}
+
+// Check requests HealthCheckPath from every mirror, marking each as up or down.
+func (c *Client) Check(ctx context.Context) {
+	for _, m := range c.mirrors {
+		body, err := c.getFrom(ctx, m.baseURL, HealthCheckPath)
+		if err == nil {
+			body.Close()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if isMirrorFailure(err) {
+			c.markDown(m)
+		} else {
+			c.markUp(m)
+		}
+	}
+}
+
+// Watch calls Check every interval until ctx is cancelled.
+func (c *Client) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Check(ctx)
+		}
+	}
+}
+
+// Listing fetches a page of packages, ordered by registration date.
+func (c *Client) Listing(ctx context.Context, skip int, limit int) ([]Listing, error) {
+	body, err := c.get(ctx, fmt.Sprintf("/?sort=registered&category=&skip=%d&limit=%d", skip, limit))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ParseListing(body)
+}
+
+// LatestVersion fetches the version the registry considers the latest for pkg.
+func (c *Client) LatestVersion(ctx context.Context, pkg string) (string, error) {
+	body, err := c.get(ctx, "/api/packages/"+url.PathEscape(pkg)+"/latest")
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	semver := ""
+	err = json.NewDecoder(body).Decode(&semver)
+	return semver, err
+}
+
+// Stats fetches the package's current stats, recording which mirror they came from.
+func (c *Client) Stats(ctx context.Context, pkg string) (PackageStats, error) {
+	body, baseURL, err := c.getWithMirror(ctx, "/api/packages/"+url.PathEscape(pkg)+"/stats")
+	if err != nil {
+		return PackageStats{}, err
+	}
+	defer body.Close()
+
+	stats, err := ParseStats(body)
+	stats.Mirror = baseURL
+	return stats, err
+}
+
+func (c *Client) Info(ctx context.Context, pkg string, ver string) (PackageInfo, error) {
+	body, err := c.get(ctx, "/api/packages/"+url.PathEscape(pkg)+"/"+url.PathEscape(ver)+"/info")
+	if err != nil {
+		return PackageInfo{}, err
+	}
+	defer body.Close()
+	return ParseInfo(body)
+}
+
+func (c *Client) get(ctx context.Context, path string) (io.ReadCloser, error) {
+	body, _, err := c.getWithMirror(ctx, path)
+	return body, err
+}
+
+// getWithMirror requests path from the first mirror that can serve it, returning the base URL of that mirror.
+func (c *Client) getWithMirror(ctx context.Context, path string) (io.ReadCloser, string, error) {
+	var err error
+	var prev *mirror
+	for _, m := range c.candidates() {
+		if prev != nil && c.OnFailover != nil {
+			c.OnFailover(prev.baseURL, err)
+		}
+		prev = m
+
+		var body io.ReadCloser
+		body, err = c.getFrom(ctx, m.baseURL, path)
+		if ctx.Err() != nil || !isMirrorFailure(err) {
+			if err == nil {
+				c.markUp(m)
+			}
+			return body, m.baseURL, err
+		}
+		c.markDown(m)
+	}
+	return nil, "", err
+}
+
+// candidates returns the mirrors worth trying, in order. When every mirror is down they're all tried anyway, as the
+// alternative is failing without trying at all.
+func (c *Client) candidates() []*mirror {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]*mirror, 0, len(c.mirrors))
+	for _, m := range c.mirrors {
+		if !now.Before(m.downUntil) {
+			healthy = append(healthy, m)
+		}
+	}
+	if len(healthy) == 0 {
+		return c.mirrors
+	}
+	return healthy
+}
+
+func (c *Client) markDown(m *mirror) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m.downUntil = time.Now().Add(c.RetryAfter)
+}
+
+func (c *Client) markUp(m *mirror) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m.downUntil = time.Time{}
+}
+
+func (c *Client) getFrom(ctx context.Context, baseURL string, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &StatusError{URL: req.URL.String(), Code: resp.StatusCode}
+	}
+	return resp.Body, nil
+}
+
+// isMirrorFailure decides whether err means the mirror itself is having problems, rather than the request being bad.
+func isMirrorFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+// Package dub contains the models and client for the dub package registry (code.dlang.org).
+package dub
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Listing is a single row of the registry's package list.
+type Listing struct {
+	Name       string
+	Category   string
+	Registered time.Time
+}
+
+type Downloads struct {
+	Total   int `json:"total"`
+	Monthly int `json:"monthly"`
+	Weekly  int `json:"weekly"`
+	Daily   int `json:"daily"`
+}
+
+type Repo struct {
+	Stars    int `json:"stars"`
+	Watchers int `json:"watchers"`
+	Forks    int `json:"forks"`
+	Issues   int `json:"issues"`
+}
+
+// PackageStats is the response of /api/packages/:name/stats
+type PackageStats struct {
+	Downloads Downloads `json:"downloads"`
+	Repo      Repo      `json:"repo"`
+	Score     float64   `json:"score"`
+	// Mirror is the base URL of the registry that served the stats.
+	Mirror string `json:"-"`
+}
+
+// PackageInfo is the response of /api/packages/:name/:version/info
+type PackageInfo struct {
+	Version string        `json:"version"`
+	Date    time.Time     `json:"date"`
+	Readme  string        `json:"readme"`
+	Info    PackageRecipe `json:"info"`
+}
+
+// PackageRecipe is the subset of the package's dub.json/dub.sdl that the registry hands back.
+type PackageRecipe struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	License     string   `json:"license"`
+	Authors     []string `json:"authors"`
+	// Dependencies maps each dependency's name onto either a version string or an object with more detail, neither
+	// of which is needed so far.
+	Dependencies map[string]json.RawMessage `json:"dependencies"`
+}
+
+// DependencyNames returns the packages the recipe depends on, sorted and without duplicates. Sub-packages such as
+// vibe-d:http count as their parent package, and the package's own sub-packages are left out.
+func (r PackageRecipe) DependencyNames() []string {
+	seen := make(map[string]bool, len(r.Dependencies))
+	names := make([]string, 0, len(r.Dependencies))
+	for dep := range r.Dependencies {
+		name := strings.SplitN(dep, ":", 2)[0]
+		if name == "" || name == r.Name || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseListing scrapes the registry's HTML package list. Rows with an unparsable registration date are skipped.
+func ParseListing(listing io.Reader) ([]Listing, error) {
+	arr := make([]Listing, 0, 20)
+
+	doc, err := goquery.NewDocumentFromReader(listing)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.Find("tr").Each(func(_ int, s *goquery.Selection) {
+		a := s.Find("td a")
+		if a.Length() == 0 {
+			return
+		}
+		span := s.Find("td span.dull")
+		if span.Length() == 0 {
+			return
+		}
+
+		timeAttr, _ := span.Attr("title")
+		registered, err := time.Parse("2006-Jan-02 15:04:05Z", timeAttr)
+		if err != nil {
+			return
+		}
+		category, _ := s.Find("td span.category-icon").Attr("title")
+		arr = append(arr, Listing{Name: a.Nodes[0].FirstChild.Data, Category: category, Registered: registered})
+	})
+
+	return arr, nil
+}
+
+func ParseStats(r io.Reader) (stats PackageStats, err error) {
+	err = json.NewDecoder(r).Decode(&stats)
+	return
+}
+
+func ParseInfo(r io.Reader) (info PackageInfo, err error) {
+	err = json.NewDecoder(r).Decode(&info)
+	return
+}
@@ -0,0 +1,150 @@
+package dub_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/BradleyChatha/ystadegau/pkg/dub"
+	"github.com/BradleyChatha/ystadegau/pkg/dub/dubtest"
+)
+
+func TestParseListing(t *testing.T) {
+	listings, err := dub.ParseListing(strings.NewReader(dubtest.PackageListing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listings) != 20 {
+		t.Fatalf("expected 20 listings, got %d", len(listings))
+	}
+
+	first := listings[0]
+	if first.Name != "slack-d" || first.Category != "unknown" || first.Registered.Format("2006-01-02 15:04:05") != "2021-10-10 14:50:58" {
+		t.Errorf("unexpected first listing: %+v", first)
+	}
+	if listings[11].Name != "vsignal" || listings[11].Category != "library/gamedev" {
+		t.Errorf("unexpected category: %+v", listings[11])
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(&dubtest.Registry{Versions: map[string]string{"slack-d": "0.0.1"}})
+	defer server.Close()
+	client := dub.NewClient(server.URL, server.Client())
+	ctx := context.Background()
+
+	ver, err := client.LatestVersion(ctx, "slack-d")
+	if err != nil || ver != "0.0.1" {
+		t.Fatalf("expected 0.0.1, got %q (%v)", ver, err)
+	}
+
+	info, err := client.Info(ctx, "slack-d", ver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Info.Description != "Slack API for D" || info.Info.License != "MIT" || len(info.Info.Authors) != 1 {
+		t.Errorf("unexpected info: %+v", info.Info)
+	}
+
+	stats, err := client.Stats(ctx, "slack-d")
+	if err != nil || stats.Downloads.Total != 3 || stats.Repo.Issues != 4 {
+		t.Errorf("unexpected stats: %+v (%v)", stats, err)
+	}
+
+	_, err = client.LatestVersion(ctx, "missing")
+	var statusErr *dub.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+		t.Errorf("expected a 404 StatusError, got %v", err)
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	var primaryDown int32 = 1
+	registry := &dubtest.Registry{Versions: map[string]string{"slack-d": "0.0.1"}}
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&primaryDown) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		registry.ServeHTTP(w, r)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(registry)
+	defer mirror.Close()
+
+	client := dub.NewClient(primary.URL, primary.Client(), mirror.URL)
+	var failedOver []string
+	client.OnFailover = func(baseURL string, err error) { failedOver = append(failedOver, baseURL) }
+	ctx := context.Background()
+
+	stats, err := client.Stats(ctx, "slack-d")
+	if err != nil || stats.Mirror != mirror.URL {
+		t.Fatalf("expected stats from the mirror, got %q (%v)", stats.Mirror, err)
+	}
+	if len(failedOver) != 1 || failedOver[0] != primary.URL || client.Healthy(primary.URL) {
+		t.Errorf("expected the primary to be marked down, failovers: %v", failedOver)
+	}
+
+	// The primary should be skipped while it's down, and a 404 from the mirror shouldn't count against it.
+	_, err = client.LatestVersion(ctx, "missing")
+	var statusErr *dub.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound || len(failedOver) != 1 || !client.Healthy(mirror.URL) {
+		t.Errorf("expected a 404 from the mirror alone, got %v with failovers %v", err, failedOver)
+	}
+
+	atomic.StoreInt32(&primaryDown, 0)
+	client.Check(ctx)
+	stats, err = client.Stats(ctx, "slack-d")
+	if err != nil || stats.Mirror != primary.URL {
+		t.Errorf("expected the primary to be used again once healthy, got %q (%v)", stats.Mirror, err)
+	}
+}
+
+func TestClientBasicAuth(t *testing.T) {
+	registry := &dubtest.Registry{Versions: map[string]string{"slack-d": "0.0.1"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "gwyliwr" || pass != "hunter2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registry.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := dub.NewClient(server.URL, server.Client())
+	ctx := context.Background()
+	if _, err := client.LatestVersion(ctx, "slack-d"); err == nil {
+		t.Error("expected the request to be refused without credentials")
+	}
+
+	client.Username, client.Password = "gwyliwr", "hunter2"
+	if _, err := client.LatestVersion(ctx, "slack-d"); err != nil {
+		t.Errorf("expected the credentials to be accepted: %v", err)
+	}
+}
+
+func TestDependencyNames(t *testing.T) {
+	var recipe dub.PackageRecipe
+	err := json.Unmarshal([]byte(`{
+		"name": "vibe-d",
+		"dependencies": {
+			"vibe-d:http": "*",
+			"vibe-core": "~>1.0",
+			"eventcore": {"version": "~>0.9", "optional": true},
+			"taggedalgebraic:sub": "*",
+			"taggedalgebraic": "*"
+		}
+	}`), &recipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := recipe.DependencyNames(); strings.Join(names, ",") != "eventcore,taggedalgebraic,vibe-core" {
+		t.Errorf("unexpected dependencies: %v", names)
+	}
+}
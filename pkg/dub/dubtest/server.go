@@ -0,0 +1,52 @@
+package dubtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Registry is a fake dub registry, intended for use with httptest.NewServer.
+//
+// Every package in Versions reports that version as its latest and responds with the canned
+// PackageStats and PackageVersionInfo, unless overridden by Stats. Any other package is a 404.
+type Registry struct {
+	Versions map[string]string
+	Stats    map[string]string
+}
+
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(PackageListing))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/packages/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || !strings.HasPrefix(r.URL.Path, "/api/packages/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	latest, ok := reg.Versions[parts[0]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case len(parts) == 2 && parts[1] == "latest":
+		json.NewEncoder(w).Encode(latest)
+	case len(parts) == 2 && parts[1] == "stats":
+		stats, ok := reg.Stats[parts[0]]
+		if !ok {
+			stats = PackageStats
+		}
+		w.Write([]byte(stats))
+	case len(parts) == 3 && parts[2] == "info":
+		w.Write([]byte(PackageVersionInfo))
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,148 @@
+// Package events describes the changes gwyliwr notices in the dataset, and delivers them to other tools.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+type Type string
+
+const (
+	// PackageRegistered is sent when a package first appears in a registry's package list, or reappears after being removed.
+	PackageRegistered Type = "package.registered"
+	// VersionReleased is sent when a package's latest version is one that hasn't been seen before.
+	VersionReleased Type = "version.released"
+	// PackageRemoved is sent when the registry no longer knows about a package.
+	PackageRemoved Type = "package.removed"
+	// MilestoneCrossed is sent when a package's total downloads or stars pass a power of ten.
+	MilestoneCrossed Type = "milestone.crossed"
+)
+
+// Types lists every event type.
+var Types = []Type{PackageRegistered, VersionReleased, PackageRemoved, MilestoneCrossed}
+
+type Event struct {
+	// ID is unique to each event, so receivers can ignore redeliveries.
+	ID       string    `json:"id"`
+	Type     Type      `json:"type"`
+	Time     time.Time `json:"time"`
+	Registry string    `json:"registry"`
+	Package  string    `json:"package"`
+	// Version is set for VersionReleased.
+	Version string `json:"version,omitempty"`
+	// Metric and Milestone are set for MilestoneCrossed, e.g. "downloads_total" and 10000.
+	Metric    string `json:"metric,omitempty"`
+	Milestone int64  `json:"milestone,omitempty"`
+}
+
+// New creates an event of the given type with a fresh ID, happening now.
+func New(t Type, registry string, pkg string) Event {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return Event{ID: hex.EncodeToString(id), Type: t, Time: time.Now().UTC(), Registry: registry, Package: pkg}
+}
+
+// Milestone returns the highest power of ten, starting from min, that the value reached on its way from prev to next.
+func Milestone(prev int64, next int64, min int64) (int64, bool) {
+	var crossed int64
+	for m := min; m > 0 && m <= next; m *= 10 {
+		if m > prev {
+			crossed = m
+		}
+	}
+	return crossed, crossed != 0
+}
+
+// Sink is somewhere events are delivered to.
+type Sink interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Nop throws every event away.
+type Nop struct{}
+
+func (Nop) Publish(context.Context, Event) error {
+	return nil
+}
+
+// Queue sends each event as a JSON message to an SQS queue, with its type in the "type" message attribute.
+type Queue struct {
+	client *sqs.SQS
+	url    string
+}
+
+func NewQueue(url string, region string) (*Queue, error) {
+	ses, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{client: sqs.New(ses), url: url}, nil
+}
+
+func (q *Queue) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.url),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String(string(e.Type))},
+		},
+	})
+	return err
+}
+
+// Async publishes events in the background so slow sinks, or their retries, don't hold up the caller. Events are
+// dropped if too many are waiting.
+type Async struct {
+	sink    Sink
+	events  chan Event
+	onError func(Event, error)
+	wg      sync.WaitGroup
+}
+
+// NewAsync starts publishing to sink in the background, keeping up to size events waiting. onError is called for each
+// event that couldn't be published, including dropped ones.
+func NewAsync(sink Sink, size int, onError func(Event, error)) *Async {
+	a := &Async{sink: sink, events: make(chan Event, size), onError: onError}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		for e := range a.events {
+			err := a.sink.Publish(context.Background(), e)
+			if err != nil {
+				a.onError(e, err)
+			}
+		}
+	}()
+	return a
+}
+
+func (a *Async) Publish(_ context.Context, e Event) error {
+	select {
+	case a.events <- e:
+	default:
+		a.onError(e, errors.New("too many events are waiting to be published"))
+	}
+	return nil
+}
+
+// Close waits for every waiting event to be published. Nothing can be published afterwards.
+func (a *Async) Close() {
+	close(a.events)
+	a.wg.Wait()
+}
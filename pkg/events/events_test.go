@@ -0,0 +1,121 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+func TestMilestone(t *testing.T) {
+	for _, test := range []struct {
+		prev, next, min int64
+		want            int64
+	}{
+		{99, 100, 100, 100},
+		{99, 12345, 100, 10000},
+		{100, 999, 100, 0},
+		{5, 50, 100, 0},
+		{0, 10, 10, 10},
+	} {
+		got, ok := Milestone(test.prev, test.next, test.min)
+		if got != test.want || ok != (test.want != 0) {
+			t.Errorf("%d to %d: expected %d, got %d", test.prev, test.next, test.want, got)
+		}
+	}
+}
+
+type subscriptions []store.Webhook
+
+func (s subscriptions) Webhooks(context.Context) ([]store.Webhook, error) {
+	return s, nil
+}
+
+func TestWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	var received []Event
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first delivery fails, so it has to be retried.
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			t.Errorf("expected the body to be signed, got %q", r.Header.Get(SignatureHeader))
+		}
+		var e Event
+		json.Unmarshal(body, &e)
+		if r.Header.Get(TypeHeader) != string(e.Type) || r.Header.Get(DeliveryHeader) != e.ID {
+			t.Errorf("expected the type and ID headers to match the event, got %+v", r.Header)
+		}
+		received = append(received, e)
+	}))
+	defer server.Close()
+
+	hooks := NewWebhooks(subscriptions{
+		{ID: 1, URL: server.URL, Secret: "secret", Events: []string{string(VersionReleased)}},
+		{ID: 2, URL: server.URL, Secret: "secret"},
+	})
+	hooks.RetryDelay = time.Millisecond
+
+	err := hooks.Publish(context.Background(), New(PackageRemoved, "dub", "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := New(VersionReleased, "dub", "new")
+	released.Version = "1.0.0"
+	err = hooks.Publish(context.Background(), released)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 4 || len(received) != 3 || received[0].Type != PackageRemoved || received[1].Version != "1.0.0" {
+		t.Errorf("expected each subscription to get the events it wants, got %d attempts and %+v", attempts, received)
+	}
+
+	hooks.Retries = 1
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	err = hooks.Publish(context.Background(), released)
+	if err == nil {
+		t.Error("expected an error once the retries ran out")
+	}
+}
+
+func TestAsync(t *testing.T) {
+	var mu sync.Mutex
+	var published []Event
+	async := NewAsync(sinkFunc(func(e Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		published = append(published, e)
+		return nil
+	}), 10, func(e Event, err error) { t.Errorf("unexpected error publishing %+v: %v", e, err) })
+
+	for i := 0; i < 5; i++ {
+		async.Publish(context.Background(), New(PackageRegistered, "dub", "pkg"))
+	}
+	async.Close()
+	if len(published) != 5 {
+		t.Errorf("expected every event to be published before Close returned, got %d", len(published))
+	}
+}
+
+type sinkFunc func(Event) error
+
+func (f sinkFunc) Publish(_ context.Context, e Event) error {
+	return f(e)
+}
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/store"
+)
+
+const (
+	// SignatureHeader holds "sha256=" followed by the hex HMAC-SHA256 of the request body, keyed by the subscription's secret.
+	SignatureHeader = "X-Ystadegau-Signature"
+	TypeHeader      = "X-Ystadegau-Event"
+	DeliveryHeader  = "X-Ystadegau-Delivery"
+)
+
+// Subscriptions lists the webhooks to deliver to, and is usually a store.Store.
+type Subscriptions interface {
+	Webhooks(ctx context.Context) ([]store.Webhook, error)
+}
+
+// Webhooks POSTs each event as JSON to every subscription that wants it.
+type Webhooks struct {
+	HTTP *http.Client
+	// Retries is how many more times a failed delivery is attempted, waiting RetryDelay and then twice as long as the
+	// last wait between each attempt.
+	Retries    int
+	RetryDelay time.Duration
+
+	subs Subscriptions
+}
+
+func NewWebhooks(subs Subscriptions) *Webhooks {
+	return &Webhooks{
+		HTTP:       &http.Client{Timeout: time.Second * 10},
+		Retries:    5,
+		RetryDelay: time.Second,
+		subs:       subs,
+	}
+}
+
+// Publish delivers to every subscription even if some fail, returning the last error.
+func (w *Webhooks) Publish(ctx context.Context, e Event) error {
+	subs, err := w.subs.Webhooks(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, sub := range subs {
+		if !sub.Wants(string(e.Type)) {
+			continue
+		}
+		err = w.deliver(ctx, sub, e, body)
+		if err != nil {
+			lastErr = fmt.Errorf("webhook %d: %w", sub.ID, err)
+		}
+	}
+	return lastErr
+}
+
+func (w *Webhooks) deliver(ctx context.Context, sub store.Webhook, e Event, body []byte) error {
+	delay := w.RetryDelay
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = w.post(ctx, sub, e, body)
+		if err == nil || attempt >= w.Retries {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (w *Webhooks) post(ctx context.Context, sub store.Webhook, e Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TypeHeader, string(e.Type))
+	req.Header.Set(DeliveryHeader, e.ID)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, body))
+
+	resp, err := w.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", sub.URL, resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the value of SignatureHeader for the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,18 @@
+module github.com/BradleyChatha/ystadegau/pkg
+
+go 1.17
+
+require (
+	github.com/PuerkitoBio/goquery v1.7.1
+	github.com/aws/aws-sdk-go v1.41.1
+	github.com/lib/pq v1.10.3
+	go.uber.org/zap v1.19.1
+)
+
+require (
+	github.com/andybalholm/cascadia v1.2.0 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	golang.org/x/net v0.0.0-20210614182718-04defd469f4e // indirect
+)
@@ -0,0 +1,113 @@
+// Package secrets abstracts over where credentials are stored.
+//
+// Providers are expected to be asked repeatedly, and should return the current value each time
+// rather than caching it, so that rotated credentials are picked up by long-running services.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// New creates the provider called kind, which is one of "ssm", "env", or "file".
+// dir is only used by the file provider, and region only by the SSM provider.
+func New(kind string, dir string, region string) (Provider, error) {
+	switch kind {
+	case "ssm":
+		return NewSSM(region)
+	case "env":
+		return Env{}, nil
+	case "file":
+		if dir == "" {
+			return nil, errors.New("the file secrets provider needs a directory")
+		}
+		return File{Dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unknown secrets provider %q", kind)
+	}
+}
+
+// SSM reads secrets from AWS SSM parameters, decrypting SecureStrings.
+type SSM struct {
+	client *ssm.SSM
+}
+
+func NewSSM(region string) (*SSM, error) {
+	ses, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SSM{client: ssm.New(ses)}, nil
+}
+
+func (s *SSM) Get(ctx context.Context, name string) (string, error) {
+	param, err := s.client.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == ssm.ErrCodeParameterNotFound {
+			return "", fmt.Errorf("%w: SSM parameter %s", ErrNotFound, name)
+		}
+		return "", fmt.Errorf("could not get SSM parameter %s: %w", name, err)
+	}
+	return *param.Parameter.Value, nil
+}
+
+// Env reads secrets from environment variables, where "db_lambda_pass" is read from DB_LAMBDA_PASS.
+type Env struct {
+	Prefix string
+}
+
+func (e Env) Get(_ context.Context, name string) (string, error) {
+	key := e.Prefix + strings.ToUpper(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name))
+
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("%w: environment variable %s", ErrNotFound, key)
+	}
+	return value, nil
+}
+
+// File reads each secret from a file of the same name inside Dir, such as the ones written by a nomad template.
+// Trailing newlines are removed.
+type File struct {
+	Dir string
+}
+
+func (f File) Get(_ context.Context, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(f.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: file %s", ErrNotFound, filepath.Join(f.Dir, name))
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
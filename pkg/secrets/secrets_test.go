@@ -0,0 +1,65 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	t.Setenv("DB_LAMBDA_PASS", "hunter2")
+	t.Setenv("YS_DB_USER_2", "ystadegau")
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		provider Env
+		name     string
+		want     string
+	}{
+		{Env{}, "db_lambda_pass", "hunter2"},
+		{Env{}, "db-lambda.pass", "hunter2"},
+		{Env{Prefix: "YS_"}, "db/user/2", "ystadegau"},
+	} {
+		got, err := test.provider.Get(ctx, test.name)
+		if err != nil || got != test.want {
+			t.Errorf("%s: expected %q, got %q: %v", test.name, test.want, got, err)
+		}
+	}
+
+	if _, err := (Env{}).Get(ctx, "missing_secret"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a missing variable to be not found, got %v", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "db_pass"), []byte("hunter2\r\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A secret outside of the directory, which traversal shouldn't reach.
+	err = os.WriteFile(filepath.Join(filepath.Dir(dir), "outside"), []byte("leaked"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(filepath.Dir(dir), "outside")) })
+	ctx := context.Background()
+	f := File{Dir: dir}
+
+	got, err := f.Get(ctx, "db_pass")
+	if err != nil || got != "hunter2" {
+		t.Errorf("expected the secret without its trailing newline, got %q: %v", got, err)
+	}
+	if _, err := f.Get(ctx, "db_user"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a missing file to be not found, got %v", err)
+	}
+
+	for _, name := range []string{"", ".", "..", "../outside", `..\outside`, "sub/db_pass", "/etc/passwd"} {
+		got, err := f.Get(ctx, name)
+		if err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("%q: expected the name to be rejected, got %q: %v", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Package semver parses the version strings used by the dub registry: semantic versions such as "1.2.3-beta.1+abc",
+// and branch versions such as "~master".
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+	// Branch is set instead of everything else for branch versions, without the leading '~'.
+	Branch string
+}
+
+func Parse(s string) (Version, error) {
+	if strings.HasPrefix(s, "~") {
+		if len(s) == 1 {
+			return Version{}, fmt.Errorf("semver: %q has an empty branch name", s)
+		}
+		return Version{Branch: s[1:]}, nil
+	}
+
+	var v Version
+	rest := s
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(v.Build) {
+			return Version{}, fmt.Errorf("semver: %q has an invalid build", s)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.Prerelease = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(v.Prerelease) {
+			return Version{}, fmt.Errorf("semver: %q has an invalid prerelease", s)
+		}
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("semver: %q should have a major, minor and patch version", s)
+	}
+	for i, dest := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if !isNumeric(parts[i]) {
+			return Version{}, fmt.Errorf("semver: %q has a non-numeric component %q", s, parts[i])
+		}
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return Version{}, fmt.Errorf("semver: %q: %w", s, err)
+		}
+		*dest = n
+	}
+	return v, nil
+}
+
+func (v Version) IsBranch() bool {
+	return v.Branch != ""
+}
+
+func (v Version) IsPrerelease() bool {
+	return v.Prerelease != ""
+}
+
+func (v Version) String() string {
+	if v.IsBranch() {
+		return "~" + v.Branch
+	}
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 depending on whether a has a lower, equal or higher precedence than b. Build metadata is
+// ignored, as the spec requires. Branches have no meaningful order, so they come before every release and are
+// otherwise only compared by name.
+func Compare(a, b Version) int {
+	switch {
+	case a.IsBranch() && b.IsBranch():
+		return strings.Compare(a.Branch, b.Branch)
+	case a.IsBranch():
+		return -1
+	case b.IsBranch():
+		return 1
+	}
+
+	for _, pair := range [][2]int{{a.Major, b.Major}, {a.Minor, b.Minor}, {a.Patch, b.Patch}} {
+		if c := compareInt(pair[0], pair[1]); c != 0 {
+			return c
+		}
+	}
+
+	// A release has a higher precedence than any of its prereleases.
+	switch {
+	case a.Prerelease == b.Prerelease:
+		return 0
+	case a.Prerelease == "":
+		return 1
+	case b.Prerelease == "":
+		return -1
+	}
+
+	as, bs := strings.Split(a.Prerelease, "."), strings.Split(b.Prerelease, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareIdentifier(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
+
+// compareIdentifier compares numeric identifiers numerically, and anything else in ASCII order. Numeric identifiers
+// always come first.
+func compareIdentifier(a, b string) int {
+	an, bn := isNumeric(a), isNumeric(b)
+	switch {
+	case an && bn:
+		if c := compareInt(len(a), len(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	case an:
+		return -1
+	case bn:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func validIdentifiers(s string) bool {
+	for _, ident := range strings.Split(s, ".") {
+		if ident == "" {
+			return false
+		}
+		for _, r := range ident {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
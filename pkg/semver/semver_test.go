@@ -0,0 +1,72 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+	}{
+		{"1.2.3", Version{Major: 1, Minor: 2, Patch: 3}},
+		{"0.10.0-beta.1", Version{Minor: 10, Prerelease: "beta.1"}},
+		{"1.0.0-rc-1+build.5", Version{Major: 1, Prerelease: "rc-1", Build: "build.5"}},
+		{"2.0.0+20211010", Version{Major: 2, Build: "20211010"}},
+		{"~master", Version{Branch: "master"}},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: expected %+v, got %+v", test.in, test.want, got)
+		}
+		if got.String() != test.in {
+			t.Errorf("%s: round tripped to %s", test.in, got.String())
+		}
+	}
+
+	for _, bad := range []string{"", "~", "1.2", "1.2.3.4", "v1.2.3", "1.x.3", "1.2.3-", "1.2.3-beta..1", "1.2.3+", "1.2.3-be_ta"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("expected %q to fail to parse", bad)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// In ascending order of precedence, mostly taken from the examples in the semver spec.
+	ordered := []string{
+		"~master",
+		"~stable",
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"10.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := Parse(ordered[i])
+			b, _ := Parse(ordered[j])
+			want := compareInt(i, j)
+			if got := Compare(a, b); got != want {
+				t.Errorf("Compare(%s, %s): expected %d, got %d", ordered[i], ordered[j], want, got)
+			}
+		}
+	}
+
+	a, _ := Parse("1.0.0+a")
+	b, _ := Parse("1.0.0+b")
+	if Compare(a, b) != 0 {
+		t.Error("expected build metadata to be ignored")
+	}
+}
@@ -0,0 +1,1048 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/semver"
+)
+
+// Memory is an in-process Store for tests and local experiments.
+type Memory struct {
+	mu         sync.Mutex
+	now        func() time.Time
+	registries []Registry
+	packages   []*memoryPackage
+	versions   []Version
+	snapshots  []Snapshot
+	rollups    map[Resolution][]Snapshot
+	downloads  map[int][]DailyDownloads
+	webhooks   []Webhook
+	webhookID  int
+	alertRules []AlertRule
+	alertID    int
+	alerts     []AlertState
+	readmes    map[int]Readme
+	// versionSeen stands in for package_version.created_at.
+	versionSeen map[int]time.Time
+}
+
+type memoryPackage struct {
+	Package
+	searchText   string
+	authors      []string
+	license      string
+	category     string
+	dependencies []string
+	removed      bool
+	// failedRules are the rules broken by the package's last ValidationFailures snapshots.
+	failedRules string
+}
+
+func NewMemory() *Memory {
+	return &Memory{now: time.Now, rollups: make(map[Resolution][]Snapshot), downloads: make(map[int][]DailyDownloads), readmes: make(map[int]Readme), versionSeen: make(map[int]time.Time)}
+}
+
+// SetClock replaces the function used in place of Postgres' now().
+func (m *Memory) SetClock(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+func (m *Memory) EnsureRegistry(_ context.Context, name string, url string) (Registry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.registries {
+		if m.registries[i].Name == name {
+			m.registries[i].URL = url
+			return m.registries[i], nil
+		}
+	}
+	reg := Registry{ID: len(m.registries) + 1, Name: name, URL: url}
+	m.registries = append(m.registries, reg)
+	return reg, nil
+}
+
+func (m *Memory) RegistryByName(_ context.Context, name string) (Registry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, reg := range m.registries {
+		if reg.Name == name {
+			return reg, nil
+		}
+	}
+	return Registry{}, ErrNotFound
+}
+
+func (m *Memory) AddPackage(_ context.Context, registryID int, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if pkg := m.findPackage(registryID, name); pkg != nil {
+		if !pkg.removed {
+			return false, nil
+		}
+		pkg.removed = false
+		pkg.NextUpdate = m.now()
+		return true, nil
+	}
+	m.packages = append(m.packages, &memoryPackage{
+		Package: Package{ID: len(m.packages) + 1, RegistryID: registryID, Name: name, NextUpdate: m.now()},
+	})
+	return true, nil
+}
+
+func (m *Memory) MarkRemoved(_ context.Context, packageID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pkg := m.packageByID(packageID)
+	if pkg == nil || pkg.removed {
+		return false, nil
+	}
+	pkg.removed = true
+	return true, nil
+}
+
+func (m *Memory) PackageByName(_ context.Context, registryID int, name string) (Package, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pkg := m.findPackage(registryID, name)
+	if pkg == nil {
+		return Package{}, ErrNotFound
+	}
+	return pkg.Package, nil
+}
+
+func (m *Memory) PackagesDue(_ context.Context) ([]Package, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pkgs []Package
+	now := m.now()
+	for _, pkg := range m.packages {
+		if pkg.NextUpdate.Before(now) && !pkg.removed {
+			pkgs = append(pkgs, pkg.Package)
+		}
+	}
+	return pkgs, nil
+}
+
+func (m *Memory) CountOverdue(ctx context.Context) (int, error) {
+	pkgs, err := m.PackagesDue(ctx)
+	return len(pkgs), err
+}
+
+func (m *Memory) UpdateSearchText(_ context.Context, packageID int, description string, readme string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pkg := m.packageByID(packageID)
+	if pkg == nil {
+		return ErrNotFound
+	}
+	pkg.searchText = strings.ToLower(pkg.Name + " " + readme + " " + description)
+	return nil
+}
+
+func (m *Memory) SetMetadata(_ context.Context, packageID int, authors []string, license string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pkg := m.packageByID(packageID)
+	if pkg == nil {
+		return ErrNotFound
+	}
+	pkg.authors = authors
+	pkg.license = license
+	return nil
+}
+
+func (m *Memory) SetCategory(_ context.Context, registryID int, name string, category string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, pkg := range m.packages {
+		if pkg.RegistryID == registryID && pkg.Name == name {
+			pkg.category = category
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) SetDependencies(_ context.Context, packageID int, names []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pkg := m.packageByID(packageID)
+	if pkg == nil {
+		return ErrNotFound
+	}
+	pkg.dependencies = names
+	return nil
+}
+
+func (m *Memory) RecordValidationFailure(_ context.Context, packageID int, rules string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pkg := m.packageByID(packageID)
+	if pkg == nil {
+		return 0, ErrNotFound
+	}
+	switch {
+	case rules == "":
+		pkg.ValidationFailures = 0
+	case rules == pkg.failedRules:
+		pkg.ValidationFailures++
+	default:
+		pkg.ValidationFailures = 1
+	}
+	pkg.failedRules = rules
+	return pkg.ValidationFailures, nil
+}
+
+func (m *Memory) SetReadme(_ context.Context, r Readme) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r.Hash = ReadmeHash(r.Description, r.Readme)
+	if m.readmes[r.VersionID].Hash == r.Hash {
+		return false, nil
+	}
+	r.UpdatedAt = m.now()
+	m.readmes[r.VersionID] = r
+	return true, nil
+}
+
+func (m *Memory) Readme(_ context.Context, versionID int) (Readme, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.readmes[versionID]
+	if !ok {
+		return r, ErrNotFound
+	}
+	return r, nil
+}
+
+func (m *Memory) BumpUpdateTime(_ context.Context, packageID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pkg := m.packageByID(packageID)
+	if pkg == nil {
+		return ErrNotFound
+	}
+	pkg.NextUpdate = m.now().Add(UpdateInterval)
+	return nil
+}
+
+func (m *Memory) EnsureVersion(ctx context.Context, packageID int, semverStr string) (Version, error) {
+	ver, err := m.FindVersion(ctx, packageID, semverStr)
+	if err != ErrNotFound {
+		return ver, err
+	}
+	if _, err := semver.Parse(semverStr); err != nil {
+		return Version{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ver = Version{ID: len(m.versions) + 1, PackageID: packageID, Semver: semverStr}
+	m.versions = append(m.versions, ver)
+	m.versionSeen[ver.ID] = m.now()
+	return ver, nil
+}
+
+func (m *Memory) FindVersion(_ context.Context, packageID int, semver string) (Version, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ver := range m.versions {
+		if ver.PackageID == packageID && ver.Semver == semver {
+			return ver, nil
+		}
+	}
+	return Version{}, ErrNotFound
+}
+
+func (m *Memory) Versions(_ context.Context, packageID int) ([]Version, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	arr := make([]Version, 0, 8)
+	for _, ver := range m.versions {
+		if ver.PackageID == packageID {
+			arr = append(arr, ver)
+		}
+	}
+	return arr, nil
+}
+
+func (m *Memory) LatestVersion(_ context.Context, packageID int, includePrerelease bool) (Version, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mirrors the ORDER BY in Postgres: stable releases, then prereleases, then branches (newest first).
+	rank := func(v semver.Version) int {
+		switch {
+		case v.IsBranch():
+			return 2
+		case v.IsPrerelease() && !includePrerelease:
+			return 1
+		}
+		return 0
+	}
+
+	var best Version
+	var bestParsed semver.Version
+	found := false
+	for _, ver := range m.versions {
+		if ver.PackageID != packageID {
+			continue
+		}
+		parsed, _ := semver.Parse(ver.Semver)
+		if found {
+			r, bestRank := rank(parsed), rank(bestParsed)
+			if r > bestRank || (r == bestRank && !parsed.IsBranch() && semver.Compare(parsed, bestParsed) < 0) {
+				continue
+			}
+		}
+		best, bestParsed, found = ver, parsed, true
+	}
+	if !found {
+		return Version{}, ErrNotFound
+	}
+	return best, nil
+}
+
+func (m *Memory) LatestPrerelease(_ context.Context, packageID int) (Version, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var best Version
+	var bestParsed semver.Version
+	found := false
+	for _, ver := range m.versions {
+		parsed, _ := semver.Parse(ver.Semver)
+		if ver.PackageID != packageID || parsed.IsBranch() || !parsed.IsPrerelease() {
+			continue
+		}
+		if found && semver.Compare(parsed, bestParsed) < 0 {
+			continue
+		}
+		best, bestParsed, found = ver, parsed, true
+	}
+	if !found {
+		return Version{}, ErrNotFound
+	}
+	return best, nil
+}
+
+func (m *Memory) AddSnapshot(_ context.Context, snapshot Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if snapshot.ValidTo.IsZero() {
+		snapshot.ValidTo = snapshot.Time
+	}
+	snapshot.ID = len(m.snapshots) + 1
+	m.snapshots = append(m.snapshots, snapshot)
+	return nil
+}
+
+func (m *Memory) ExtendSnapshot(_ context.Context, snapshotID int, to time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if snapshotID < 1 || snapshotID > len(m.snapshots) || m.snapshots[snapshotID-1].VersionID == 0 {
+		return ErrNotFound
+	}
+	if s := &m.snapshots[snapshotID-1]; to.After(s.ValidTo) {
+		s.ValidTo = to
+	}
+	return nil
+}
+
+func (m *Memory) Snapshots(_ context.Context, q SnapshotQuery) ([]Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := func(versionID int) bool {
+		if q.PackageID != 0 {
+			return m.versionPackage(versionID) == q.PackageID
+		}
+		return versionID == q.VersionID
+	}
+
+	arr := make([]Snapshot, 0, 16)
+	if q.Resolution != "" && q.Resolution != Raw {
+		since := q.Resolution.Truncate(q.Since)
+		buckets := make(map[time.Time]int)
+		for _, s := range m.rollups[q.Resolution] {
+			if !wanted(s.VersionID) || s.Time.Before(since) || (!q.Until.IsZero() && !s.Time.Before(q.Until)) {
+				continue
+			}
+			// Version IDs are assigned in the order versions are seen, so the highest is the newest.
+			if i, ok := buckets[s.Time]; ok {
+				samples := arr[i].Samples + s.Samples
+				if s.VersionID > arr[i].VersionID {
+					arr[i] = s
+				}
+				arr[i].Samples = samples
+				continue
+			}
+			buckets[s.Time] = len(arr)
+			arr = append(arr, s)
+		}
+	} else {
+		for _, s := range m.snapshots {
+			if wanted(s.VersionID) && !s.ValidTo.Before(q.Since) && (q.IncludeFlagged || !s.Flagged()) &&
+				(q.Until.IsZero() || s.Time.Before(q.Until)) {
+				arr = append(arr, s)
+			}
+		}
+		return expandSnapshots(arr, q.Since, q.Until), nil
+	}
+	sort.SliceStable(arr, func(i, j int) bool { return arr[i].Time.Before(arr[j].Time) })
+	return arr, nil
+}
+
+func (m *Memory) LastSnapshot(_ context.Context, packageID int) (Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last *Snapshot
+	for i, s := range m.snapshots {
+		if s.Flagged() || m.versionPackage(s.VersionID) != packageID {
+			continue
+		}
+		if last == nil || !s.Time.Before(last.Time) {
+			last = &m.snapshots[i]
+		}
+	}
+	if last == nil {
+		return Snapshot{}, ErrNotFound
+	}
+	return *last, nil
+}
+
+func (m *Memory) SnapshotAt(_ context.Context, packageID int, at time.Time) (Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found *Snapshot
+	for i, s := range m.snapshots {
+		if s.Flagged() || s.Time.After(at) || m.versionPackage(s.VersionID) != packageID {
+			continue
+		}
+		if found == nil || !s.Time.Before(found.Time) {
+			found = &m.snapshots[i]
+		}
+	}
+	if found == nil {
+		return Snapshot{}, ErrNotFound
+	}
+	return *found, nil
+}
+
+func (m *Memory) Rollup(_ context.Context, resolution Resolution) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing := m.rollups[resolution]
+	var latest time.Time
+	for _, r := range existing {
+		if r.Time.After(latest) {
+			latest = r.Time
+		}
+	}
+
+	type key struct {
+		versionID int
+		bucket    time.Time
+	}
+	buckets := make(map[key]Snapshot)
+	for _, s := range m.snapshots {
+		if s.Flagged() || s.VersionID == 0 || s.ValidTo.Before(latest) {
+			continue
+		}
+		last := resolution.Truncate(s.ValidTo)
+		for bucket := resolution.Truncate(s.Time); !bucket.After(last); bucket = resolution.Next(bucket) {
+			if bucket.Before(latest) {
+				continue
+			}
+			k := key{s.VersionID, bucket}
+			prev, ok := buckets[k]
+			kept := s
+			if ok && prev.Time.After(s.Time) {
+				kept = prev
+			}
+			kept.Samples = prev.Samples + 1
+			buckets[k] = kept
+		}
+	}
+
+	for k, s := range buckets {
+		rollup := Snapshot{
+			VersionID:        k.versionID,
+			Time:             k.bucket,
+			Samples:          s.Samples,
+			DownloadsWeekly:  s.DownloadsWeekly,
+			DownloadsMonthly: s.DownloadsMonthly,
+			DownloadsTotal:   s.DownloadsTotal,
+			Stars:            s.Stars,
+			Watchers:         s.Watchers,
+			Issues:           s.Issues,
+			Forks:            s.Forks,
+		}
+		replaced := false
+		for i := range existing {
+			if existing[i].VersionID == k.versionID && existing[i].Time.Equal(k.bucket) {
+				existing[i], replaced = rollup, true
+				break
+			}
+		}
+		if !replaced {
+			existing = append(existing, rollup)
+		}
+	}
+	m.rollups[resolution] = existing
+	return int64(len(buckets)), nil
+}
+
+func (m *Memory) AddDailyDownloads(_ context.Context, packageID int, days []DailyDownloads) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing := m.downloads[packageID]
+	for _, d := range days {
+		added := false
+		for i := range existing {
+			if existing[i].Day.Equal(d.Day) {
+				existing[i].Downloads += d.Downloads
+				existing[i].Coverage += d.Coverage
+				added = true
+				break
+			}
+		}
+		if !added {
+			existing = append(existing, d)
+		}
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].Day.Before(existing[j].Day) })
+	m.downloads[packageID] = existing
+	return nil
+}
+
+func (m *Memory) DailyDownloads(_ context.Context, packageID int, since time.Time) ([]DailyDownloads, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since = Daily.Truncate(since)
+	arr := make([]DailyDownloads, 0, 16)
+	for _, d := range m.downloads[packageID] {
+		if !d.Day.Before(since) {
+			arr = append(arr, d)
+		}
+	}
+	return arr, nil
+}
+
+func (m *Memory) PruneSnapshots(_ context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// IDs are handed out by position, so pruned snapshots are blanked out rather than removed to keep them unique.
+	var pruned int64
+	for i, s := range m.snapshots {
+		if s.VersionID != 0 && s.ValidTo.Before(before) {
+			m.snapshots[i] = Snapshot{ID: s.ID}
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+func (m *Memory) AddWebhook(_ context.Context, w Webhook) (Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.webhookID++
+	w.ID = m.webhookID
+	w.CreatedAt = m.now()
+	m.webhooks = append(m.webhooks, w)
+	return w, nil
+}
+
+func (m *Memory) Webhooks(_ context.Context) ([]Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Webhook(nil), m.webhooks...), nil
+}
+
+func (m *Memory) DeleteWebhook(_ context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, w := range m.webhooks {
+		if w.ID == id {
+			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) AddAlertRule(_ context.Context, r AlertRule) (AlertRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.alertID++
+	r.ID = m.alertID
+	r.CreatedAt = m.now()
+	m.alertRules = append(m.alertRules, r)
+	return r, nil
+}
+
+func (m *Memory) AlertRules(_ context.Context) ([]AlertRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]AlertRule(nil), m.alertRules...), nil
+}
+
+func (m *Memory) DeleteAlertRule(_ context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, r := range m.alertRules {
+		if r.ID != id {
+			continue
+		}
+		m.alertRules = append(m.alertRules[:i], m.alertRules[i+1:]...)
+		kept := m.alerts[:0]
+		for _, state := range m.alerts {
+			if state.RuleID != id {
+				kept = append(kept, state)
+			}
+		}
+		m.alerts = kept
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) AlertState(_ context.Context, ruleID int, packageID int) (AlertState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, state := range m.alerts {
+		if state.RuleID == ruleID && state.PackageID == packageID {
+			return state, nil
+		}
+	}
+	return AlertState{}, ErrNotFound
+}
+
+func (m *Memory) SetAlertState(_ context.Context, state AlertState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state.Package = ""
+	for i := range m.alerts {
+		if m.alerts[i].RuleID == state.RuleID && m.alerts[i].PackageID == state.PackageID {
+			m.alerts[i] = state
+			return nil
+		}
+	}
+	m.alerts = append(m.alerts, state)
+	return nil
+}
+
+func (m *Memory) FiringAlerts(_ context.Context) ([]AlertState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var arr []AlertState
+	for _, state := range m.alerts {
+		if !state.Firing {
+			continue
+		}
+		if pkg := m.packageByID(state.PackageID); pkg != nil {
+			state.Package = pkg.Name
+		}
+		arr = append(arr, state)
+	}
+	sort.SliceStable(arr, func(i, j int) bool { return arr[i].Since.Before(arr[j].Since) })
+	return arr, nil
+}
+
+// Search approximates search_packages: an exact name match scores 10, a name starting or ending with the query scores 1,
+// any other name similar enough to the query scores its similarity, and matching every positive term in the package's
+// search text scores 0.1 per term. Filters and terms are matched against the lower cased text as substrings, rather
+// than as lexemes.
+func (m *Memory) Search(_ context.Context, q SearchQuery) ([]SearchResult, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range q.Filters {
+		if f.Field != "name" && f.Field != "author" && f.Field != "license" {
+			return nil, 0, fmt.Errorf("unknown filter %q", f.Field)
+		}
+	}
+
+	query := q.Query
+	arr := make([]SearchResult, 0, 50)
+outer:
+	for _, pkg := range m.packages {
+		if q.RegistryID != 0 && pkg.RegistryID != q.RegistryID {
+			continue
+		}
+		for _, f := range q.Filters {
+			if pkg.matches(f) == f.Negate {
+				continue outer
+			}
+		}
+
+		rank := 0.0
+		if query != "" && pkg.Name == query {
+			rank += 10
+		}
+		if query != "" && (strings.HasPrefix(pkg.Name, query) || strings.HasSuffix(pkg.Name, query)) {
+			rank += 1
+		}
+		if sim := Similarity(pkg.Name, query); query != "" && pkg.Name != query && sim >= FuzzyThreshold {
+			rank += sim
+		}
+		positive := 0
+		matched := pkg.searchText != ""
+		for _, t := range q.Terms {
+			hit := pkg.searchText != "" && strings.Contains(pkg.searchText, strings.ToLower(strings.Join(t.Words, " ")))
+			if t.Negate {
+				if hit {
+					continue outer
+				}
+				continue
+			}
+			positive++
+			matched = matched && hit
+		}
+		if positive > 0 && matched {
+			rank += 0.1 * float64(positive)
+		}
+
+		// Searches that are only filters match every package.
+		if rank > 0 || (query == "" && positive == 0) {
+			arr = append(arr, m.searchResult(pkg, rank))
+		}
+	}
+
+	less, err := searchLess(q.Sort, arr)
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.SliceStable(arr, less)
+
+	total := len(arr)
+	if q.Offset >= total {
+		return []SearchResult{}, total, nil
+	}
+	arr = arr[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(arr) {
+		arr = arr[:q.Limit]
+	}
+	return arr, total, nil
+}
+
+// searchResult fills in the metrics that results are sorted by, the same way Postgres' Search does.
+func (m *Memory) searchResult(pkg *memoryPackage, rank float64) SearchResult {
+	result := SearchResult{ID: pkg.ID, Name: pkg.Name, Registry: m.registryName(pkg.RegistryID), Rank: rank}
+	var latest *Snapshot
+	for _, ver := range m.versions {
+		if ver.PackageID != pkg.ID {
+			continue
+		}
+		if seen := m.versionSeen[ver.ID]; seen.After(result.UpdatedAt) {
+			result.UpdatedAt = seen
+		}
+		for i, s := range m.snapshots {
+			if s.VersionID == ver.ID && !s.Flagged() && (latest == nil || s.ValidTo.After(latest.ValidTo)) {
+				latest = &m.snapshots[i]
+			}
+		}
+	}
+	if latest != nil {
+		result.Downloads = latest.DownloadsTotal
+		result.Stars = latest.Stars
+	}
+	return result
+}
+
+// searchLess mirrors the ORDER BY clauses Postgres' Search uses for each sort.
+func searchLess(by SearchSort, arr []SearchResult) (func(i, j int) bool, error) {
+	byName := func(i, j int) bool {
+		if arr[i].Name != arr[j].Name {
+			return arr[i].Name < arr[j].Name
+		}
+		return arr[i].ID < arr[j].ID
+	}
+	switch by {
+	case SortRelevance, "":
+		return func(i, j int) bool {
+			if arr[i].Rank != arr[j].Rank {
+				return arr[i].Rank > arr[j].Rank
+			}
+			return byName(i, j)
+		}, nil
+	case SortDownloads:
+		return func(i, j int) bool {
+			if arr[i].Downloads != arr[j].Downloads {
+				return arr[i].Downloads > arr[j].Downloads
+			}
+			return byName(i, j)
+		}, nil
+	case SortStars:
+		return func(i, j int) bool {
+			if arr[i].Stars != arr[j].Stars {
+				return arr[i].Stars > arr[j].Stars
+			}
+			return byName(i, j)
+		}, nil
+	case SortUpdated:
+		return func(i, j int) bool {
+			if !arr[i].UpdatedAt.Equal(arr[j].UpdatedAt) {
+				return arr[i].UpdatedAt.After(arr[j].UpdatedAt)
+			}
+			return byName(i, j)
+		}, nil
+	case SortName:
+		return byName, nil
+	}
+	return nil, fmt.Errorf("unknown sort %q", by)
+}
+
+func (m *Memory) Suggest(_ context.Context, registryID int, query string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	best, bestSim := "", 0.0
+	for _, pkg := range m.packages {
+		if registryID != 0 && pkg.RegistryID != registryID {
+			continue
+		}
+		if strings.EqualFold(pkg.Name, query) {
+			return "", nil
+		}
+		sim := Similarity(pkg.Name, query)
+		if sim >= SuggestThreshold && (sim > bestSim || (sim == bestSim && pkg.Name < best)) {
+			best, bestSim = pkg.Name, sim
+		}
+	}
+	return best, nil
+}
+
+func (m *Memory) Autocomplete(_ context.Context, registryID int, prefix string, limit int) ([]SearchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix = strings.ToLower(prefix)
+	arr := make([]SearchResult, 0, limit)
+	for _, pkg := range m.packages {
+		if (registryID == 0 || pkg.RegistryID == registryID) && strings.HasPrefix(strings.ToLower(pkg.Name), prefix) {
+			result := m.searchResult(pkg, 0)
+			result.UpdatedAt = time.Time{}
+			arr = append(arr, result)
+		}
+	}
+
+	less, _ := searchLess(SortDownloads, arr)
+	sort.SliceStable(arr, less)
+	if limit < len(arr) {
+		arr = arr[:limit]
+	}
+	return arr, nil
+}
+
+func (m *Memory) Top(_ context.Context, q TopQuery) ([]TopResult, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !validTopMetric(q.Metric) {
+		return nil, 0, fmt.Errorf("unknown metric %q", q.Metric)
+	}
+
+	arr := make([]TopResult, 0, 50)
+	var previous []TopResult
+	for _, pkg := range m.packages {
+		if q.RegistryID != 0 && pkg.RegistryID != q.RegistryID {
+			continue
+		}
+		category := strings.ToLower(pkg.category)
+		if want := strings.ToLower(q.Category); want != "" && category != want && !strings.HasPrefix(category, want+"/") {
+			continue
+		}
+		if q.License != "" && !strings.EqualFold(pkg.license, q.License) {
+			continue
+		}
+
+		result := TopResult{ID: pkg.ID, Name: pkg.Name, Registry: m.registryName(pkg.RegistryID)}
+		if q.Metric == TopDependents {
+			result.Value = m.dependents(pkg)
+			arr = append(arr, result)
+			continue
+		}
+		latest := m.snapshotBefore(pkg.ID, time.Time{})
+		if latest == nil {
+			continue
+		}
+		result.Value = topValue(q.Metric, *latest)
+		if before := m.snapshotBefore(pkg.ID, q.Previous); before != nil && !q.Previous.IsZero() {
+			result.PreviousValue = topValue(q.Metric, *before)
+			previous = append(previous, result)
+		}
+		arr = append(arr, result)
+	}
+
+	// Mirrors RANK() in Postgres: ties share a rank, and the ranks after them are skipped.
+	rank := func(arr []TopResult, value func(TopResult) int64) map[int]int {
+		sort.SliceStable(arr, func(i, j int) bool {
+			if value(arr[i]) != value(arr[j]) {
+				return value(arr[i]) > value(arr[j])
+			}
+			if arr[i].Name != arr[j].Name {
+				return arr[i].Name < arr[j].Name
+			}
+			return arr[i].ID < arr[j].ID
+		})
+		ranks := make(map[int]int, len(arr))
+		for i, r := range arr {
+			ranks[r.ID] = i + 1
+			if i > 0 && value(arr[i-1]) == value(r) {
+				ranks[r.ID] = ranks[arr[i-1].ID]
+			}
+		}
+		return ranks
+	}
+	previousRanks := rank(previous, func(r TopResult) int64 { return r.PreviousValue })
+	ranks := rank(arr, func(r TopResult) int64 { return r.Value })
+	for i := range arr {
+		arr[i].Rank = ranks[arr[i].ID]
+		arr[i].PreviousRank = previousRanks[arr[i].ID]
+	}
+
+	total := len(arr)
+	if q.Offset >= total {
+		return []TopResult{}, total, nil
+	}
+	arr = arr[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(arr) {
+		arr = arr[:q.Limit]
+	}
+	return arr, total, nil
+}
+
+// snapshotBefore returns the package's most recent unflagged snapshot taken at or before the given time, or at any
+// time if it's zero.
+func (m *Memory) snapshotBefore(packageID int, at time.Time) *Snapshot {
+	var found *Snapshot
+	for i, s := range m.snapshots {
+		if s.Flagged() || (!at.IsZero() && s.Time.After(at)) || m.versionPackage(s.VersionID) != packageID {
+			continue
+		}
+		if found == nil || s.Time.After(found.Time) {
+			found = &m.snapshots[i]
+		}
+	}
+	return found
+}
+
+// dependents counts the other packages in the same registry that depend on the package.
+func (m *Memory) dependents(pkg *memoryPackage) int64 {
+	var n int64
+	for _, other := range m.packages {
+		if other.RegistryID != pkg.RegistryID || other.ID == pkg.ID {
+			continue
+		}
+		for _, dep := range other.dependencies {
+			if dep == pkg.Name {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+func topValue(metric TopMetric, s Snapshot) int64 {
+	switch metric {
+	case TopDownloadsMonthly:
+		return int64(s.DownloadsMonthly)
+	case TopDownloadsWeekly:
+		return int64(s.DownloadsWeekly)
+	case TopStars:
+		return int64(s.Stars)
+	case TopForks:
+		return int64(s.Forks)
+	}
+	return s.DownloadsTotal
+}
+
+func (pkg *memoryPackage) matches(f SearchFilter) bool {
+	values := []string{pkg.Name}
+	switch f.Field {
+	case "author":
+		values = pkg.authors
+	case "license":
+		values = []string{pkg.license}
+	}
+	want := strings.ToLower(f.Value)
+	for _, v := range values {
+		v = strings.ToLower(v)
+		if v == want || (f.Prefix && strings.HasPrefix(v, want)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) findPackage(registryID int, name string) *memoryPackage {
+	for _, pkg := range m.packages {
+		if pkg.RegistryID == registryID && pkg.Name == name {
+			return pkg
+		}
+	}
+	return nil
+}
+
+func (m *Memory) packageByID(id int) *memoryPackage {
+	if id < 1 || id > len(m.packages) {
+		return nil
+	}
+	return m.packages[id-1]
+}
+
+func (m *Memory) registryName(id int) string {
+	if id < 1 || id > len(m.registries) {
+		return ""
+	}
+	return m.registries[id-1].Name
+}
+
+func (m *Memory) versionPackage(versionID int) int {
+	if versionID < 1 || versionID > len(m.versions) {
+		return 0
+	}
+	return m.versions[versionID-1].PackageID
+}
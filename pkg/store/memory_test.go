@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatestVersion(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name               string
+		versions           []string
+		stable, prerelease string
+		// latestPrerelease is empty if there's no prerelease.
+		latestPrerelease string
+	}{
+		{"branches only", []string{"~master", "~stable"}, "~stable", "~stable", ""},
+		{"prerelease only", []string{"~master", "0.1.0-beta", "0.1.0-alpha"}, "0.1.0-beta", "0.1.0-beta", "0.1.0-beta"},
+		{"backported patch", []string{"1.0.0", "2.0.0", "1.0.1"}, "2.0.0", "2.0.0", ""},
+		{"newer prerelease", []string{"1.9.0", "2.0.0-rc.2", "2.0.0-rc.10", "~master"}, "1.9.0", "2.0.0-rc.10", "2.0.0-rc.10"},
+		{"released prerelease", []string{"2.0.0-rc.1", "2.0.0"}, "2.0.0", "2.0.0", "2.0.0-rc.1"},
+		{"stable and newer prerelease", []string{"2.1.0-rc.1", "2.0.0", "1.0.0-beta"}, "2.0.0", "2.1.0-rc.1", "2.1.0-rc.1"},
+	}
+	for _, test := range tests {
+		mem := NewMemory()
+		for _, v := range test.versions {
+			_, err := mem.EnsureVersion(ctx, 1, v)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+
+		stable, _ := mem.LatestVersion(ctx, 1, false)
+		prerelease, _ := mem.LatestVersion(ctx, 1, true)
+		if stable.Semver != test.stable || prerelease.Semver != test.prerelease {
+			t.Errorf("%s: expected %s and %s, got %s and %s", test.name, test.stable, test.prerelease, stable.Semver, prerelease.Semver)
+		}
+		latest, err := mem.LatestPrerelease(ctx, 1)
+		if latest.Semver != test.latestPrerelease || (test.latestPrerelease == "" && err != ErrNotFound) {
+			t.Errorf("%s: expected the latest prerelease to be %q, got %q %v", test.name, test.latestPrerelease, latest.Semver, err)
+		}
+	}
+
+	mem := NewMemory()
+	if _, err := mem.LatestVersion(ctx, 1, false); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a package without versions, got %v", err)
+	}
+	if _, err := mem.EnsureVersion(ctx, 1, "latest"); err == nil {
+		t.Error("expected an invalid version to be refused")
+	}
+}
+
+func TestRollup(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	day := time.Date(2021, 10, 13, 0, 0, 0, 0, time.UTC) // A Wednesday.
+
+	mem.AddSnapshot(ctx, Snapshot{VersionID: 1, Time: day.Add(time.Hour), DownloadsTotal: 1})
+	mem.AddSnapshot(ctx, Snapshot{VersionID: 1, Time: day.Add(time.Hour * 5), DownloadsTotal: 2})
+	mem.AddSnapshot(ctx, Snapshot{VersionID: 1, Time: day.Add(time.Hour * 6), DownloadsTotal: 0, FlagReason: "went backwards"})
+	mem.AddSnapshot(ctx, Snapshot{VersionID: 1, Time: day.Add(time.Hour * 30), DownloadsTotal: 3})
+
+	written, err := mem.Rollup(ctx, Daily)
+	if err != nil || written != 2 {
+		t.Fatalf("expected 2 daily buckets, got %d (%v)", written, err)
+	}
+	daily, _ := mem.Snapshots(ctx, SnapshotQuery{VersionID: 1, Since: day.Add(time.Hour * 12), Resolution: Daily})
+	if len(daily) != 2 || !daily[0].Time.Equal(day) || daily[0].DownloadsTotal != 2 || daily[0].Samples != 2 || daily[1].DownloadsTotal != 3 {
+		t.Errorf("expected the last unflagged snapshot of each day, got %+v", daily)
+	}
+
+	// Only the latest bucket onwards is recalculated.
+	mem.AddSnapshot(ctx, Snapshot{VersionID: 1, Time: day.Add(time.Hour * 31), DownloadsTotal: 4})
+	written, _ = mem.Rollup(ctx, Daily)
+	daily, _ = mem.Snapshots(ctx, SnapshotQuery{VersionID: 1, Resolution: Daily})
+	if written != 1 || len(daily) != 2 || daily[1].DownloadsTotal != 4 || daily[1].Samples != 2 {
+		t.Errorf("expected the second day to be updated, got %d written and %+v", written, daily)
+	}
+
+	mem.Rollup(ctx, Weekly)
+	weekly, _ := mem.Snapshots(ctx, SnapshotQuery{VersionID: 1, Resolution: Weekly})
+	if len(weekly) != 1 || weekly[0].Time.Weekday() != time.Monday || weekly[0].Samples != 4 || weekly[0].DownloadsTotal != 4 {
+		t.Errorf("expected a single week starting on Monday, got %+v", weekly)
+	}
+
+	pruned, _ := mem.PruneSnapshots(ctx, day.Add(time.Hour*24))
+	raw, _ := mem.Snapshots(ctx, SnapshotQuery{VersionID: 1, IncludeFlagged: true})
+	if pruned != 3 || len(raw) != 2 {
+		t.Errorf("expected the first day's snapshots to be pruned, got %d pruned and %+v left", pruned, raw)
+	}
+	daily, _ = mem.Snapshots(ctx, SnapshotQuery{VersionID: 1, Resolution: Daily})
+	if len(daily) != 2 {
+		t.Errorf("expected rollups to survive pruning, got %+v", daily)
+	}
+}
+
+func TestExtendSnapshot(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	week := time.Date(2021, 10, 11, 12, 0, 0, 0, time.UTC) // A Monday.
+
+	mem.AddSnapshot(ctx, Snapshot{VersionID: 1, Time: week, DownloadsTotal: 1})
+	for i := 1; i <= 3; i++ {
+		err := mem.ExtendSnapshot(ctx, 1, week.Add(UpdateInterval*time.Duration(i)+time.Minute*time.Duration(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mem.AddSnapshot(ctx, Snapshot{VersionID: 1, Time: week.Add(UpdateInterval * 4), DownloadsTotal: 2})
+	if err := mem.ExtendSnapshot(ctx, 3, week); err != ErrNotFound {
+		t.Errorf("expected extending a missing snapshot to fail, got %v", err)
+	}
+
+	// The extended snapshot is spread back out over each update it covered.
+	raw, _ := mem.Snapshots(ctx, SnapshotQuery{VersionID: 1, Since: week.Add(time.Hour)})
+	if len(raw) != 4 || raw[0].DownloadsTotal != 1 || raw[2].DownloadsTotal != 1 || raw[3].DownloadsTotal != 2 {
+		t.Fatalf("expected the 3 later points of the extended snapshot and the new one, got %+v", raw)
+	}
+	if !raw[2].Time.Equal(week.Add(UpdateInterval*3 + time.Minute*3)) {
+		t.Errorf("expected the series to end when the snapshot was last seen, got %s", raw[2].Time)
+	}
+
+	// Each week the snapshot was valid during gets a bucket.
+	written, _ := mem.Rollup(ctx, Weekly)
+	weekly, _ := mem.Snapshots(ctx, SnapshotQuery{VersionID: 1, Resolution: Weekly})
+	if written != 5 || len(weekly) != 5 || weekly[3].DownloadsTotal != 1 || weekly[4].DownloadsTotal != 2 {
+		t.Errorf("expected 5 weekly buckets, got %d written and %+v", written, weekly)
+	}
+
+	// A snapshot is only pruned once it stopped being valid.
+	pruned, _ := mem.PruneSnapshots(ctx, week.Add(UpdateInterval*2))
+	if pruned != 0 {
+		t.Errorf("expected nothing to be pruned, got %d", pruned)
+	}
+}
+
+func TestSpreadDownloads(t *testing.T) {
+	day := time.Date(2021, 10, 13, 0, 0, 0, 0, time.UTC)
+
+	// 48 downloads over 48 hours, starting at noon, touch three days.
+	days := SpreadDownloads(day.Add(time.Hour*12), day.Add(time.Hour*60), 48)
+	if len(days) != 3 || days[0].Downloads != 12 || days[1].Downloads != 24 || days[2].Downloads != 12 || days[1].Coverage != 1 || days[2].Coverage != 0.5 {
+		t.Fatalf("expected the downloads to be spread by the time spent in each day, got %+v", days)
+	}
+	if perDay, ok := DownloadsPerDay(days, day, day.Add(time.Hour*72)); !ok || perDay != 24 {
+		t.Errorf("expected 24 downloads per day, got %f", perDay)
+	}
+	if _, ok := DownloadsPerDay(days, day.Add(time.Hour*72), day.Add(time.Hour*96)); ok {
+		t.Errorf("expected days without any coverage to have no rate")
+	}
+
+	if days := SpreadDownloads(day, day.Add(time.Hour), -5); len(days) != 1 || days[0].Downloads != 0 {
+		t.Errorf("expected a total that went backwards to count as no downloads, got %+v", days)
+	}
+
+	mem := NewMemory()
+	ctx := context.Background()
+	mem.AddDailyDownloads(ctx, 1, SpreadDownloads(day, day.Add(time.Hour*12), 6))
+	mem.AddDailyDownloads(ctx, 1, SpreadDownloads(day.Add(time.Hour*12), day.Add(time.Hour*36), 24))
+	stored, _ := mem.DailyDownloads(ctx, 1, day.Add(time.Hour))
+	if len(stored) != 2 || stored[0].Downloads != 18 || stored[0].Coverage != 1 || stored[1].Downloads != 12 {
+		t.Errorf("expected the downloads of each day to be added together, got %+v", stored)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	day := time.Date(2021, 10, 13, 0, 0, 0, 0, time.UTC)
+	arr := []Snapshot{
+		{Time: day.Add(time.Hour), Stars: 3, DownloadsTotal: 10},
+		{Time: day.Add(time.Hour * 2), Stars: 5, DownloadsTotal: 20, Samples: 2},
+		{Time: day.Add(time.Hour * 3), Stars: 4, DownloadsTotal: 30},
+		{Time: day.Add(time.Hour * 25), Stars: 6, DownloadsTotal: 40},
+	}
+	for by, want := range map[Aggregation][2]int{AggregateLast: {4, 30}, AggregateMax: {5, 30}, AggregateAvg: {4, 20}} {
+		got := Aggregate(arr, Daily, by)
+		if len(got) != 2 || !got[0].Time.Equal(day) || got[0].Stars != want[0] || got[0].DownloadsTotal != int64(want[1]) || got[0].Samples != 4 {
+			t.Errorf("%s: expected %d stars and %d downloads from 4 samples on the first day, got %+v", by, want[0], want[1], got)
+		}
+		if got[1].Stars != 6 || got[1].Samples != 1 {
+			t.Errorf("%s: expected the second day to stand alone, got %+v", by, got[1])
+		}
+	}
+}
@@ -0,0 +1,915 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BradleyChatha/ystadegau/pkg/db"
+	"github.com/BradleyChatha/ystadegau/pkg/semver"
+	"github.com/lib/pq"
+)
+
+type Postgres struct {
+	pool *db.Pool
+}
+
+func NewPostgres(pool *db.Pool) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+func (p *Postgres) EnsureRegistry(ctx context.Context, name string, url string) (Registry, error) {
+	reg := Registry{Name: name, URL: url}
+	err := p.queryRow(ctx, `
+		INSERT INTO registry(name, url) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET url = EXCLUDED.url
+		RETURNING id`, name, url).Scan(&reg.ID)
+	return reg, p.check(err)
+}
+
+func (p *Postgres) RegistryByName(ctx context.Context, name string) (Registry, error) {
+	var reg Registry
+	err := p.queryRow(ctx, "SELECT id, name, url FROM registry WHERE name = $1", name).Scan(&reg.ID, &reg.Name, &reg.URL)
+	return reg, p.check(err)
+}
+
+func (p *Postgres) AddPackage(ctx context.Context, registryID int, name string) (bool, error) {
+	res, err := p.exec(ctx, `
+		INSERT INTO package(registry_id, name, next_update) VALUES ($1, $2, now())
+		ON CONFLICT (registry_id, name) DO UPDATE SET removed_at = NULL, next_update = now()
+		WHERE package.removed_at IS NOT NULL`, registryID, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (p *Postgres) MarkRemoved(ctx context.Context, packageID int) (bool, error) {
+	res, err := p.exec(ctx, "UPDATE package SET removed_at = now() WHERE id = $1 AND removed_at IS NULL", packageID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (p *Postgres) PackageByName(ctx context.Context, registryID int, name string) (Package, error) {
+	var pkg Package
+	var nextUpdate sql.NullTime
+	err := p.queryRow(ctx, "SELECT id, registry_id, name, next_update, validation_failures FROM package WHERE registry_id = $1 AND name = $2", registryID, name).
+		Scan(&pkg.ID, &pkg.RegistryID, &pkg.Name, &nextUpdate, &pkg.ValidationFailures)
+	pkg.NextUpdate = nextUpdate.Time
+	return pkg, p.check(err)
+}
+
+func (p *Postgres) PackagesDue(ctx context.Context) ([]Package, error) {
+	rows, err := p.query(ctx, "SELECT id, registry_id, name, next_update, validation_failures FROM package WHERE next_update < now() AND removed_at IS NULL;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pkgs []Package
+	for rows.Next() {
+		var pkg Package
+		err = rows.Scan(&pkg.ID, &pkg.RegistryID, &pkg.Name, &pkg.NextUpdate, &pkg.ValidationFailures)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, rows.Err()
+}
+
+func (p *Postgres) CountOverdue(ctx context.Context) (int, error) {
+	var count int
+	err := p.queryRow(ctx, "SELECT COUNT(*) FROM package WHERE next_update < now() AND removed_at IS NULL;").Scan(&count)
+	return count, p.check(err)
+}
+
+func (p *Postgres) UpdateSearchText(ctx context.Context, packageID int, description string, readme string) error {
+	_, err := p.exec(ctx, "SELECT * FROM update_package_query_vector($1, $2, $3);", packageID, description, readme)
+	return err
+}
+
+func (p *Postgres) SetReadme(ctx context.Context, r Readme) (bool, error) {
+	res, err := p.exec(ctx, `
+		INSERT INTO package_version_readme(package_version_id, description, readme, hash, updated_at) VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (package_version_id) DO UPDATE
+		SET description = EXCLUDED.description, readme = EXCLUDED.readme, hash = EXCLUDED.hash, updated_at = now()
+		WHERE package_version_readme.hash <> EXCLUDED.hash`,
+		r.VersionID, r.Description, r.Readme, ReadmeHash(r.Description, r.Readme))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (p *Postgres) Readme(ctx context.Context, versionID int) (Readme, error) {
+	r := Readme{VersionID: versionID}
+	err := p.queryRow(ctx, "SELECT description, readme, hash, updated_at FROM package_version_readme WHERE package_version_id = $1", versionID).
+		Scan(&r.Description, &r.Readme, &r.Hash, &r.UpdatedAt)
+	return r, p.check(err)
+}
+
+func (p *Postgres) SetMetadata(ctx context.Context, packageID int, authors []string, license string) error {
+	if authors == nil {
+		authors = []string{}
+	}
+	_, err := p.exec(ctx, "UPDATE package SET authors = $2, license = $3 WHERE id = $1", packageID, pq.Array(authors), license)
+	return err
+}
+
+func (p *Postgres) SetCategory(ctx context.Context, registryID int, name string, category string) error {
+	res, err := p.exec(ctx, "UPDATE package SET category = $3 WHERE registry_id = $1 AND name = $2", registryID, name, category)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (p *Postgres) RecordValidationFailure(ctx context.Context, packageID int, rules string) (int, error) {
+	var count int
+	err := p.queryRow(ctx, `
+		UPDATE package
+		SET validation_failures = CASE WHEN $2 = '' THEN 0 WHEN validation_failed_rules = $2 THEN validation_failures + 1 ELSE 1 END,
+			validation_failed_rules = $2
+		WHERE id = $1
+		RETURNING validation_failures;`, packageID, rules).Scan(&count)
+	return count, p.check(err)
+}
+
+func (p *Postgres) SetDependencies(ctx context.Context, packageID int, names []string) error {
+	if names == nil {
+		names = []string{}
+	}
+	_, err := p.exec(ctx, "UPDATE package SET dependencies = $2 WHERE id = $1", packageID, pq.Array(names))
+	return err
+}
+
+func (p *Postgres) BumpUpdateTime(ctx context.Context, packageID int) error {
+	_, err := p.exec(ctx, "SELECT bump_package_update_time($1);", packageID)
+	return err
+}
+
+func (p *Postgres) EnsureVersion(ctx context.Context, packageID int, semverStr string) (Version, error) {
+	ver, err := p.FindVersion(ctx, packageID, semverStr)
+	if err != ErrNotFound {
+		return ver, err
+	}
+
+	parsed, err := semver.Parse(semverStr)
+	if err != nil {
+		return ver, err
+	}
+
+	// Branches leave every component NULL.
+	var major, minor, patch sql.NullInt32
+	var prerelease, build sql.NullString
+	if !parsed.IsBranch() {
+		major = sql.NullInt32{Int32: int32(parsed.Major), Valid: true}
+		minor = sql.NullInt32{Int32: int32(parsed.Minor), Valid: true}
+		patch = sql.NullInt32{Int32: int32(parsed.Patch), Valid: true}
+		prerelease = sql.NullString{String: parsed.Prerelease, Valid: parsed.IsPrerelease()}
+		build = sql.NullString{String: parsed.Build, Valid: parsed.Build != ""}
+	}
+
+	err = p.queryRow(ctx, `
+		INSERT INTO package_version(package_id, semver, major, minor, patch, prerelease, build, is_branch)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		packageID, semverStr, major, minor, patch, prerelease, build, parsed.IsBranch(),
+	).Scan(&ver.ID)
+	return ver, p.check(err)
+}
+
+func (p *Postgres) FindVersion(ctx context.Context, packageID int, semver string) (Version, error) {
+	ver := Version{PackageID: packageID, Semver: semver}
+	err := p.queryRow(ctx, "SELECT id FROM package_version WHERE package_id = $1 AND semver = $2", packageID, semver).Scan(&ver.ID)
+	return ver, p.check(err)
+}
+
+func (p *Postgres) Versions(ctx context.Context, packageID int) ([]Version, error) {
+	rows, err := p.query(ctx, "SELECT id, semver FROM package_version WHERE package_id = $1 ORDER BY id", packageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	arr := make([]Version, 0, 8)
+	for rows.Next() {
+		ver := Version{PackageID: packageID}
+		err = rows.Scan(&ver.ID, &ver.Semver)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, ver)
+	}
+	return arr, rows.Err()
+}
+
+func (p *Postgres) LatestVersion(ctx context.Context, packageID int, includePrerelease bool) (Version, error) {
+	ver := Version{PackageID: packageID}
+	err := p.queryRow(ctx, `
+		SELECT id, semver
+		FROM package_version
+		WHERE package_id = $1
+		ORDER BY
+			is_branch,
+			CASE WHEN $2::BOOLEAN THEN FALSE ELSE prerelease IS NOT NULL END,
+			major DESC NULLS LAST,
+			minor DESC NULLS LAST,
+			patch DESC NULLS LAST,
+			prerelease IS NULL DESC,
+			semver_prerelease_key(prerelease) COLLATE "C" DESC,
+			id DESC
+		LIMIT 1;`, packageID, includePrerelease).Scan(&ver.ID, &ver.Semver)
+	return ver, p.check(err)
+}
+
+func (p *Postgres) LatestPrerelease(ctx context.Context, packageID int) (Version, error) {
+	ver := Version{PackageID: packageID}
+	err := p.queryRow(ctx, `
+		SELECT id, semver
+		FROM package_version
+		WHERE package_id = $1 AND prerelease IS NOT NULL
+		ORDER BY
+			major DESC,
+			minor DESC,
+			patch DESC,
+			semver_prerelease_key(prerelease) COLLATE "C" DESC,
+			id DESC
+		LIMIT 1;`, packageID).Scan(&ver.ID, &ver.Semver)
+	return ver, p.check(err)
+}
+
+func (p *Postgres) AddSnapshot(ctx context.Context, s Snapshot) error {
+	if s.ValidTo.IsZero() {
+		s.ValidTo = s.Time
+	}
+	_, err := p.exec(ctx,
+		"INSERT INTO package_snapshot(package_version_id, valid_from, valid_to, downloads_weekly, downloads_monthly, downloads_total, stars, watchers, issues, forks, flag_reason, registry_mirror) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+		s.VersionID,
+		s.Time,
+		s.ValidTo,
+		s.DownloadsWeekly,
+		s.DownloadsMonthly,
+		s.DownloadsTotal,
+		s.Stars,
+		s.Watchers,
+		s.Issues,
+		s.Forks,
+		sql.NullString{String: s.FlagReason, Valid: s.Flagged()},
+		sql.NullString{String: s.Mirror, Valid: s.Mirror != ""},
+	)
+	return err
+}
+
+func (p *Postgres) ExtendSnapshot(ctx context.Context, snapshotID int, to time.Time) error {
+	res, err := p.exec(ctx, "UPDATE package_snapshot SET valid_to = GREATEST(valid_to, $2) WHERE id = $1", snapshotID, to)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+const snapshotColumns = "id, package_version_id, valid_from, valid_to, downloads_weekly, downloads_monthly, downloads_total, stars, watchers, issues, forks, flag_reason, registry_mirror"
+
+func (p *Postgres) Snapshots(ctx context.Context, q SnapshotQuery) ([]Snapshot, error) {
+	if q.Resolution != "" && q.Resolution != Raw {
+		return p.rollupSnapshots(ctx, q)
+	}
+
+	versions, id := snapshotVersions(q)
+	rows, err := p.query(ctx, `
+		SELECT `+snapshotColumns+`
+		FROM package_snapshot
+		WHERE `+versions+` AND valid_to >= $2 AND ($3 OR flag_reason IS NULL)
+			AND ($4::timestamptz IS NULL OR valid_from < $4)
+		ORDER BY valid_from;`, id, q.Since, q.IncludeFlagged, nullTime(q.Until))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	arr := make([]Snapshot, 0, 16)
+	for rows.Next() {
+		s, err := scanSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return expandSnapshots(arr, q.Since, q.Until), nil
+}
+
+// snapshotVersions returns the condition that picks the versions asked for by q, and the ID to pass as $1.
+func snapshotVersions(q SnapshotQuery) (string, int) {
+	if q.PackageID != 0 {
+		return "package_version_id IN (SELECT id FROM package_version WHERE package_id = $1)", q.PackageID
+	}
+	return "package_version_id = $1", q.VersionID
+}
+
+// rollupTables maps each resolution to its table and the unit used by date_trunc.
+var rollupTables = map[Resolution]struct{ table, unit string }{
+	Daily:   {"package_snapshot_daily", "day"},
+	Weekly:  {"package_snapshot_weekly", "week"},
+	Monthly: {"package_snapshot_monthly", "month"},
+}
+
+func (p *Postgres) rollupSnapshots(ctx context.Context, q SnapshotQuery) ([]Snapshot, error) {
+	rollup, ok := rollupTables[q.Resolution]
+	if !ok {
+		return nil, fmt.Errorf("unknown resolution %q", q.Resolution)
+	}
+
+	// The window function runs before DISTINCT ON, so the samples of every version in the bucket are added up. Version
+	// IDs are assigned in the order versions are seen, so the highest is the newest.
+	versions, id := snapshotVersions(q)
+	rows, err := p.query(ctx, `
+		SELECT DISTINCT ON (bucket)
+			package_version_id, bucket, SUM(samples) OVER (PARTITION BY bucket), downloads_weekly, downloads_monthly, downloads_total, stars, watchers, issues, forks
+		FROM `+rollup.table+`
+		WHERE `+versions+` AND bucket >= $2 AND ($3::timestamptz IS NULL OR bucket < $3)
+		ORDER BY bucket, package_version_id DESC;`, id, q.Resolution.Truncate(q.Since), nullTime(q.Until))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	arr := make([]Snapshot, 0, 16)
+	for rows.Next() {
+		var s Snapshot
+		err = rows.Scan(&s.VersionID, &s.Time, &s.Samples, &s.DownloadsWeekly, &s.DownloadsMonthly, &s.DownloadsTotal, &s.Stars, &s.Watchers, &s.Issues, &s.Forks)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, s)
+	}
+	return arr, rows.Err()
+}
+
+func (p *Postgres) Rollup(ctx context.Context, resolution Resolution) (int64, error) {
+	rollup, ok := rollupTables[resolution]
+	if !ok {
+		return 0, fmt.Errorf("unknown resolution %q", resolution)
+	}
+
+	// Window functions run before DISTINCT ON, so samples counts every snapshot in the bucket rather than just the
+	// one that's kept. Snapshots are spread over every bucket between their valid_from and valid_to.
+	res, err := p.exec(ctx, `
+		WITH since AS (SELECT COALESCE(MAX(bucket), '-infinity') AS bucket FROM `+rollup.table+`)
+		INSERT INTO `+rollup.table+`(package_version_id, bucket, samples, downloads_weekly, downloads_monthly, downloads_total, stars, watchers, issues, forks)
+		SELECT DISTINCT ON (package_version_id, bucket)
+			package_version_id,
+			bucket,
+			COUNT(*) OVER (PARTITION BY package_version_id, bucket),
+			downloads_weekly,
+			downloads_monthly,
+			downloads_total,
+			stars,
+			watchers,
+			issues,
+			forks
+		FROM (
+			SELECT s.*, b.bucket
+			FROM package_snapshot AS s
+			CROSS JOIN LATERAL (
+				SELECT g AT TIME ZONE 'UTC' AS bucket
+				FROM generate_series(
+					date_trunc('`+rollup.unit+`', s.valid_from AT TIME ZONE 'UTC'),
+					date_trunc('`+rollup.unit+`', s.valid_to AT TIME ZONE 'UTC'),
+					INTERVAL '1 `+rollup.unit+`'
+				) AS g
+			) AS b
+			WHERE s.flag_reason IS NULL AND s.valid_to >= (SELECT bucket FROM since)
+		) AS s
+		WHERE bucket >= (SELECT bucket FROM since)
+		ORDER BY package_version_id, bucket, valid_from DESC
+		ON CONFLICT (package_version_id, bucket) DO UPDATE SET
+			samples = EXCLUDED.samples,
+			downloads_weekly = EXCLUDED.downloads_weekly,
+			downloads_monthly = EXCLUDED.downloads_monthly,
+			downloads_total = EXCLUDED.downloads_total,
+			stars = EXCLUDED.stars,
+			watchers = EXCLUDED.watchers,
+			issues = EXCLUDED.issues,
+			forks = EXCLUDED.forks;`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (p *Postgres) AddDailyDownloads(ctx context.Context, packageID int, days []DailyDownloads) error {
+	tx, err := p.pool.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return p.check(err)
+	}
+	defer tx.Rollback()
+
+	for _, d := range days {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO package_downloads_daily(package_id, day, downloads, coverage) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (package_id, day) DO UPDATE SET
+				downloads = package_downloads_daily.downloads + EXCLUDED.downloads,
+				coverage = package_downloads_daily.coverage + EXCLUDED.coverage;`, packageID, d.Day, d.Downloads, d.Coverage)
+		if err != nil {
+			return p.check(err)
+		}
+	}
+	return p.check(tx.Commit())
+}
+
+func (p *Postgres) DailyDownloads(ctx context.Context, packageID int, since time.Time) ([]DailyDownloads, error) {
+	rows, err := p.query(ctx, `
+		SELECT day, downloads, coverage
+		FROM package_downloads_daily
+		WHERE package_id = $1 AND day >= $2
+		ORDER BY day;`, packageID, Daily.Truncate(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	arr := make([]DailyDownloads, 0, 16)
+	for rows.Next() {
+		var d DailyDownloads
+		err = rows.Scan(&d.Day, &d.Downloads, &d.Coverage)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, d)
+	}
+	return arr, rows.Err()
+}
+
+func (p *Postgres) PruneSnapshots(ctx context.Context, before time.Time) (int64, error) {
+	res, err := p.exec(ctx, "DELETE FROM package_snapshot WHERE valid_to < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (p *Postgres) LastSnapshot(ctx context.Context, packageID int) (Snapshot, error) {
+	row := p.queryRow(ctx, `
+		SELECT `+snapshotColumns+`
+		FROM package_snapshot
+		WHERE package_version_id IN (SELECT id FROM package_version WHERE package_id = $1) AND flag_reason IS NULL
+		ORDER BY valid_from DESC
+		LIMIT 1;`, packageID)
+	s, err := scanSnapshot(row)
+	return s, p.check(err)
+}
+
+func (p *Postgres) SnapshotAt(ctx context.Context, packageID int, at time.Time) (Snapshot, error) {
+	row := p.queryRow(ctx, `
+		SELECT `+snapshotColumns+`
+		FROM package_snapshot
+		WHERE package_version_id IN (SELECT id FROM package_version WHERE package_id = $1) AND flag_reason IS NULL AND valid_from <= $2
+		ORDER BY valid_from DESC
+		LIMIT 1;`, packageID, at)
+	s, err := scanSnapshot(row)
+	return s, p.check(err)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSnapshot(row scanner) (Snapshot, error) {
+	var s Snapshot
+	var flagReason, mirror sql.NullString
+	err := row.Scan(&s.ID, &s.VersionID, &s.Time, &s.ValidTo, &s.DownloadsWeekly, &s.DownloadsMonthly, &s.DownloadsTotal, &s.Stars, &s.Watchers, &s.Issues, &s.Forks, &flagReason, &mirror)
+	s.FlagReason = flagReason.String
+	s.Mirror = mirror.String
+	return s, err
+}
+
+func (p *Postgres) AddWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	err := p.queryRow(ctx,
+		"INSERT INTO webhook(url, secret, events) VALUES ($1, $2, $3) RETURNING id, created_at",
+		w.URL, w.Secret, pq.Array(w.Events),
+	).Scan(&w.ID, &w.CreatedAt)
+	return w, p.check(err)
+}
+
+func (p *Postgres) Webhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := p.query(ctx, "SELECT id, url, secret, events, created_at FROM webhook ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var arr []Webhook
+	for rows.Next() {
+		var w Webhook
+		err = rows.Scan(&w.ID, &w.URL, &w.Secret, pq.Array(&w.Events), &w.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, w)
+	}
+	return arr, rows.Err()
+}
+
+func (p *Postgres) DeleteWebhook(ctx context.Context, id int) error {
+	res, err := p.exec(ctx, "DELETE FROM webhook WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+const alertRuleColumns = "id, name, registry, package, filter, metric, comparison, threshold, window_seconds, webhook_url, email, created_at"
+
+func (p *Postgres) AddAlertRule(ctx context.Context, r AlertRule) (AlertRule, error) {
+	err := p.queryRow(ctx, `
+		INSERT INTO alert_rule(name, registry, package, filter, metric, comparison, threshold, window_seconds, webhook_url, email)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at`,
+		r.Name, r.Registry, r.Package, r.Filter, r.Metric, r.Comparison, r.Threshold, int64(r.Window.Seconds()), r.WebhookURL, r.Email,
+	).Scan(&r.ID, &r.CreatedAt)
+	return r, p.check(err)
+}
+
+func (p *Postgres) AlertRules(ctx context.Context) ([]AlertRule, error) {
+	rows, err := p.query(ctx, "SELECT "+alertRuleColumns+" FROM alert_rule ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var arr []AlertRule
+	for rows.Next() {
+		var r AlertRule
+		var window int64
+		err = rows.Scan(&r.ID, &r.Name, &r.Registry, &r.Package, &r.Filter, &r.Metric, &r.Comparison, &r.Threshold, &window, &r.WebhookURL, &r.Email, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		r.Window = time.Duration(window) * time.Second
+		arr = append(arr, r)
+	}
+	return arr, rows.Err()
+}
+
+func (p *Postgres) DeleteAlertRule(ctx context.Context, id int) error {
+	res, err := p.exec(ctx, "DELETE FROM alert_rule WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+func (p *Postgres) AlertState(ctx context.Context, ruleID int, packageID int) (AlertState, error) {
+	state := AlertState{RuleID: ruleID, PackageID: packageID}
+	err := p.queryRow(ctx, "SELECT firing, since, value FROM alert_state WHERE alert_rule_id = $1 AND package_id = $2", ruleID, packageID).
+		Scan(&state.Firing, &state.Since, &state.Value)
+	return state, p.check(err)
+}
+
+func (p *Postgres) SetAlertState(ctx context.Context, state AlertState) error {
+	_, err := p.exec(ctx, `
+		INSERT INTO alert_state(alert_rule_id, package_id, firing, since, value) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (alert_rule_id, package_id) DO UPDATE SET
+			firing = EXCLUDED.firing,
+			since = EXCLUDED.since,
+			value = EXCLUDED.value;`, state.RuleID, state.PackageID, state.Firing, state.Since, state.Value)
+	return err
+}
+
+func (p *Postgres) FiringAlerts(ctx context.Context) ([]AlertState, error) {
+	rows, err := p.query(ctx, `
+		SELECT s.alert_rule_id, s.package_id, p.name, s.since, s.value
+		FROM alert_state s
+		JOIN package p ON p.id = s.package_id
+		WHERE s.firing
+		ORDER BY s.since;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var arr []AlertState
+	for rows.Next() {
+		state := AlertState{Firing: true}
+		err = rows.Scan(&state.RuleID, &state.PackageID, &state.Package, &state.Since, &state.Value)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, state)
+	}
+	return arr, rows.Err()
+}
+
+// searchOrder maps each sort onto an ORDER BY clause for the query in Search.
+var searchOrder = map[SearchSort]string{
+	SortRelevance: "m.rank DESC, p.name, p.id",
+	SortDownloads: "l.downloads_total DESC NULLS LAST, p.name, p.id",
+	SortStars:     "l.stars DESC NULLS LAST, p.name, p.id",
+	SortUpdated:   "v.updated_at DESC NULLS LAST, p.name, p.id",
+	SortName:      "p.name, p.id",
+}
+
+// searchFilterSQL holds the condition each filter field adds to Search, where %s is the value's placeholder.
+var searchFilterSQL = map[string]map[bool]string{
+	"name": {
+		false: "lower(p.name) = lower(%s)",
+		true:  "lower(p.name) LIKE lower(%s) || '%%'",
+	},
+	"author": {
+		false: "EXISTS (SELECT 1 FROM unnest(p.authors) AS a WHERE lower(a) = lower(%s))",
+		true:  "EXISTS (SELECT 1 FROM unnest(p.authors) AS a WHERE lower(a) LIKE lower(%s) || '%%')",
+	},
+	"license": {
+		false: "lower(p.license) = lower(%s)",
+		true:  "lower(p.license) LIKE lower(%s) || '%%'",
+	},
+}
+
+func (p *Postgres) Search(ctx context.Context, q SearchQuery) ([]SearchResult, int, error) {
+	order, ok := searchOrder[q.Sort]
+	if q.Sort == "" {
+		order, ok = searchOrder[SortRelevance], true
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	// Terms are only ever turned into tsquery syntax by TSQuery, which quotes every word, and filter values are only
+	// ever passed as parameters.
+	include, exclude := TSQuery(q.Terms)
+	args := []interface{}{q.Query, include, exclude, q.RegistryID}
+	matches := `
+		SELECT s.id, s.rank
+		FROM search_packages($1, $2, $3) AS s
+		JOIN package p ON p.id = s.id
+		WHERE ($4 = 0 OR p.registry_id = $4)`
+	for _, f := range q.Filters {
+		cond, ok := searchFilterSQL[f.Field][f.Prefix]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown filter %q", f.Field)
+		}
+		value := f.Value
+		if f.Prefix {
+			value = escapeLike(value)
+		}
+		args = append(args, value)
+		cond = fmt.Sprintf(cond, fmt.Sprintf("$%d", len(args)))
+		if f.Negate {
+			cond = "NOT COALESCE(" + cond + ", false)"
+		}
+		matches += "\n\t\tAND " + cond
+	}
+
+	var limit sql.NullInt64
+	if q.Limit > 0 {
+		limit = sql.NullInt64{Int64: int64(q.Limit), Valid: true}
+	}
+	rows, err := p.query(ctx, `
+		WITH matches AS (`+matches+`
+		)
+		SELECT p.id, p.name, r.name, m.rank, COALESCE(l.downloads_total, 0), COALESCE(l.stars, 0), v.updated_at, COUNT(*) OVER ()
+		FROM matches m
+		JOIN package p ON p.id = m.id
+		JOIN registry r ON r.id = p.registry_id
+		LEFT JOIN LATERAL (
+			SELECT s.downloads_total, s.stars
+			FROM package_snapshot s
+			JOIN package_version pv ON pv.id = s.package_version_id
+			WHERE pv.package_id = p.id AND s.flag_reason IS NULL
+			ORDER BY s.valid_to DESC
+			LIMIT 1
+		) l ON true
+		LEFT JOIN LATERAL (
+			SELECT MAX(created_at) AS updated_at FROM package_version WHERE package_id = p.id
+		) v ON true
+		ORDER BY `+order+fmt.Sprintf(`
+		LIMIT $%d OFFSET $%d;`, len(args)+1, len(args)+2), append(args, limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	arr := make([]SearchResult, 0, 50)
+	total := 0
+	for rows.Next() {
+		var value SearchResult
+		var updatedAt sql.NullTime
+		err = rows.Scan(&value.ID, &value.Name, &value.Registry, &value.Rank, &value.Downloads, &value.Stars, &updatedAt, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		value.UpdatedAt = updatedAt.Time
+		arr = append(arr, value)
+	}
+	if err = rows.Err(); err != nil || len(arr) > 0 || q.Offset == 0 {
+		return arr, total, err
+	}
+
+	// Pages past the end don't have any rows to carry the total.
+	err = p.queryRow(ctx, "SELECT COUNT(*) FROM ("+matches+") AS m;", args...).Scan(&total)
+	return arr, total, p.check(err)
+}
+
+func (p *Postgres) Suggest(ctx context.Context, registryID int, query string) (string, error) {
+	var name string
+	err := p.queryRow(ctx, `
+		SELECT name
+		FROM package
+		WHERE ($2 = 0 OR registry_id = $2)
+			AND name % $1
+			AND similarity(name, $1) >= $3
+			AND NOT EXISTS (SELECT 1 FROM package WHERE lower(name) = lower($1) AND ($2 = 0 OR registry_id = $2))
+		ORDER BY similarity(name, $1) DESC, name
+		LIMIT 1;`, query, registryID, SuggestThreshold).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return name, err
+}
+
+func (p *Postgres) Autocomplete(ctx context.Context, registryID int, prefix string, limit int) ([]SearchResult, error) {
+	// lower(name) text_pattern_ops is indexed, so the prefix match doesn't need to scan every package.
+	rows, err := p.query(ctx, `
+		SELECT p.id, p.name, r.name, COALESCE(l.downloads_total, 0), COALESCE(l.stars, 0)
+		FROM package p
+		JOIN registry r ON r.id = p.registry_id
+		LEFT JOIN LATERAL (
+			SELECT s.downloads_total, s.stars
+			FROM package_snapshot s
+			JOIN package_version pv ON pv.id = s.package_version_id
+			WHERE pv.package_id = p.id AND s.flag_reason IS NULL
+			ORDER BY s.valid_to DESC
+			LIMIT 1
+		) l ON true
+		WHERE lower(p.name) LIKE lower($1) || '%' AND ($2 = 0 OR p.registry_id = $2)
+		ORDER BY `+searchOrder[SortDownloads]+`
+		LIMIT $3;`, escapeLike(prefix), registryID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	arr := make([]SearchResult, 0, limit)
+	for rows.Next() {
+		var value SearchResult
+		err = rows.Scan(&value.ID, &value.Name, &value.Registry, &value.Downloads, &value.Stars)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, value)
+	}
+	return arr, rows.Err()
+}
+
+// topColumns maps each snapshot metric onto its column in package_snapshot.
+var topColumns = map[TopMetric]string{
+	TopDownloadsTotal:   "downloads_total",
+	TopDownloadsMonthly: "downloads_monthly",
+	TopDownloadsWeekly:  "downloads_weekly",
+	TopStars:            "stars",
+	TopForks:            "forks",
+}
+
+func (p *Postgres) Top(ctx context.Context, q TopQuery) ([]TopResult, int, error) {
+	if !validTopMetric(q.Metric) {
+		return nil, 0, fmt.Errorf("unknown metric %q", q.Metric)
+	}
+
+	// $4 is when the previous ranking is taken, or NULL to skip it. A snapshot's metrics hold from its valid_from.
+	valueAt := func(at string) string {
+		return `
+			SELECT s.` + topColumns[q.Metric] + `::bigint AS value
+			FROM package_snapshot s
+			JOIN package_version pv ON pv.id = s.package_version_id
+			WHERE pv.package_id = p.id AND s.flag_reason IS NULL AND (` + at + ` IS NULL OR s.valid_from <= ` + at + `)
+			ORDER BY s.valid_from DESC
+			LIMIT 1`
+	}
+	current, previous := valueAt("NULL::timestamptz"), valueAt("$4::timestamptz")
+	if q.Metric == TopDependents {
+		current = `
+			SELECT COUNT(*)::bigint AS value
+			FROM package d
+			WHERE d.registry_id = p.registry_id AND d.id <> p.id AND p.name = ANY(d.dependencies)`
+		// Nothing records who depended on a package in the past, so there's no previous ranking. $4 is still
+		// referenced so that its type is known.
+		previous = "SELECT NULL::bigint AS value, $4::timestamptz AS previous"
+	}
+
+	var limit sql.NullInt64
+	if q.Limit > 0 {
+		limit = sql.NullInt64{Int64: int64(q.Limit), Valid: true}
+	}
+	category := strings.ToLower(q.Category)
+	rows, err := p.query(ctx, `
+		WITH ranked AS (
+			SELECT
+				p.id, p.name, r.name AS registry, cur.value,
+				RANK() OVER (ORDER BY cur.value DESC) AS rank,
+				prev.value AS previous_value,
+				RANK() OVER (ORDER BY prev.value DESC NULLS LAST) AS previous_rank
+			FROM package p
+			JOIN registry r ON r.id = p.registry_id
+			LEFT JOIN LATERAL (`+current+`
+			) cur ON true
+			LEFT JOIN LATERAL (`+previous+`
+			) prev ON true
+			WHERE ($1 = 0 OR p.registry_id = $1)
+				AND ($2 = '' OR lower(p.category) = $2 OR lower(p.category) LIKE $3::text || '/%')
+				AND ($5 = '' OR lower(p.license) = lower($5))
+				AND cur.value IS NOT NULL
+		)
+		SELECT id, name, registry, rank, value, COALESCE(previous_value, 0),
+			CASE WHEN previous_value IS NULL THEN 0 ELSE previous_rank END, COUNT(*) OVER ()
+		FROM ranked
+		ORDER BY rank, name, id
+		LIMIT $6 OFFSET $7;`, q.RegistryID, category, escapeLike(category), nullTime(q.Previous), q.License, limit, q.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	arr := make([]TopResult, 0, 50)
+	total := 0
+	for rows.Next() {
+		var value TopResult
+		err = rows.Scan(&value.ID, &value.Name, &value.Registry, &value.Rank, &value.Value, &value.PreviousValue, &value.PreviousRank, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		arr = append(arr, value)
+	}
+	if err = rows.Err(); err != nil || len(arr) > 0 || q.Offset == 0 {
+		return arr, total, err
+	}
+
+	// Pages past the end don't have any rows to carry the total, so the query is run again without paging.
+	err = p.queryRow(ctx, `
+		SELECT COUNT(*)
+		FROM package p
+		WHERE ($1 = 0 OR p.registry_id = $1)
+			AND ($2 = '' OR lower(p.category) = $2 OR lower(p.category) LIKE $3::text || '/%')
+			AND ($4 = '' OR lower(p.license) = lower($4))
+			AND EXISTS (`+current+`
+			);`, q.RegistryID, category, escapeLike(category), q.License).Scan(&total)
+	return arr, total, p.check(err)
+}
+
+func (p *Postgres) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := p.pool.DB().QueryContext(ctx, query, args...)
+	return rows, p.check(err)
+}
+
+func (p *Postgres) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.pool.DB().QueryRowContext(ctx, query, args...)
+}
+
+func (p *Postgres) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	res, err := p.pool.DB().ExecContext(ctx, query, args...)
+	return res, p.check(err)
+}
+
+// nullTime passes the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// check turns sql.ErrNoRows into ErrNotFound, and lets the pool know about any errors so it can react to rotated credentials.
+func (p *Postgres) check(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	p.pool.Check(err)
+	return err
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TSQuery turns search terms into two tsquery expressions, suitable for to_tsquery: one that packages must match, and
+// one that they mustn't. Either is empty when there are no such terms. Every word is quoted, so any text is safe.
+func TSQuery(terms []SearchTerm) (include string, exclude string) {
+	var includes, excludes []string
+	for _, t := range terms {
+		if len(t.Words) == 0 {
+			continue
+		}
+		words := make([]string, 0, len(t.Words))
+		for _, w := range t.Words {
+			words = append(words, quoteLexeme(w))
+		}
+		expr := strings.Join(words, " <-> ")
+		if t.Prefix {
+			expr += ":*"
+		}
+		if len(words) > 1 {
+			expr = "(" + expr + ")"
+		}
+
+		if t.Negate {
+			excludes = append(excludes, expr)
+		} else {
+			includes = append(includes, expr)
+		}
+	}
+	return strings.Join(includes, " & "), strings.Join(excludes, " | ")
+}
+
+var lexemeEscaper = strings.NewReplacer(`\`, `\\`, `'`, `''`)
+
+func quoteLexeme(word string) string {
+	return "'" + lexemeEscaper.Replace(word) + "'"
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike stops LIKE from treating the value's % and _ as wildcards.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+// FuzzyThreshold is the similarity a package name needs to the query to be a fuzzy match, the same as pg_trgm's
+// default similarity_threshold.
+const FuzzyThreshold = 0.3
+
+// SuggestThreshold is the similarity a package name needs to the query to be suggested as a correction.
+const SuggestThreshold = 0.4
+
+// Similarity approximates pg_trgm's similarity: the share of trigrams the two strings have in common, where each
+// lower cased word is padded with two spaces in front and one behind.
+func Similarity(a string, b string) float64 {
+	as, bs := trigrams(a), trigrams(b)
+	if len(as) == 0 || len(bs) == 0 {
+		return 0
+	}
+	common := 0
+	for t := range as {
+		if bs[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(as)+len(bs)-common)
+}
+
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,47 @@
+package store
+
+import "testing"
+
+func TestTSQuery(t *testing.T) {
+	include, exclude := TSQuery([]SearchTerm{
+		{Words: []string{"json"}},
+		{Words: []string{"c++"}},
+		{Words: []string{"vib"}, Prefix: true},
+		{Words: []string{"web", "framework"}},
+		{Words: []string{"it's"}, Negate: true},
+		{Words: []string{`a\b`}, Negate: true},
+	})
+	if want := `'json' & 'c++' & 'vib':* & ('web' <-> 'framework')`; include != want {
+		t.Errorf("expected %s, got %s", want, include)
+	}
+	if want := `'it''s' | 'a\\b'`; exclude != want {
+		t.Errorf("expected %s, got %s", want, exclude)
+	}
+
+	if include, exclude := TSQuery(nil); include != "" || exclude != "" {
+		t.Errorf("expected no terms to give empty queries, got %q and %q", include, exclude)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`100%_sure\`); got != `100\%\_sure\\` {
+		t.Errorf("unexpected escaping: %s", got)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want float64
+	}{
+		{"vibe-d", "vibe-d", 1},
+		{"vibed", "vibe-d", 4.0 / 9},
+		{"mir-algoritm", "mir-algorithm", 11.0 / 16},
+		{"vibe-d", "asdf", 0},
+		{"", "asdf", 0},
+	} {
+		if got := Similarity(test.a, test.b); got < test.want-1e-9 || got > test.want+1e-9 {
+			t.Errorf("%s and %s: expected %f, got %f", test.a, test.b, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,599 @@
+// Package store is the storage layer shared by chwilwr and gwyliwr.
+//
+// Postgres is what runs in production, while Memory mirrors its behaviour closely enough
+// for the handlers and updater to be tested without a database.
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// How long gwyliwr waits between updates of the same package.
+const UpdateInterval = time.Hour * 24 * 7
+
+// Registry is a dub registry that packages are crawled from. Package names are only unique within a registry.
+type Registry struct {
+	ID   int
+	Name string
+	URL  string
+}
+
+type Package struct {
+	ID         int
+	RegistryID int
+	Name       string
+	NextUpdate time.Time
+	// ValidationFailures is how many of the package's snapshots in a row have failed validation by breaking the same rules.
+	ValidationFailures int
+}
+
+type Version struct {
+	ID        int
+	PackageID int
+	Semver    string
+}
+
+// Readme is the README and description a version had when gwyliwr last updated it. Hash identifies the content, so
+// versions whose documentation didn't change have the same hash.
+type Readme struct {
+	VersionID   int
+	Description string
+	Readme      string
+	Hash        string
+	UpdatedAt   time.Time
+}
+
+// ReadmeHash is the hex SHA-256 of a description and README.
+func ReadmeHash(description string, readme string) string {
+	sum := sha256.Sum256([]byte(description + "\x00" + readme))
+	return hex.EncodeToString(sum[:])
+}
+
+type Snapshot struct {
+	ID        int
+	VersionID int
+	// Time is when these metrics were first seen, and ValidTo is when they were last seen unchanged. They're the same
+	// unless change-only storage extended the snapshot. AddSnapshot treats a zero ValidTo as Time.
+	Time             time.Time
+	ValidTo          time.Time
+	DownloadsWeekly  int
+	DownloadsMonthly int
+	DownloadsTotal   int64
+	Stars            int
+	Watchers         int
+	Issues           int
+	Forks            int
+	// FlagReason is set when the snapshot failed validation but was stored anyway.
+	FlagReason string
+	// Mirror is the base URL of the registry the stats were fetched from.
+	Mirror string
+	// Samples is how many stored snapshots covered this one's bucket, and is only set for rollups.
+	Samples int
+}
+
+func (s Snapshot) Flagged() bool {
+	return s.FlagReason != ""
+}
+
+// SameMetrics reports whether both snapshots have identical stats, ignoring when and where they were taken.
+func (s Snapshot) SameMetrics(o Snapshot) bool {
+	return s.DownloadsWeekly == o.DownloadsWeekly &&
+		s.DownloadsMonthly == o.DownloadsMonthly &&
+		s.DownloadsTotal == o.DownloadsTotal &&
+		s.Stars == o.Stars &&
+		s.Watchers == o.Watchers &&
+		s.Issues == o.Issues &&
+		s.Forks == o.Forks
+}
+
+// expandSnapshots reconstructs the regular series from snapshots that cover a range of time, by spreading each one
+// evenly across the number of UpdateIntervals it covers. Points before since, or at or after a non-zero until, are
+// dropped, and the result is sorted oldest first.
+func expandSnapshots(arr []Snapshot, since time.Time, until time.Time) []Snapshot {
+	expanded := make([]Snapshot, 0, len(arr))
+	for _, s := range arr {
+		span := s.ValidTo.Sub(s.Time)
+		steps := 0
+		if span > 0 {
+			steps = int(math.Max(1, math.Round(float64(span)/float64(UpdateInterval))))
+		}
+
+		for i := 0; i <= steps; i++ {
+			point := s
+			if steps > 0 {
+				point.Time = s.Time.Add(span * time.Duration(i) / time.Duration(steps))
+			}
+			point.ValidTo = point.Time
+			if !point.Time.Before(since) && (until.IsZero() || point.Time.Before(until)) {
+				expanded = append(expanded, point)
+			}
+		}
+	}
+	sort.SliceStable(expanded, func(i, j int) bool { return expanded[i].Time.Before(expanded[j].Time) })
+	return expanded
+}
+
+type SnapshotQuery struct {
+	VersionID int
+	// PackageID can be set instead of VersionID to get a single series across every version of the package. Where
+	// the rollups of several versions share a bucket, the newest version's is used and their samples are added up.
+	PackageID int
+	// Only snapshots taken at or after Since are returned. For rollups, this is any bucket that contains Since or later.
+	// Snapshots extended by change-only storage are expanded back into one snapshot per update.
+	Since time.Time
+	// Only snapshots taken before Until are returned, unless it's zero. For rollups, this is any bucket that starts
+	// before Until.
+	Until time.Time
+	// IncludeFlagged is ignored for rollups, which never include flagged snapshots.
+	IncludeFlagged bool
+	// Resolution defaults to Raw.
+	Resolution Resolution
+}
+
+// Resolution is the granularity of a series of snapshots. Anything other than Raw reads from rollups, where each
+// snapshot is the last unflagged snapshot taken within a UTC day, week (starting Monday) or month.
+type Resolution string
+
+const (
+	Raw     Resolution = "raw"
+	Daily   Resolution = "daily"
+	Weekly  Resolution = "weekly"
+	Monthly Resolution = "monthly"
+)
+
+// Rollups lists the resolutions that snapshots are rolled up into.
+var Rollups = []Resolution{Daily, Weekly, Monthly}
+
+// Next returns the start of the bucket after the one starting at bucket.
+func (r Resolution) Next(bucket time.Time) time.Time {
+	switch r {
+	case Daily:
+		return bucket.AddDate(0, 0, 1)
+	case Weekly:
+		return bucket.AddDate(0, 0, 7)
+	}
+	return bucket.AddDate(0, 1, 0)
+}
+
+// Truncate returns the start of the bucket containing t.
+func (r Resolution) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	switch r {
+	case Daily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case Weekly:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t
+}
+
+// Aggregation decides how Aggregate combines the snapshots within a bucket.
+type Aggregation string
+
+const (
+	// AggregateLast keeps the last snapshot in each bucket, the same as the rollups do.
+	AggregateLast Aggregation = "last"
+	AggregateMax  Aggregation = "max"
+	// AggregateAvg averages each metric, rounded to the nearest whole number.
+	AggregateAvg Aggregation = "avg"
+)
+
+// Aggregations lists every Aggregation.
+var Aggregations = []Aggregation{AggregateLast, AggregateMax, AggregateAvg}
+
+// Aggregate combines snapshots, sorted oldest first, into one per bucket of the given resolution, with each one's Time
+// being the start of its bucket. Samples adds up the samples of the snapshots that were combined, counting raw
+// snapshots as one each. Flags aren't carried over, so flagged snapshots should be left out beforehand.
+func Aggregate(arr []Snapshot, resolution Resolution, by Aggregation) []Snapshot {
+	aggregated := make([]Snapshot, 0, len(arr))
+	for start := 0; start < len(arr); {
+		bucket := resolution.Truncate(arr[start].Time)
+		end := start + 1
+		for end < len(arr) && resolution.Truncate(arr[end].Time).Equal(bucket) {
+			end++
+		}
+		aggregated = append(aggregated, aggregateBucket(arr[start:end], bucket, by))
+		start = end
+	}
+	return aggregated
+}
+
+func aggregateBucket(arr []Snapshot, bucket time.Time, by Aggregation) Snapshot {
+	last := arr[len(arr)-1]
+	result := Snapshot{VersionID: last.VersionID, Time: bucket}
+	for _, s := range arr {
+		result.Samples += s.Samples
+		if s.Samples == 0 {
+			result.Samples++
+		}
+	}
+
+	switch by {
+	case AggregateMax:
+		for _, s := range arr {
+			result.DownloadsWeekly = maxInt(result.DownloadsWeekly, s.DownloadsWeekly)
+			result.DownloadsMonthly = maxInt(result.DownloadsMonthly, s.DownloadsMonthly)
+			if s.DownloadsTotal > result.DownloadsTotal {
+				result.DownloadsTotal = s.DownloadsTotal
+			}
+			result.Stars = maxInt(result.Stars, s.Stars)
+			result.Watchers = maxInt(result.Watchers, s.Watchers)
+			result.Issues = maxInt(result.Issues, s.Issues)
+			result.Forks = maxInt(result.Forks, s.Forks)
+		}
+	case AggregateAvg:
+		var weekly, monthly, total, stars, watchers, issues, forks float64
+		for _, s := range arr {
+			weekly += float64(s.DownloadsWeekly)
+			monthly += float64(s.DownloadsMonthly)
+			total += float64(s.DownloadsTotal)
+			stars += float64(s.Stars)
+			watchers += float64(s.Watchers)
+			issues += float64(s.Issues)
+			forks += float64(s.Forks)
+		}
+		n := float64(len(arr))
+		result.DownloadsWeekly = int(math.Round(weekly / n))
+		result.DownloadsMonthly = int(math.Round(monthly / n))
+		result.DownloadsTotal = int64(math.Round(total / n))
+		result.Stars = int(math.Round(stars / n))
+		result.Watchers = int(math.Round(watchers / n))
+		result.Issues = int(math.Round(issues / n))
+		result.Forks = int(math.Round(forks / n))
+	default:
+		result.DownloadsWeekly = last.DownloadsWeekly
+		result.DownloadsMonthly = last.DownloadsMonthly
+		result.DownloadsTotal = last.DownloadsTotal
+		result.Stars = last.Stars
+		result.Watchers = last.Watchers
+		result.Issues = last.Issues
+		result.Forks = last.Forks
+	}
+	return result
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// DailyDownloads is how many times a package was downloaded during a UTC day, derived from the change in its total
+// downloads between snapshots. Snapshots rarely line up with midnight, so each change is spread evenly over the time
+// between the two snapshots, and Coverage is the fraction of the day that's been accounted for so far.
+type DailyDownloads struct {
+	Day       time.Time
+	Downloads float64
+	Coverage  float64
+}
+
+// SpreadDownloads splits the downloads made between from and to across the UTC days they overlap.
+func SpreadDownloads(from time.Time, to time.Time, downloads int64) []DailyDownloads {
+	span := to.Sub(from)
+	if span <= 0 {
+		return nil
+	}
+	if downloads < 0 {
+		downloads = 0
+	}
+
+	var days []DailyDownloads
+	for day := Daily.Truncate(from); day.Before(to); day = Daily.Next(day) {
+		start, end := day, Daily.Next(day)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		overlap := end.Sub(start)
+		days = append(days, DailyDownloads{
+			Day:       day,
+			Downloads: float64(downloads) * float64(overlap) / float64(span),
+			Coverage:  float64(overlap) / float64(time.Hour*24),
+		})
+	}
+	return days
+}
+
+// DownloadsPerDay averages the downloads of the days in [from, to), ignoring any time that isn't covered. It returns
+// false if none of it is.
+func DownloadsPerDay(days []DailyDownloads, from time.Time, to time.Time) (float64, bool) {
+	var downloads, coverage float64
+	for _, d := range days {
+		if !d.Day.Before(from) && d.Day.Before(to) {
+			downloads += d.Downloads
+			coverage += d.Coverage
+		}
+	}
+	if coverage == 0 {
+		return 0, false
+	}
+	return downloads / coverage, true
+}
+
+// Webhook is a subscription to gwyliwr's events. Events lists the event types to deliver, or every type when empty.
+type Webhook struct {
+	ID        int
+	URL       string
+	Secret    string
+	Events    []string
+	CreatedAt time.Time
+}
+
+// Wants reports whether the subscription includes the given event type.
+func (w Webhook) Wants(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// AlertRule watches a metric of every package it matches. Package matches a single package, Filter is a glob such as
+// "vibe-*", and leaving both empty matches every package. Registry limits the rule to one registry when set.
+//
+// "above" and "below" compare the metric to Threshold, while "change_above" and "change_below" compare the percentage
+// it changed by since Window ago, e.g. "change_below" with -50 fires when it has halved.
+type AlertRule struct {
+	ID         int
+	Name       string
+	Registry   string
+	Package    string
+	Filter     string
+	Metric     string
+	Comparison string
+	Threshold  float64
+	Window     time.Duration
+	// Notifications are sent to WebhookURL and Email, whichever are set.
+	WebhookURL string
+	Email      string
+	CreatedAt  time.Time
+}
+
+// IsChange reports whether the rule compares how much the metric changed over its window, rather than the metric itself.
+func (r AlertRule) IsChange() bool {
+	return r.Comparison == "change_above" || r.Comparison == "change_below"
+}
+
+// AlertState is whether a rule is firing for a package, and since when.
+type AlertState struct {
+	RuleID    int
+	PackageID int
+	// Package is only filled in by FiringAlerts.
+	Package string
+	Firing  bool
+	Since   time.Time
+	Value   float64
+}
+
+// SearchSort is the order search results are returned in. Ties are broken by name.
+type SearchSort string
+
+const (
+	// SortRelevance puts the best matches first.
+	SortRelevance SearchSort = "relevance"
+	// SortDownloads and SortStars use the package's most recent unflagged snapshot, putting packages without one last.
+	SortDownloads SearchSort = "downloads"
+	SortStars     SearchSort = "stars"
+	// SortUpdated puts the packages whose newest version was seen most recently first.
+	SortUpdated SearchSort = "updated"
+	SortName    SearchSort = "name"
+)
+
+// SearchSorts lists every SearchSort.
+var SearchSorts = []SearchSort{SortRelevance, SortDownloads, SortStars, SortUpdated, SortName}
+
+// SearchTerm is a word, phrase or prefix that packages are searched for, as parsed from the user's query.
+type SearchTerm struct {
+	// Words are matched as a phrase when there's more than one.
+	Words []string
+	// Prefix matches any word starting with the only word.
+	Prefix bool
+	// Negate excludes packages that match the term.
+	Negate bool
+}
+
+// SearchFilter narrows the search down to packages whose field has the given value, ignoring case.
+type SearchFilter struct {
+	// Field is "name", "author" or "license". A package matches an author filter if any of its authors match.
+	Field  string
+	Value  string
+	Prefix bool
+	Negate bool
+}
+
+type SearchQuery struct {
+	// Query is the text that package names are compared against, which is usually the positive terms' words.
+	Query   string
+	Terms   []SearchTerm
+	Filters []SearchFilter
+	// RegistryID limits the search to one registry, or searches every registry if it's 0.
+	RegistryID int
+	// Sort defaults to SortRelevance.
+	Sort SearchSort
+	// Limit and Offset select a page of results. A Limit of 0 returns every result.
+	Limit  int
+	Offset int
+}
+
+type SearchResult struct {
+	ID       int
+	Name     string
+	Registry string
+	Rank     float64
+	// Downloads and Stars come from the package's most recent unflagged snapshot, and are 0 without one.
+	Downloads int64
+	Stars     int
+	// UpdatedAt is when the package's newest version was first seen.
+	UpdatedAt time.Time
+}
+
+// TopMetric is what packages are ranked by in a leaderboard.
+type TopMetric string
+
+const (
+	TopDownloadsTotal   TopMetric = "downloadsTotal"
+	TopDownloadsMonthly TopMetric = "downloadsMonthly"
+	TopDownloadsWeekly  TopMetric = "downloadsWeekly"
+	TopStars            TopMetric = "stars"
+	TopForks            TopMetric = "forks"
+	// TopDependents counts the packages in the same registry whose latest version depends on the package. Only the
+	// current dependencies are stored, so it has no previous ranking.
+	TopDependents TopMetric = "dependents"
+)
+
+// TopMetrics lists every TopMetric.
+var TopMetrics = []TopMetric{TopDownloadsTotal, TopDownloadsMonthly, TopDownloadsWeekly, TopStars, TopForks, TopDependents}
+
+func validTopMetric(metric TopMetric) bool {
+	for _, m := range TopMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+type TopQuery struct {
+	Metric TopMetric
+	// RegistryID is 0 to rank the packages of every registry together.
+	RegistryID int
+	// Category matches the category and every category under it, so "library" includes "library/web". License is
+	// matched exactly. Both ignore case, and are ignored when empty.
+	Category string
+	License  string
+	// Previous is when the ranking is compared against, using the metrics each package had then. Zero skips it.
+	Previous time.Time
+	Limit    int
+	Offset   int
+}
+
+// TopResult is a package's place on a leaderboard. Packages with the same value share a rank. Every metric other than
+// TopDependents comes from the package's most recent unflagged snapshot, so packages without one aren't ranked by them.
+type TopResult struct {
+	ID       int
+	Name     string
+	Registry string
+	Rank     int
+	Value    int64
+	// PreviousRank and PreviousValue are the package's place at TopQuery.Previous among the packages ranked now, and
+	// are 0 if it wasn't ranked then.
+	PreviousRank  int
+	PreviousValue int64
+}
+
+type Store interface {
+	// EnsureRegistry returns the registry with the given name, creating it or updating its URL as needed.
+	EnsureRegistry(ctx context.Context, name string, url string) (Registry, error)
+	RegistryByName(ctx context.Context, name string) (Registry, error)
+
+	// AddPackage registers a package to be updated as soon as possible, doing nothing if it already exists. Packages
+	// that were marked as removed are restored. It reports whether the package was added or restored.
+	AddPackage(ctx context.Context, registryID int, name string) (bool, error)
+	PackageByName(ctx context.Context, registryID int, name string) (Package, error)
+	// MarkRemoved records that the registry no longer has the package, which stops it from being updated. It reports
+	// whether the package wasn't already marked.
+	MarkRemoved(ctx context.Context, packageID int) (bool, error)
+	// PackagesDue returns every package whose next update time has passed, other than removed packages.
+	PackagesDue(ctx context.Context) ([]Package, error)
+	CountOverdue(ctx context.Context) (int, error)
+	// UpdateSearchText replaces the text that the package is found by in Search.
+	UpdateSearchText(ctx context.Context, packageID int, description string, readme string) error
+	// SetMetadata replaces the package's authors and license, which search can be filtered by.
+	SetMetadata(ctx context.Context, packageID int, authors []string, license string) error
+	// SetCategory records the category the registry lists the package under, which leaderboards can be filtered by.
+	SetCategory(ctx context.Context, registryID int, name string, category string) error
+	// SetDependencies replaces the names of the packages that the package's latest version depends on.
+	SetDependencies(ctx context.Context, packageID int, names []string) error
+	// RecordValidationFailure counts the package's snapshots in a row that failed validation by breaking the same
+	// rules, returning the count including this one. Empty rules reset the count.
+	RecordValidationFailure(ctx context.Context, packageID int, rules string) (int, error)
+	// SetReadme stores a version's README and description, filling in the hash. It does nothing when the stored hash
+	// already matches, and reports whether anything changed.
+	SetReadme(ctx context.Context, r Readme) (bool, error)
+	// Readme returns ErrNotFound if the version's README hasn't been stored yet.
+	Readme(ctx context.Context, versionID int) (Readme, error)
+	// BumpUpdateTime schedules the package's next update for UpdateInterval from now.
+	BumpUpdateTime(ctx context.Context, packageID int) error
+
+	// EnsureVersion returns the given version of a package, creating it if needed. The version must be a valid semantic
+	// version or a branch, as understood by the semver package.
+	EnsureVersion(ctx context.Context, packageID int, semver string) (Version, error)
+	FindVersion(ctx context.Context, packageID int, semver string) (Version, error)
+	// LatestVersion returns the package's highest stable version by semver precedence, or its highest version whether
+	// or not it's a prerelease if includePrerelease is set. Prereleases are still used when nothing stable exists, and
+	// packages without any releases fall back to their most recently added branch.
+	LatestVersion(ctx context.Context, packageID int, includePrerelease bool) (Version, error)
+	// LatestPrerelease returns the package's highest prerelease by semver precedence, even if a newer stable version
+	// has been released since.
+	LatestPrerelease(ctx context.Context, packageID int) (Version, error)
+	// Versions returns every version of the package, in the order they were first seen.
+	Versions(ctx context.Context, packageID int) ([]Version, error)
+
+	AddSnapshot(ctx context.Context, snapshot Snapshot) error
+	// ExtendSnapshot records that a snapshot's metrics were still unchanged at the given time.
+	ExtendSnapshot(ctx context.Context, snapshotID int, to time.Time) error
+	// Snapshots returns the snapshots matching the query, oldest first.
+	Snapshots(ctx context.Context, query SnapshotQuery) ([]Snapshot, error)
+	// LastSnapshot returns the most recent unflagged snapshot of any version of a package.
+	LastSnapshot(ctx context.Context, packageID int) (Snapshot, error)
+	// SnapshotAt returns the most recent unflagged snapshot of any version of a package that was taken at or before the given time.
+	SnapshotAt(ctx context.Context, packageID int, at time.Time) (Snapshot, error)
+	// Rollup brings the rollups of the given resolution up to date with the raw snapshots, returning how many buckets
+	// were written. Only the latest existing bucket and anything after it are recalculated. A snapshot counts towards
+	// every bucket it was valid during.
+	Rollup(ctx context.Context, resolution Resolution) (int64, error)
+	// AddDailyDownloads adds to the package's derived download counts, see DailyDownloads.
+	AddDailyDownloads(ctx context.Context, packageID int, days []DailyDownloads) error
+	// DailyDownloads returns the package's derived download counts from the day containing since onwards, oldest first.
+	DailyDownloads(ctx context.Context, packageID int, since time.Time) ([]DailyDownloads, error)
+	// PruneSnapshots deletes raw snapshots that were last seen before the given time, returning how many were deleted.
+	PruneSnapshots(ctx context.Context, before time.Time) (int64, error)
+
+	AddWebhook(ctx context.Context, webhook Webhook) (Webhook, error)
+	Webhooks(ctx context.Context) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+
+	AddAlertRule(ctx context.Context, rule AlertRule) (AlertRule, error)
+	AlertRules(ctx context.Context) ([]AlertRule, error)
+	// DeleteAlertRule deletes the rule along with its state.
+	DeleteAlertRule(ctx context.Context, id int) error
+	// AlertState returns ErrNotFound if the rule has never been evaluated for the package.
+	AlertState(ctx context.Context, ruleID int, packageID int) (AlertState, error)
+	SetAlertState(ctx context.Context, state AlertState) error
+	// FiringAlerts returns the state of every rule that's firing for a package, oldest first.
+	FiringAlerts(ctx context.Context) ([]AlertState, error)
+
+	// Search returns a page of the packages matching the query, along with how many matched in total.
+	Search(ctx context.Context, q SearchQuery) ([]SearchResult, int, error)
+	// Suggest returns the package name most similar to the query, as a likely correction of a typo. Nothing is
+	// suggested if a package already has that name, ignoring case, or none are similar enough.
+	Suggest(ctx context.Context, registryID int, query string) (string, error)
+	// Autocomplete returns up to limit packages whose name starts with the prefix, ignoring case, most downloaded first.
+	// Only the ID, Name, Registry, Downloads and Stars of each result are filled in.
+	Autocomplete(ctx context.Context, registryID int, prefix string, limit int) ([]SearchResult, error)
+	// Top returns a page of the leaderboard described by the query, along with how many packages are on it.
+	Top(ctx context.Context, q TopQuery) ([]TopResult, int, error)
+}
+
+var (
+	_ Store = (*Postgres)(nil)
+	_ Store = (*Memory)(nil)
+)
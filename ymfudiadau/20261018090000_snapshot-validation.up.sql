@@ -0,0 +1,2 @@
+-- Snapshots that fail gwyliwr's validation rules are kept, but with the reason(s) recorded here.
+ALTER TABLE package_snapshot ADD COLUMN flag_reason TEXT;
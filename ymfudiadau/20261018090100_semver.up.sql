@@ -0,0 +1,42 @@
+-- Versions are now parsed when gwyliwr stores them, so they can be ordered properly instead of by insertion order.
+-- Branch versions (e.g. ~master) have is_branch set and no other components.
+ALTER TABLE package_version
+    ADD COLUMN major      INT,
+    ADD COLUMN minor      INT,
+    ADD COLUMN patch      INT,
+    ADD COLUMN prerelease VARCHAR(128),
+    ADD COLUMN build      VARCHAR(128),
+    ADD COLUMN is_branch  BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE package_version SET is_branch = TRUE WHERE semver LIKE '~%';
+
+UPDATE package_version AS v SET
+    major      = parsed.m[1]::INT,
+    minor      = parsed.m[2]::INT,
+    patch      = parsed.m[3]::INT,
+    prerelease = parsed.m[4],
+    build      = parsed.m[5]
+FROM (
+    SELECT id, regexp_match(semver, '^(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$') AS m
+    FROM package_version
+) AS parsed
+WHERE v.id = parsed.id AND parsed.m IS NOT NULL;
+
+-- semver_prerelease_key turns a prerelease into text that sorts by semver precedence under the "C" collation.
+-- Numeric identifiers are prefixed with '0' and their length so they sort numerically and before alphanumeric ones,
+-- which are prefixed with '1'. Identifiers are joined by \x01 so that a shorter set of identifiers sorts first.
+-- Releases have a NULL key, so still need sorting above their prereleases separately.
+CREATE FUNCTION semver_prerelease_key(in prerelease text) RETURNS text
+AS $$
+    SELECT string_agg(
+        CASE
+            WHEN part ~ '^[0-9]+$' THEN '0' || lpad(length(part)::TEXT, 3, '0') || part
+            ELSE '1' || part
+        END,
+        E'\x01' ORDER BY n
+    )
+    FROM unnest(string_to_array(prerelease, '.')) WITH ORDINALITY AS t(part, n);
+$$
+LANGUAGE SQL IMMUTABLE;
+
+CREATE INDEX ON package_version(package_id, major DESC, minor DESC, patch DESC);
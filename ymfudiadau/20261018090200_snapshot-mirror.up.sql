@@ -0,0 +1,2 @@
+-- The base URL of the registry mirror each snapshot's stats came from. Snapshots from before mirrors were supported leave this NULL.
+ALTER TABLE package_snapshot ADD COLUMN registry_mirror TEXT;
@@ -0,0 +1,43 @@
+-- Packages now belong to a registry, with code.dlang.org being the one every existing package came from.
+-- gwyliwr adds any other registries it's configured with on startup.
+CREATE TABLE registry(
+    id      SERIAL PRIMARY KEY,
+    name    VARCHAR(64) NOT NULL UNIQUE,
+    url     TEXT NOT NULL
+);
+INSERT INTO registry(name, url) VALUES ('dub', 'https://code.dlang.org');
+
+ALTER TABLE package ADD COLUMN registry_id INTEGER;
+UPDATE package SET registry_id = (SELECT id FROM registry WHERE name = 'dub');
+ALTER TABLE package
+    ALTER COLUMN registry_id SET NOT NULL,
+    ADD CONSTRAINT fk_package_registry_id FOREIGN KEY(registry_id) REFERENCES registry(id);
+
+-- package.name was never unique, so refreshing the package list has been adding duplicates rather than hitting the
+-- ON CONFLICT clause. Merge each duplicate into the oldest package of the same name before enforcing uniqueness.
+CREATE TEMPORARY TABLE package_duplicate AS
+    SELECT p.id AS old_id, keep.id AS new_id
+    FROM package p
+    JOIN (SELECT name, MIN(id) AS id FROM package GROUP BY name) AS keep ON keep.name = p.name
+    WHERE p.id <> keep.id;
+
+-- Snapshots of a version that the kept package also has are moved onto the kept package's version...
+UPDATE package_snapshot AS s SET package_version_id = kept.id
+FROM package_version v
+JOIN package_duplicate d ON d.old_id = v.package_id
+JOIN package_version kept ON kept.package_id = d.new_id AND kept.semver = v.semver
+WHERE s.package_version_id = v.id;
+
+DELETE FROM package_version AS v
+USING package_duplicate d, package_version kept
+WHERE v.package_id = d.old_id AND kept.package_id = d.new_id AND kept.semver = v.semver;
+
+-- ...while any other versions are moved over as they are.
+UPDATE package_version AS v SET package_id = d.new_id
+FROM package_duplicate d
+WHERE v.package_id = d.old_id;
+
+DELETE FROM package AS p USING package_duplicate d WHERE p.id = d.old_id;
+DROP TABLE package_duplicate;
+
+ALTER TABLE package ADD CONSTRAINT package_registry_id_name_key UNIQUE(registry_id, name);
@@ -0,0 +1,29 @@
+-- Rollups of package_snapshot, maintained by gwyliwr's maintain command so raw snapshots can be deleted after a while.
+-- Each row holds the last unflagged snapshot taken within its UTC day, week (starting Monday), or month, along with
+-- how many snapshots were taken in that time.
+CREATE TABLE package_snapshot_daily(
+    package_version_id  INTEGER NOT NULL,
+    bucket              TIMESTAMP WITH TIME ZONE NOT NULL,
+    samples             INTEGER NOT NULL,
+    downloads_weekly    INTEGER NOT NULL,
+    downloads_monthly   INTEGER NOT NULL,
+    downloads_total     BIGINT NOT NULL,
+    stars               INTEGER NOT NULL,
+    watchers            INTEGER NOT NULL,
+    issues              INTEGER NOT NULL,
+    forks               INTEGER NOT NULL,
+
+    PRIMARY KEY(package_version_id, bucket),
+    CONSTRAINT fk_package_snapshot_daily_package_version_id FOREIGN KEY(package_version_id) REFERENCES package_version(id)
+);
+
+CREATE TABLE package_snapshot_weekly(LIKE package_snapshot_daily INCLUDING ALL);
+ALTER TABLE package_snapshot_weekly
+    ADD CONSTRAINT fk_package_snapshot_weekly_package_version_id FOREIGN KEY(package_version_id) REFERENCES package_version(id);
+
+CREATE TABLE package_snapshot_monthly(LIKE package_snapshot_daily INCLUDING ALL);
+ALTER TABLE package_snapshot_monthly
+    ADD CONSTRAINT fk_package_snapshot_monthly_package_version_id FOREIGN KEY(package_version_id) REFERENCES package_version(id);
+
+-- Rollups and pruning both look snapshots up by time.
+CREATE INDEX ON package_snapshot(time);
@@ -0,0 +1,13 @@
+-- A snapshot row now covers a range of time rather than a single moment: the metrics were first seen at valid_from,
+-- and were still the same when last checked at valid_to. Rows written on every update have both set to the same
+-- time, while gwyliwr's "changes" storage mode extends valid_to instead of writing identical rows.
+ALTER TABLE package_snapshot RENAME COLUMN time TO valid_from;
+ALTER TABLE package_snapshot ADD COLUMN valid_to TIMESTAMP WITH TIME ZONE;
+UPDATE package_snapshot SET valid_to = valid_from;
+ALTER TABLE package_snapshot
+    ALTER COLUMN valid_to SET NOT NULL,
+    ADD CONSTRAINT package_snapshot_valid_range CHECK (valid_to >= valid_from);
+
+-- Pruning and rollups look at the end of each range, and the start is used to find a version's latest row.
+CREATE INDEX ON package_snapshot(valid_to);
+CREATE INDEX ON package_snapshot(package_version_id, valid_from);
@@ -0,0 +1,46 @@
+-- Downloads per UTC day, derived by gwyliwr from the change in downloads_total between a package's consecutive
+-- unflagged snapshots. Each change is spread evenly over the time between the two snapshots, and coverage is the
+-- fraction of the day that's been accounted for so far, so downloads / coverage is the rate for that day.
+CREATE TABLE package_downloads_daily(
+    package_id  INTEGER NOT NULL,
+    day         TIMESTAMP WITH TIME ZONE NOT NULL,
+    downloads   DOUBLE PRECISION NOT NULL,
+    coverage    DOUBLE PRECISION NOT NULL,
+
+    PRIMARY KEY(package_id, day),
+    CONSTRAINT fk_package_downloads_daily_package_id FOREIGN KEY(package_id) REFERENCES package(id)
+);
+
+-- Backfill from the snapshots taken so far. Each snapshot is paired with the package's previous one, from when that
+-- was last seen unchanged, and totals that went backwards count as no downloads.
+INSERT INTO package_downloads_daily(package_id, day, downloads, coverage)
+SELECT
+    pairs.package_id,
+    d.day AT TIME ZONE 'UTC',
+    SUM(GREATEST(pairs.downloads_total - pairs.prev_total, 0) * o.overlap / EXTRACT(EPOCH FROM pairs.valid_from - pairs.prev_to)),
+    SUM(o.overlap / 86400)
+FROM (
+    SELECT
+        v.package_id,
+        s.valid_from,
+        s.downloads_total,
+        LAG(s.valid_to) OVER w AS prev_to,
+        LAG(s.downloads_total) OVER w AS prev_total
+    FROM package_snapshot s
+    JOIN package_version v ON v.id = s.package_version_id
+    WHERE s.flag_reason IS NULL
+    WINDOW w AS (PARTITION BY v.package_id ORDER BY s.valid_from)
+) AS pairs
+CROSS JOIN LATERAL generate_series(
+    date_trunc('day', pairs.prev_to AT TIME ZONE 'UTC'),
+    date_trunc('day', pairs.valid_from AT TIME ZONE 'UTC'),
+    INTERVAL '1 day'
+) AS d(day)
+CROSS JOIN LATERAL (
+    SELECT EXTRACT(EPOCH FROM
+        LEAST(pairs.valid_from, (d.day + INTERVAL '1 day') AT TIME ZONE 'UTC') -
+        GREATEST(pairs.prev_to, d.day AT TIME ZONE 'UTC')
+    ) AS overlap
+) AS o
+WHERE pairs.valid_from > pairs.prev_to AND o.overlap > 0
+GROUP BY pairs.package_id, d.day;
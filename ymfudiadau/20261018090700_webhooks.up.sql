@@ -0,0 +1,12 @@
+-- Subscriptions to gwyliwr's events, managed through chwilwr. Each delivery is signed with the subscription's secret,
+-- and an empty events array subscribes to every event type.
+CREATE TABLE webhook(
+    id          SERIAL PRIMARY KEY,
+    url         TEXT NOT NULL,
+    secret      TEXT NOT NULL,
+    events      TEXT[] NOT NULL DEFAULT '{}',
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Set once the registry stops knowing about a package, which stops gwyliwr from updating it until it's listed again.
+ALTER TABLE package ADD COLUMN removed_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,31 @@
+-- Alert rules, managed through chwilwr and evaluated by gwyliwr after each package update. Empty strings stand for
+-- settings that aren't used, e.g. a rule with neither a package nor a filter applies to every package.
+CREATE TABLE alert_rule(
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL,
+    registry        TEXT NOT NULL DEFAULT '',
+    package         TEXT NOT NULL DEFAULT '',
+    filter          TEXT NOT NULL DEFAULT '',
+    metric          TEXT NOT NULL,
+    comparison      TEXT NOT NULL,
+    threshold       DOUBLE PRECISION NOT NULL,
+    window_seconds  BIGINT NOT NULL DEFAULT 0,
+    webhook_url     TEXT NOT NULL DEFAULT '',
+    email           TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Whether each rule is firing for each package it has been evaluated against, so notifications are only sent when
+-- that changes.
+CREATE TABLE alert_state(
+    alert_rule_id   INTEGER NOT NULL,
+    package_id      INTEGER NOT NULL,
+    firing          BOOLEAN NOT NULL,
+    since           TIMESTAMP WITH TIME ZONE NOT NULL,
+    value           DOUBLE PRECISION NOT NULL,
+
+    PRIMARY KEY(alert_rule_id, package_id),
+    CONSTRAINT fk_alert_state_alert_rule_id FOREIGN KEY(alert_rule_id) REFERENCES alert_rule(id) ON DELETE CASCADE,
+    CONSTRAINT fk_alert_state_package_id FOREIGN KEY(package_id) REFERENCES package(id)
+);
+CREATE INDEX ON alert_state(since) WHERE firing;
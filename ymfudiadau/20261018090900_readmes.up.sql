@@ -0,0 +1,11 @@
+-- The README and description of each version as gwyliwr last saw them. The hash lets unchanged documentation be
+-- skipped without comparing the text, and shows which versions share the same documentation.
+CREATE TABLE package_version_readme(
+    package_version_id  INTEGER PRIMARY KEY,
+    description         TEXT NOT NULL,
+    readme              TEXT NOT NULL,
+    hash                TEXT NOT NULL,
+    updated_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+
+    CONSTRAINT fk_package_version_readme_package_version_id FOREIGN KEY(package_version_id) REFERENCES package_version(id)
+);
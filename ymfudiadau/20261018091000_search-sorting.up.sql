@@ -0,0 +1,7 @@
+-- When each version was first seen, so search can sort packages by their newest version. Existing versions are
+-- backfilled from their earliest snapshot, falling back to now for versions without any.
+ALTER TABLE package_version ADD COLUMN created_at TIMESTAMP WITH TIME ZONE;
+UPDATE package_version pv
+SET created_at = COALESCE((SELECT MIN(s.valid_from) FROM package_snapshot s WHERE s.package_version_id = pv.id), now());
+ALTER TABLE package_version ALTER COLUMN created_at SET NOT NULL, ALTER COLUMN created_at SET DEFAULT now();
+CREATE INDEX ON package_version(package_id, created_at);
@@ -0,0 +1,65 @@
+-- Authors and licenses come from each package's recipe, so search can be filtered by them.
+ALTER TABLE package ADD COLUMN authors TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE package ADD COLUMN license TEXT NOT NULL DEFAULT '';
+CREATE INDEX ON package(lower(license));
+
+-- search_packages used to hand the user's text straight to to_tsquery, so anything that wasn't valid tsquery syntax
+-- was an error. Chwilwr now parses the query itself and passes:
+--   name_query     the plain words, which package names are compared against as before.
+--   include_query  tsquery text that packages must match. Every word in it has been quoted.
+--   exclude_query  tsquery text that packages mustn't match.
+-- Either tsquery can be empty. When name_query and include_query both are, every package matches so that filters
+-- alone can narrow the results down.
+DROP FUNCTION search_packages(text);
+
+CREATE FUNCTION search_packages(in name_query text, in include_query text, in exclude_query text) RETURNS TABLE(id int, name text, rank real)
+AS $$
+    SELECT
+        id, name, SUM(rank)::real AS rank
+    FROM
+    (
+        SELECT
+            id, name, 10 AS rank
+        FROM
+            package
+        WHERE
+            name_query <> '' AND name = name_query
+        UNION ALL
+        (
+            SELECT
+                id, name, 1 AS rank
+            FROM
+                package
+            WHERE
+                name_query <> ''
+                AND (
+                    name LIKE (replace(replace(replace(name_query, '\', '\\'), '%', '\%'), '_', '\_') || '%')
+                    OR
+                    name LIKE ('%' || replace(replace(replace(name_query, '\', '\\'), '%', '\%'), '_', '\_'))
+                )
+        )
+        UNION ALL
+        (
+            SELECT
+                id, name, ts_rank_cd(query_vector, to_tsquery(include_query)) AS rank
+            FROM
+                package
+            WHERE
+                include_query <> '' AND query_vector @@ to_tsquery(include_query)
+        )
+        UNION ALL
+        (
+            SELECT
+                id, name, 0 AS rank
+            FROM
+                package
+            WHERE
+                name_query = '' AND include_query = ''
+        )
+    ) AS matches
+    WHERE
+        exclude_query = ''
+        OR NOT EXISTS (SELECT 1 FROM package p WHERE p.id = matches.id AND p.query_vector @@ to_tsquery(exclude_query))
+    GROUP BY id, name;
+$$
+LANGUAGE SQL STABLE;
@@ -0,0 +1,66 @@
+-- Trigram matching on package names, so typos like "vibed" still find vibe-d.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX ON package USING gin(name gin_trgm_ops);
+
+-- The same as before, except that names similar enough to name_query (by pg_trgm's similarity_threshold, 0.3 unless
+-- changed) score their similarity, which is at most 1. Exact matches already score 10 so they don't get it on top.
+CREATE OR REPLACE FUNCTION search_packages(in name_query text, in include_query text, in exclude_query text) RETURNS TABLE(id int, name text, rank real)
+AS $$
+    SELECT
+        id, name, SUM(rank)::real AS rank
+    FROM
+    (
+        SELECT
+            id, name, 10 AS rank
+        FROM
+            package
+        WHERE
+            name_query <> '' AND name = name_query
+        UNION ALL
+        (
+            SELECT
+                id, name, 1 AS rank
+            FROM
+                package
+            WHERE
+                name_query <> ''
+                AND (
+                    name LIKE (replace(replace(replace(name_query, '\', '\\'), '%', '\%'), '_', '\_') || '%')
+                    OR
+                    name LIKE ('%' || replace(replace(replace(name_query, '\', '\\'), '%', '\%'), '_', '\_'))
+                )
+        )
+        UNION ALL
+        (
+            SELECT
+                id, name, similarity(name, name_query) AS rank
+            FROM
+                package
+            WHERE
+                name_query <> '' AND name % name_query AND name <> name_query
+        )
+        UNION ALL
+        (
+            SELECT
+                id, name, ts_rank_cd(query_vector, to_tsquery(include_query)) AS rank
+            FROM
+                package
+            WHERE
+                include_query <> '' AND query_vector @@ to_tsquery(include_query)
+        )
+        UNION ALL
+        (
+            SELECT
+                id, name, 0 AS rank
+            FROM
+                package
+            WHERE
+                name_query = '' AND include_query = ''
+        )
+    ) AS matches
+    WHERE
+        exclude_query = ''
+        OR NOT EXISTS (SELECT 1 FROM package p WHERE p.id = matches.id AND p.query_vector @@ to_tsquery(exclude_query))
+    GROUP BY id, name;
+$$
+LANGUAGE SQL STABLE;
@@ -0,0 +1,6 @@
+-- Prefix matches on the lower cased name, for autocomplete. text_pattern_ops lets LIKE 'prefix%' use the index
+-- whatever the database's collation is.
+CREATE INDEX ON package(lower(name) text_pattern_ops);
+
+-- Autocomplete ranks by each package's latest unflagged snapshot, which this keeps to an index lookup per package.
+CREATE INDEX ON package_snapshot(package_version_id, valid_to DESC) WHERE flag_reason IS NULL;
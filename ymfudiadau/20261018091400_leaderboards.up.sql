@@ -0,0 +1,7 @@
+-- Leaderboards can be narrowed to a category from the registry's listing, such as library/gamedev.
+ALTER TABLE package ADD COLUMN category TEXT;
+CREATE INDEX ON package(lower(category) text_pattern_ops);
+
+-- The names of the packages each package's latest version depends on, for ranking by number of dependents.
+ALTER TABLE package ADD COLUMN dependencies TEXT[] NOT NULL DEFAULT '{}';
+CREATE INDEX ON package USING gin(dependencies);
@@ -0,0 +1,4 @@
+-- How many of a package's snapshots in a row have failed validation by breaking the same rules, so that a registry's
+-- permanent correction is eventually accepted rather than rejected or flagged forever.
+ALTER TABLE package ADD COLUMN validation_failures INT NOT NULL DEFAULT 0;
+ALTER TABLE package ADD COLUMN validation_failed_rules TEXT NOT NULL DEFAULT '';